@@ -0,0 +1,262 @@
+// Command indexctl looks at the index of the indexer from the command line. It reads the
+// index file the indexer writes and never writes it, so it can run next to the indexer.
+//
+//	indexctl [config flags] <command> [flags]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/TechMDW/indexing/internal/graceful"
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/indexing"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(opts indexing.Options, args []string) error
+}
+
+var commands = []command{
+	{"duplicates", "list files with the same content", duplicates},
+	{"search", "search the index, and explain the scores with -explain", search},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	opts, err := indexing.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for n := range commands {
+		if commands[n].name == flag.Arg(0) {
+			cmd = &commands[n]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(opts, flag.Args()[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openIndex loads the index file without ever writing it
+func openIndex(opts indexing.Options) (*indexing.Index, error) {
+	opts.ReadOnly = true
+
+	// The indexer logs what it loads, which is noise here
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	idx, err := indexing.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := idx.LoadFileIndex(); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no index at %s, the indexer has not stored one yet", idx.StoragePath())
+		}
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: indexctl [config flags] <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun indexctl <command> -h for the flags of a command.\n")
+}
+
+// listFlag is a flag that can be given more than once, or with values separated by commas
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+func duplicates(opts indexing.Options, args []string) error {
+	fs := flag.NewFlagSet("duplicates", flag.ExitOnError)
+
+	var roots, exts listFlag
+	fs.Var(&roots, "root", "only files below this path, can be given more than once")
+	fs.Var(&exts, "ext", "only files with these comma separated extensions")
+	minSize := fs.String("minSize", "1", `only files of at least this size, like "1MB"`)
+	algorithm := fs.String("algorithm", string(hash.SHA256), "digest the files are compared by")
+	asJSON := fs.Bool("json", false, "print the groups as JSON")
+	fs.Parse(args)
+
+	size, err := indexing.ParseSize(*minSize)
+	if err != nil {
+		return fmt.Errorf("-minSize: %w", err)
+	}
+
+	alg, err := hash.ParseAlgorithm(*algorithm)
+	if err != nil {
+		return fmt.Errorf("-algorithm: %w", err)
+	}
+
+	idx, err := openIndex(opts)
+	if err != nil {
+		return err
+	}
+
+	g := graceful.Shutdown()
+
+	groups, err := idx.Duplicates(g.Context(), indexing.DuplicateOptions{
+		Roots:      roots,
+		Extensions: exts,
+		MinSize:    size,
+		Algorithm:  alg,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(groups)
+	}
+
+	var total int64
+	for _, group := range groups {
+		fmt.Printf("%d files of %s, %s reclaimable (%s %s)\n", len(group.Paths), formatSize(group.Size), formatSize(group.Reclaimable), group.Algorithm, group.Hash)
+		for _, path := range group.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+		fmt.Println()
+		total += group.Reclaimable
+	}
+
+	fmt.Printf("%d groups of duplicates, %s reclaimable\n", len(groups), formatSize(total))
+	return nil
+}
+
+func search(opts indexing.Options, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: indexctl search [flags] <query>\n\nStart the query with glob: or regex: for a pattern.\n\n")
+		fs.PrintDefaults()
+	}
+
+	limit := fs.Int("limit", 0, "number of results, maxResults of the config when 0")
+	sortBy := fs.String("sort", "", "order of the results, one of relevance, modified, size, name, path, ext and recent")
+	reverse := fs.Bool("reverse", false, "sort the other way around")
+	profile := fs.String("profile", "", "scoring profile of the config, the default one when empty")
+	explain := fs.Bool("explain", false, "explain the score of every result by the rules that gave it")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	mode, q := indexing.SplitSearchMode(strings.Join(fs.Args(), " "))
+	searchOpts := indexing.SearchOptions{Mode: mode, Limit: *limit, Reverse: *reverse, Profile: *profile}
+	if *sortBy != "" {
+		key, err := indexing.ParseSortKey(*sortBy)
+		if err != nil {
+			return fmt.Errorf("-sort: %w", err)
+		}
+		searchOpts.Sort = key
+	}
+
+	idx, err := openIndex(opts)
+	if err != nil {
+		return err
+	}
+
+	g := graceful.Shutdown()
+
+	// Searches log how long they took
+	log.SetOutput(io.Discard)
+	files, err := idx.SearchWith(g.Context(), q, searchOpts)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		Path        string                `json:"path"`
+		Score       int                   `json:"score"`
+		Explanation *indexing.Explanation `json:"explanation,omitempty"`
+	}
+
+	results := make([]result, 0, len(files))
+	for _, f := range files {
+		r := result{Path: f.FullPath, Score: f.Internal_metadata.Score}
+		if *explain {
+			if r.Explanation, err = idx.Explain(g.Context(), q, f.FullPath, searchOpts); err != nil {
+				return err
+			}
+		}
+		results = append(results, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, r := range results {
+		if r.Explanation != nil {
+			fmt.Println(r.Explanation)
+			continue
+		}
+		fmt.Printf("%5d  %s\n", r.Score, r.Path)
+	}
+	return nil
+}
+
+// formatSize formats bytes like "1.5 MiB"
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
+	"github.com/TechMDW/indexing/internal/graceful"
 	"github.com/TechMDW/indexing/internal/indexing"
 
 	"github.com/asticode/go-astikit"
@@ -19,6 +21,24 @@ import (
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	opts, err := indexing.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	idx, err := indexing.New(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := graceful.Shutdown()
+	if err := idx.Start(g.Context()); err != nil {
+		log.Fatal(err)
+	}
+
 	a, _ := astilectron.New(nil, astilectron.Options{
 		AppName: "Indexer",
 	})
@@ -28,16 +48,21 @@ func main() {
 	// Start astilectron
 	a.Start()
 
-	startWindow(a)
-
-	_, err := indexing.GetIndexInstance()
+	// Close the window on SIGINT/SIGTERM so we get past Wait
+	go func() {
+		<-g.Context().Done()
+		a.Stop()
+	}()
 
-	if err != nil {
-		log.Fatal(err)
-	}
+	startWindow(a, idx)
 
 	a.Wait()
 
+	// Stores the index before exiting
+	if err := idx.Stop(); err != nil {
+		log.Println(err)
+	}
+
 	go func() {
 		// get any keypress
 		bufio.NewReader(os.Stdin).ReadByte()
@@ -62,7 +87,7 @@ func startHook(w *astilectron.Window) {
 	<-hook.Process(start)
 }
 
-func startWindow(a *astilectron.Astilectron) {
+func startWindow(a *astilectron.Astilectron, idx *indexing.Index) {
 	w, err := a.NewWindow("./page/home.html", &astilectron.WindowOptions{
 		Center:      astikit.BoolPtr(true),
 		Height:      astikit.IntPtr(600),
@@ -77,16 +102,11 @@ func startWindow(a *astilectron.Astilectron) {
 
 	w.Create()
 	w.OpenDevTools()
-	listenForInput(w)
+	listenForInput(w, idx)
 	startHook(w)
 }
 
-func listenForInput(w *astilectron.Window) {
-	idx, err := indexing.GetIndexInstance()
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func listenForInput(w *astilectron.Window, idx *indexing.Index) {
 	w.OnMessage(func(m *astilectron.EventMessage) interface{} {
 		// Unmarshal
 		var s string
@@ -95,7 +115,11 @@ func listenForInput(w *astilectron.Window) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		files := idx.Search(ctx, s)
+		mode, q := indexing.SplitSearchMode(s)
+		files, err := idx.SearchWith(ctx, q, indexing.SearchOptions{Mode: mode})
+		if err != nil {
+			log.Println(err)
+		}
 
 		w.SendMessage(files)
 		return nil
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"runtime"
 	"time"
 
+	"github.com/TechMDW/indexing/internal/graceful"
 	"github.com/TechMDW/indexing/internal/indexing"
 
 	"github.com/asticode/go-astikit"
@@ -16,6 +18,24 @@ import (
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	opts, err := indexing.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	idx, err := indexing.New(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := graceful.Shutdown()
+	if err := idx.Start(g.Context()); err != nil {
+		log.Fatal(err)
+	}
+
 	a, _ := astilectron.New(nil, astilectron.Options{
 		AppName: "Indexer",
 	})
@@ -25,13 +45,13 @@ func main() {
 	// Start astilectron
 	a.Start()
 
-	go startWindow(a)
-
-	_, err := indexing.GetIndexInstance()
+	// Close the window on SIGINT/SIGTERM so we get past Wait
+	go func() {
+		<-g.Context().Done()
+		a.Stop()
+	}()
 
-	if err != nil {
-		log.Fatal(err)
-	}
+	go startWindow(a, idx)
 
 	go func() {
 		for {
@@ -41,9 +61,14 @@ func main() {
 	}()
 
 	a.Wait()
+
+	// Stores the index before exiting
+	if err := idx.Stop(); err != nil {
+		log.Println(err)
+	}
 }
 
-func startWindow(a *astilectron.Astilectron) {
+func startWindow(a *astilectron.Astilectron, idx *indexing.Index) {
 	w, err := a.NewWindow("./page/home.html", &astilectron.WindowOptions{
 		Center:      astikit.BoolPtr(true),
 		MinHeight:   astikit.IntPtr(80),
@@ -58,15 +83,10 @@ func startWindow(a *astilectron.Astilectron) {
 
 	w.Create()
 	w.OpenDevTools()
-	listenForInput(w)
+	listenForInput(w, idx)
 }
 
-func listenForInput(w *astilectron.Window) {
-	idx, err := indexing.GetIndexInstance()
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func listenForInput(w *astilectron.Window, idx *indexing.Index) {
 	w.OnMessage(func(m *astilectron.EventMessage) interface{} {
 		// Unmarshal
 		var s string
@@ -75,7 +95,11 @@ func listenForInput(w *astilectron.Window) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		files := idx.Search(ctx, s)
+		mode, q := indexing.SplitSearchMode(s)
+		files, err := idx.SearchWith(ctx, q, indexing.SearchOptions{Mode: mode})
+		if err != nil {
+			log.Println(err)
+		}
 
 		w.SendMessage(files)
 		return nil
@@ -13,5 +13,6 @@ require github.com/vcaesar/keycode v0.10.0 // indirect
 require (
 	github.com/pierrec/lz4/v4 v4.1.17
 	github.com/robotn/gohook v0.40.0
+	github.com/ulikunitz/xz v0.5.11
 	golang.org/x/sys v0.8.0
 )
@@ -0,0 +1,228 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Separator is between the path of an archive and the path of an entry in it, like
+// "/docs/a.zip!/dir/file.txt". An archive in an archive adds another, "a.zip!/b.tar!/c.txt".
+const Separator = "!/"
+
+var (
+	// ErrLimit is returned when an archive has more entries or expands to more bytes than
+	// the limits allow, the entries found before it was hit are still walked
+	ErrLimit = errors.New("archive limit exceeded")
+
+	// ErrUnsupported is returned for a file that is not an archive of a known format
+	ErrUnsupported = errors.New("not a supported archive")
+)
+
+// Format of an archive, the compression of a tar archive is part of it
+type Format int
+
+const (
+	Unknown Format = iota
+	Zip
+	Tar
+	TarGzip
+	TarBzip2
+	TarXz
+)
+
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case TarGzip:
+		return "tar.gz"
+	case TarBzip2:
+		return "tar.bz2"
+	case TarXz:
+		return "tar.xz"
+	}
+	return "unknown"
+}
+
+// Extensions of the archive formats, the longest are first so ".tar.gz" is found before ".gz"
+var extensions = []struct {
+	ext    string
+	format Format
+}{
+	{".tar.gz", TarGzip},
+	{".tar.bz2", TarBzip2},
+	{".tar.xz", TarXz},
+	{".tgz", TarGzip},
+	{".tbz2", TarBzip2},
+	{".tbz", TarBzip2},
+	{".txz", TarXz},
+	{".tar", Tar},
+	{".zip", Zip},
+	{".jar", Zip},
+}
+
+// FormatOf returns the format of an archive by the extension of its name
+func FormatOf(name string) Format {
+	name = strings.ToLower(name)
+	for _, e := range extensions {
+		if strings.HasSuffix(name, e.ext) {
+			return e.format
+		}
+	}
+	return Unknown
+}
+
+// IsArchive reports whether the name has the extension of a known archive format
+func IsArchive(name string) bool {
+	return FormatOf(name) != Unknown
+}
+
+// Split splits the path of an entry into the path of the outermost archive and the path in
+// it. It returns false for a path that is not in an archive.
+func Split(p string) (string, string, bool) {
+	n := strings.Index(p, Separator)
+	if n < 0 {
+		return p, "", false
+	}
+	return p[:n], p[n+len(Separator):], true
+}
+
+// Entry is a file or directory in an archive
+type Entry struct {
+	// Path in the archive with "/" as the separator. Entries of a nested archive are below
+	// it, after a Separator.
+	Path    string
+	Size    int64 // Uncompressed, as the archive says
+	ModTime time.Time
+	Mode    fs.FileMode
+	IsDir   bool
+}
+
+// Limits protect against archives that expand into far more than they look like, zip
+// bombs, and archives nested in themselves. Zero means no limit.
+type Limits struct {
+	MaxDepth   int   // How many levels of archives in archives are opened, 0 opens none
+	MaxEntries int   // Entries walked in an archive, those in nested archives included
+	MaxSize    int64 // Bytes decompressed while walking, compressed tar streams and nested archives
+	MaxRatio   int64 // How many times bigger than compressed an archive or entry may get
+}
+
+// Walk calls fn for every entry of the archive at path, directories that are only implied
+// by the paths of files are walked too. Nested archives are walked after their own entry.
+// An error from fn stops the walk and is returned.
+func Walk(path string, limits Limits, fn func(Entry) error) error {
+	format := FormatOf(path)
+	if format == Unknown {
+		return ErrUnsupported
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return ErrUnsupported
+	}
+
+	w := &walker{
+		limits: limits,
+		fn:     fn,
+		dirs:   make(map[string]struct{}),
+	}
+
+	if err := w.walk(f, info.Size(), format, "", 0); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// walker keeps the counts the limits apply to across nested archives
+type walker struct {
+	limits  Limits
+	fn      func(Entry) error
+	entries int
+	read    int64               // Bytes decompressed so far
+	dirs    map[string]struct{} // Directories already walked
+	stopped error               // Returned by fn, it stops the whole walk
+}
+
+func (w *walker) walk(r io.ReaderAt, size int64, format Format, prefix string, depth int) error {
+	if format == Zip {
+		return w.walkZip(r, size, prefix, depth)
+	}
+	return w.walkTar(r, size, format, prefix, depth)
+}
+
+// emit walks an entry after the directories above it that were not walked yet
+func (w *walker) emit(prefix string, e Entry) error {
+	name, ok := cleanName(e.Path)
+	if !ok {
+		return nil
+	}
+
+	// Directories above it are implied, they may come later or not at all
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if err := w.emitDir(prefix+dir, Entry{IsDir: true, Mode: fs.ModeDir | 0755}); err != nil {
+			return err
+		}
+	}
+
+	e.Path = prefix + name
+	if e.IsDir {
+		return w.emitDir(e.Path, e)
+	}
+	return w.call(e)
+}
+
+func (w *walker) emitDir(p string, e Entry) error {
+	if _, ok := w.dirs[p]; ok {
+		return nil
+	}
+	w.dirs[p] = struct{}{}
+
+	e.Path = p
+	return w.call(e)
+}
+
+func (w *walker) call(e Entry) error {
+	if w.limits.MaxEntries > 0 && w.entries >= w.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrLimit, w.limits.MaxEntries)
+	}
+	w.entries++
+
+	if err := w.fn(e); err != nil {
+		w.stopped = err
+		return err
+	}
+	return nil
+}
+
+// nested reports whether an entry is an archive that is opened, at depth below the top
+func (w *walker) nested(name string, depth int) bool {
+	return !strings.HasSuffix(name, "/") && depth < w.limits.MaxDepth && IsArchive(name)
+}
+
+// cleanName makes the path of an entry relative with "/" separators and keeps it in the
+// archive, "../x" is "x". Empty paths are skipped.
+func cleanName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+	return name, true
+}
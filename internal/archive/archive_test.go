@@ -0,0 +1,227 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/archive"
+	"github.com/ulikunitz/xz"
+)
+
+var modTime = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+type file struct {
+	name string
+	data []byte
+}
+
+func zipData(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarData(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		h := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), ModTime: modTime, Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			h.Typeflag, h.Mode, h.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(f.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(data)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func xzData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw.Write(data)
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// walk returns "path size" for every entry, directories end with "/"
+func walk(path string, limits archive.Limits) ([]string, error) {
+	var entries []string
+	err := archive.Walk(path, limits, func(e archive.Entry) error {
+		if e.IsDir {
+			entries = append(entries, e.Path+"/")
+		} else {
+			entries = append(entries, fmt.Sprintf("%s %d", e.Path, e.Size))
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func TestWalk(t *testing.T) {
+	inner := tarData(t, file{"readme.md", []byte("hello")})
+	data := zipData(t,
+		file{"docs/a.txt", []byte("abc")},
+		file{"../escape.txt", []byte("x")},
+		file{"inner.tar", inner},
+	)
+
+	path := writeFile(t, "a.zip", data)
+
+	got, err := walk(path, archive.Limits{MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[docs/ docs/a.txt 3 escape.txt 1 inner.tar 2048 inner.tar!/readme.md 5]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+
+	// Nested archives are only entries without depth
+	got, err = walk(path, archive.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Errorf("Expected the nested archive not to be opened, got %v", got)
+	}
+
+	err = archive.Walk(path, archive.Limits{}, func(e archive.Entry) error {
+		if !e.ModTime.Equal(modTime) && !e.IsDir {
+			t.Errorf("Expected the time from the header for %s, got %v", e.Path, e.ModTime)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkTar(t *testing.T) {
+	data := tarData(t, file{"src/", nil}, file{"src/main.go", []byte("package main")})
+
+	for name, content := range map[string][]byte{
+		"a.tar":    data,
+		"a.tar.gz": gzipData(t, data),
+		"a.tgz":    gzipData(t, data),
+		"a.tar.xz": xzData(t, data),
+	} {
+		got, err := walk(writeFile(t, name, content), archive.Limits{})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if fmt.Sprint(got) != "[src/ src/main.go 12]" {
+			t.Errorf("%s: unexpected entries %v", name, got)
+		}
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	var files []file
+	for n := 0; n < 10; n++ {
+		files = append(files, file{fmt.Sprintf("f%d.txt", n), nil})
+	}
+	path := writeFile(t, "many.zip", zipData(t, files...))
+
+	got, err := walk(path, archive.Limits{MaxEntries: 4})
+	if !errors.Is(err, archive.ErrLimit) {
+		t.Errorf("Expected ErrLimit, got %v", err)
+	}
+	if len(got) != 4 {
+		t.Errorf("Expected the entries up to the limit, got %v", got)
+	}
+
+	// A stream that expands into far more than it is
+	bomb := writeFile(t, "bomb.tar.gz", gzipData(t, tarData(t, file{"zeros", make([]byte, 8<<20)})))
+	if _, err := walk(bomb, archive.Limits{MaxRatio: 100}); !errors.Is(err, archive.ErrLimit) {
+		t.Errorf("Expected ErrLimit for the ratio, got %v", err)
+	}
+	if _, err := walk(bomb, archive.Limits{MaxSize: 1 << 20}); !errors.Is(err, archive.ErrLimit) {
+		t.Errorf("Expected ErrLimit for the size, got %v", err)
+	}
+	if _, err := walk(bomb, archive.Limits{}); err != nil {
+		t.Errorf("Expected no error without limits, got %v", err)
+	}
+
+	// An archive nested in itself over and over
+	nested := zipData(t, file{"x.txt", nil})
+	for n := 0; n < 5; n++ {
+		nested = zipData(t, file{"n.zip", nested})
+	}
+	got, err = walk(writeFile(t, "nested.zip", nested), archive.Limits{MaxDepth: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !strings.HasPrefix(got[2], "n.zip!/n.zip!/n.zip ") {
+		t.Errorf("Expected two levels of nested archives, got %v", got)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		path, archive, entry string
+		ok                   bool
+	}{
+		{"/a/b.zip!/c/d.txt", "/a/b.zip", "c/d.txt", true},
+		{"/a/b.zip!/c.tar!/d.txt", "/a/b.zip", "c.tar!/d.txt", true},
+		{"/a/b!c.txt", "/a/b!c.txt", "", false},
+	}
+
+	for _, test := range tests {
+		a, e, ok := archive.Split(test.path)
+		if a != test.archive || e != test.entry || ok != test.ok {
+			t.Errorf("Split(%q) = %q, %q, %v", test.path, a, e, ok)
+		}
+	}
+
+	if archive.FormatOf("A.TAR.GZ") != archive.TarGzip || archive.FormatOf("x.jar") != archive.Zip || archive.IsArchive("x.gz") {
+		t.Error("Unexpected formats")
+	}
+}
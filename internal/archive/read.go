@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// Nested archives bigger than this are not read into memory to be opened
+	maxNestedSize = 64 << 20
+
+	// Small files compress very well, the ratio only counts once this much was decompressed
+	minRatioSize = 1 << 20
+)
+
+func (w *walker) walkZip(r io.ReaderAt, size int64, prefix string, depth int) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return err
+	}
+
+	for _, f := range z.File {
+		info := f.FileInfo()
+		e := Entry{
+			Path:    f.Name,
+			Size:    int64(f.UncompressedSize64),
+			ModTime: f.Modified,
+			Mode:    info.Mode(),
+			IsDir:   info.IsDir(),
+		}
+		if err := w.emit(prefix, e); err != nil {
+			return err
+		}
+
+		if e.IsDir || !w.nested(f.Name, depth) {
+			continue
+		}
+
+		if err := w.checkRatio(int64(f.UncompressedSize64), int64(f.CompressedSize64)); err != nil {
+			return err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		err = w.walkNested(w.count(rc, 0), e.Size, f.Name, prefix, depth)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *walker) walkTar(r io.ReaderAt, size int64, format Format, prefix string, depth int) error {
+	// A plain tar is not decompressed, the reader can seek past the content of the entries
+	var src io.Reader = io.NewSectionReader(r, 0, size)
+
+	switch format {
+	case TarGzip:
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = w.count(gz, size)
+	case TarBzip2:
+		src = w.count(bzip2.NewReader(src), size)
+	case TarXz:
+		xr, err := xz.NewReader(src)
+		if err != nil {
+			return err
+		}
+		src = w.count(xr, size)
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		}
+
+		e := Entry{
+			Path:    h.Name,
+			Size:    h.Size,
+			ModTime: h.ModTime,
+			Mode:    h.FileInfo().Mode(),
+			IsDir:   h.Typeflag == tar.TypeDir,
+		}
+		if err := w.emit(prefix, e); err != nil {
+			return err
+		}
+
+		if h.Typeflag != tar.TypeReg || !w.nested(h.Name, depth) {
+			continue
+		}
+
+		// Compressed tar streams count what they decompress already
+		var nr io.Reader = tr
+		if format == Tar {
+			nr = w.count(tr, 0)
+		}
+		if err := w.walkNested(nr, e.Size, h.Name, prefix, depth); err != nil {
+			return err
+		}
+	}
+}
+
+// walkNested reads an archive in an archive into memory and walks it. One that can't be
+// read is only an entry, running into a limit or an error from fn stops the walk.
+func (w *walker) walkNested(r io.Reader, size int64, name, prefix string, depth int) error {
+	if size > maxNestedSize {
+		return nil
+	}
+	if w.limits.MaxSize > 0 && w.read+size > w.limits.MaxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrLimit, w.limits.MaxSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		if errors.Is(err, ErrLimit) {
+			return err
+		}
+		return nil
+	}
+
+	name, _ = cleanName(name)
+	err = w.walk(bytes.NewReader(data), int64(len(data)), FormatOf(name), prefix+name+Separator, depth+1)
+	if err != nil && (errors.Is(err, ErrLimit) || w.stopped != nil) {
+		return err
+	}
+	return nil
+}
+
+// checkRatio returns ErrLimit for an entry that expands too many times over its compressed size
+func (w *walker) checkRatio(size, compressed int64) error {
+	if w.limits.MaxRatio <= 0 || size < minRatioSize {
+		return nil
+	}
+	if compressed <= 0 || size/compressed > w.limits.MaxRatio {
+		return fmt.Errorf("%w: expands more than %d times", ErrLimit, w.limits.MaxRatio)
+	}
+	return nil
+}
+
+// count returns a reader that adds what it reads to the bytes decompressed, and fails once
+// that is over the limits. Compressed is the size it was decompressed from, 0 when it is
+// checked some other way.
+func (w *walker) count(r io.Reader, compressed int64) io.Reader {
+	return &countReader{r: r, w: w, compressed: compressed}
+}
+
+type countReader struct {
+	r          io.Reader
+	w          *walker
+	compressed int64
+	n          int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.w.read += int64(n)
+
+	if max := c.w.limits.MaxSize; max > 0 && c.w.read > max {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrLimit, max)
+	}
+	if c.compressed > 0 {
+		if err := c.w.checkRatio(c.n, c.compressed); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package attributes
+
+// WindowsAttributes mirrors the Windows file attribute flags. On other
+// platforms it is always the zero value.
+type WindowsAttributes struct {
+	ReadOnly          bool `json:"read_only"`
+	Hidden            bool `json:"hidden"`
+	System            bool `json:"system"`
+	Directory         bool `json:"directory"`
+	Archive           bool `json:"archive"`
+	Normal            bool `json:"normal"`
+	Temporary         bool `json:"temporary"`
+	Offline           bool `json:"offline"`
+	NotContentIndexed bool `json:"notContentIndexed"`
+	Encrypted         bool `json:"encrypted"`
+	OneDrive          bool `json:"oneDrive"`
+}
+
+func GetFileAttributes(path string) (WindowsAttributes, error) {
+	return WindowsAttributes{}, nil
+}
@@ -0,0 +1,134 @@
+package content_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/content"
+)
+
+func TestSplit(t *testing.T) {
+	got := content.Split("func ParseSize(s string) // 10KB, Größe")
+	want := "[func parsesize s string 10kb größe]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+
+	if got := content.Tokens("a b a"); fmt.Sprint(got) != "[a b]" {
+		t.Errorf("Expected distinct tokens, got %v", got)
+	}
+}
+
+func TestContainsPhrase(t *testing.T) {
+	tokens := content.Split("The annual-budget, is due")
+
+	tests := []struct {
+		phrase string
+		want   bool
+	}{
+		{"annual budget", true},
+		{"ANNUAL   budget", true},
+		{"budget annual", false},
+		{"annual is", false},
+		{"due", true},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := content.ContainsPhrase(tokens, content.Split(tt.phrase)); got != tt.want {
+			t.Errorf("%q: expected %v", tt.phrase, tt.want)
+		}
+	}
+}
+
+func TestIsText(t *testing.T) {
+	if !content.IsText([]byte("plain text\n")) {
+		t.Error("Expected text")
+	}
+	if content.IsText([]byte("bin\x00ary")) {
+		t.Error("Expected a NUL byte to be binary")
+	}
+	if content.IsText([]byte{0xff, 0xfe, 'a'}) {
+		t.Error("Expected invalid UTF-8 to be binary")
+	}
+
+	// A character cut in half where the sniffing stops
+	long := strings.Repeat("a", 7999) + "ö" + "rest"
+	if !content.IsText([]byte(long)) {
+		t.Error("Expected text cut in the middle of a character")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := content.NewIndex()
+	x.Add("/a.txt", content.Tokens("annual budget report"))
+	x.Add("/b.txt", content.Tokens("budget"))
+	x.Add("/c.txt", content.Tokens("annual"))
+
+	tests := []struct {
+		tokens string
+		want   string
+	}{
+		{"budget", "[/a.txt /b.txt]"},
+		{"annual budget", "[/a.txt]"},
+		{"missing", "[]"},
+	}
+
+	for _, tt := range tests {
+		if got := fmt.Sprint(x.Lookup(content.Tokens(tt.tokens))); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.tokens, tt.want, got)
+		}
+	}
+
+	// Adding again replaces the tokens
+	x.Add("/a.txt", content.Tokens("report"))
+	if got := fmt.Sprint(x.Lookup([]string{"budget"})); got != "[/b.txt]" {
+		t.Errorf("Expected the old tokens to be gone, got %s", got)
+	}
+
+	x.Remove("/b.txt")
+	if x.Has("/b.txt") || x.Len() != 2 {
+		t.Errorf("Expected /b.txt to be removed")
+	}
+}
+
+func TestIndexCompact(t *testing.T) {
+	x := content.NewIndex()
+	for n := 0; n < 3000; n++ {
+		x.Add(fmt.Sprintf("/%d.txt", n), []string{"common", fmt.Sprintf("word%d", n%3)})
+	}
+	for n := 0; n < 3000; n++ {
+		if n%3 != 0 {
+			x.Remove(fmt.Sprintf("/%d.txt", n))
+		}
+	}
+
+	if got := len(x.Lookup([]string{"common"})); got != 1000 {
+		t.Errorf("Expected 1000 keys, got %d", got)
+	}
+	if got := x.Lookup([]string{"word0"}); len(got) != 1000 || got[1] != "/3.txt" {
+		t.Errorf("Unexpected keys after compacting %v", got[:2])
+	}
+	if got := len(x.Lookup([]string{"word1"})); got != 0 {
+		t.Errorf("Expected no word1, got %d", got)
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	text := "first line\nthe annual budget\r\nnothing\n" + strings.Repeat("x ", 200) + "budget " + strings.Repeat("y ", 200) + "\nbudget again\n"
+
+	snippets := content.Snippets(text, [][]string{{"budget"}}, 2)
+	if len(snippets) != 2 {
+		t.Fatalf("Expected 2 snippets, got %+v", snippets)
+	}
+
+	if snippets[0].Line != 2 || snippets[0].Text != "the annual budget" {
+		t.Errorf("Unexpected first snippet %+v", snippets[0])
+	}
+
+	long := snippets[1]
+	if long.Line != 4 || !strings.Contains(long.Text, "budget") || !strings.HasPrefix(long.Text, "…") || !strings.HasSuffix(long.Text, "…") {
+		t.Errorf("Expected a long line cut around the match, got %+v", long)
+	}
+}
@@ -0,0 +1,52 @@
+package content
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrNotText is returned for a file that doesn't look like text
+var ErrNotText = errors.New("not a text file")
+
+// ErrTooBig is returned for a file bigger than the limit
+var ErrTooBig = errors.New("file too big")
+
+// ReadFile reads a text file that is at most maxSize bytes, 0 means no limit
+func ReadFile(path string, maxSize int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if !info.Mode().IsRegular() {
+		return "", ErrNotText
+	}
+
+	if maxSize > 0 && info.Size() > maxSize {
+		return "", ErrTooBig
+	}
+
+	// The file can grow after the stat
+	r := io.Reader(f)
+	if maxSize > 0 {
+		r = io.LimitReader(f, maxSize)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	if !IsText(data) {
+		return "", ErrNotText
+	}
+
+	return string(data), nil
+}
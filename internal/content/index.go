@@ -0,0 +1,175 @@
+package content
+
+import (
+	"sort"
+	"sync"
+)
+
+// Index is an inverted index from the tokens in the content of files to the keys of the
+// files that have them. Tokens and keys get ids so a posting list is a sorted list of
+// numbers, and every key keeps its token ids so it can be taken out again.
+type Index struct {
+	lock     sync.RWMutex
+	tokenIDs map[string]uint32
+	postings [][]uint32 // Key ids by token id, in increasing order
+	ids      map[string]uint32
+	keys     []string   // By id, empty once removed
+	docs     [][]uint32 // Token ids by key id
+	removed  int
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{
+		tokenIDs: make(map[string]uint32),
+		ids:      make(map[string]uint32),
+	}
+}
+
+// Len returns the number of keys in the index
+func (x *Index) Len() int {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	return len(x.ids)
+}
+
+// Has reports whether a key is in the index
+func (x *Index) Has(key string) bool {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	_, ok := x.ids[key]
+	return ok
+}
+
+// Add adds the distinct tokens of a key, replacing what it had before
+func (x *Index) Add(key string, tokens []string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.remove(key)
+
+	// New ids are always the highest so appending keeps the lists in order
+	id := uint32(len(x.keys))
+	x.ids[key] = id
+	x.keys = append(x.keys, key)
+
+	doc := make([]uint32, 0, len(tokens))
+	for _, t := range tokens {
+		tid, ok := x.tokenIDs[t]
+		if !ok {
+			tid = uint32(len(x.postings))
+			x.tokenIDs[t] = tid
+			x.postings = append(x.postings, nil)
+		}
+
+		x.postings[tid] = append(x.postings[tid], id)
+		doc = append(doc, tid)
+	}
+	x.docs = append(x.docs, doc)
+}
+
+// Remove removes a key
+func (x *Index) Remove(key string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.remove(key)
+}
+
+func (x *Index) remove(key string) {
+	id, ok := x.ids[key]
+	if !ok {
+		return
+	}
+
+	for _, tid := range x.docs[id] {
+		list := x.postings[tid]
+		n := sort.Search(len(list), func(i int) bool { return list[i] >= id })
+		if n < len(list) && list[n] == id {
+			x.postings[tid] = append(list[:n], list[n+1:]...)
+		}
+	}
+
+	delete(x.ids, key)
+	x.keys[id] = ""
+	x.docs[id] = nil
+	x.removed++
+
+	// Ids are not reused, once most of them are gone they are handed out again from 0
+	if x.removed > 1024 && x.removed > len(x.keys)/2 {
+		x.compact()
+	}
+}
+
+// compact gives the keys that are left new ids in the same order, so the lists stay sorted
+func (x *Index) compact() {
+	remap := make([]uint32, len(x.keys))
+	keys := make([]string, 0, len(x.ids))
+	docs := make([][]uint32, 0, len(x.ids))
+
+	for id, key := range x.keys {
+		if key == "" {
+			continue
+		}
+		remap[id] = uint32(len(keys))
+		x.ids[key] = uint32(len(keys))
+		keys = append(keys, key)
+		docs = append(docs, x.docs[id])
+	}
+
+	for _, list := range x.postings {
+		for n, id := range list {
+			list[n] = remap[id]
+		}
+	}
+
+	x.keys = keys
+	x.docs = docs
+	x.removed = 0
+}
+
+// Lookup returns the keys that have all of the tokens
+func (x *Index) Lookup(tokens []string) []string {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	var ids []uint32
+	for n, t := range tokens {
+		tid, ok := x.tokenIDs[t]
+		if !ok {
+			return []string{}
+		}
+
+		if n == 0 {
+			ids = x.postings[tid]
+		} else {
+			ids = intersect(ids, x.postings[tid])
+		}
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, x.keys[id])
+	}
+	return keys
+}
+
+// intersect returns the ids in both sorted lists, in a new list
+func intersect(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package content
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Snippet is a line of a file where a search matched
+type Snippet struct {
+	Line int    `json:"line"` // Counted from 1
+	Text string `json:"text"` // Cut down around the match when the line is long
+}
+
+// Longest snippet text in bytes, before the "…" around a cut line
+const maxSnippetLen = 160
+
+// Snippets returns up to max lines of text that contain any of the phrases, each a list of
+// tokens as Split returns them
+func Snippets(text string, phrases [][]string, max int) []Snippet {
+	var snippets []Snippet
+
+	for n, line := range strings.Split(text, "\n") {
+		if len(snippets) >= max {
+			break
+		}
+
+		tokens := Split(line)
+		for _, phrase := range phrases {
+			if ContainsPhrase(tokens, phrase) {
+				snippets = append(snippets, Snippet{Line: n + 1, Text: cut(strings.TrimRight(line, "\r"), phrase[0])})
+				break
+			}
+		}
+	}
+
+	return snippets
+}
+
+// cut shortens a long line to the part around the first place it has the token
+func cut(line, token string) string {
+	line = strings.TrimSpace(line)
+	if len(line) <= maxSnippetLen {
+		return line
+	}
+
+	at := strings.Index(strings.ToLower(line), token)
+	if at < 0 || at >= len(line) {
+		at = 0
+	}
+
+	start := at - maxSnippetLen/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxSnippetLen
+	if end > len(line) {
+		end = len(line)
+		start = end - maxSnippetLen
+	}
+
+	// Don't cut a character in half
+	for start > 0 && !utf8.RuneStart(line[start]) {
+		start--
+	}
+	for end < len(line) && !utf8.RuneStart(line[end]) {
+		end++
+	}
+
+	text := line[start:end]
+	if start > 0 {
+		text = "…" + text
+	}
+	if end < len(line) {
+		text += "…"
+	}
+	return text
+}
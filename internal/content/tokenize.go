@@ -0,0 +1,104 @@
+package content
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Longest token that is indexed, longer runs are more likely data than words
+const maxTokenLen = 64
+
+// Split returns the tokens of text in order. A token is a run of letters, digits and
+// underscores in lower case, so identifiers in source code are one token.
+func Split(text string) []string {
+	var tokens []string
+
+	start := -1
+	for i, r := range text {
+		if isTokenRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			tokens = appendToken(tokens, text[start:i])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = appendToken(tokens, text[start:])
+	}
+
+	return tokens
+}
+
+func appendToken(tokens []string, token string) []string {
+	if len(token) > maxTokenLen {
+		return tokens
+	}
+	return append(tokens, strings.ToLower(token))
+}
+
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Tokens returns the distinct tokens of text
+func Tokens(text string) []string {
+	seen := make(map[string]struct{})
+	var tokens []string
+	for _, t := range Split(text) {
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// ContainsPhrase reports whether the tokens of a phrase follow each other in tokens
+func ContainsPhrase(tokens, phrase []string) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j, t := range phrase {
+			if tokens[i+j] != t {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// How much of a file is looked at to tell whether it is text
+const sniffLen = 8000
+
+// IsText reports whether data looks like text: valid UTF-8 without NUL bytes
+func IsText(data []byte) bool {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+
+		// Don't count a character cut in half at the end
+		for n := 0; n < utf8.UTFMax && len(data) > 0 && !utf8.RuneStart(data[len(data)-1]); n++ {
+			data = data[:len(data)-1]
+		}
+		if len(data) > 0 && !utf8.FullRune(data[len(data)-1:]) {
+			data = data[:len(data)-1]
+		}
+	}
+
+	return bytes.IndexByte(data, 0) < 0 && utf8.Valid(data)
+}
@@ -0,0 +1,124 @@
+package extract
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// Nested multiparts are followed this deep
+const maxMultipartDepth = 8
+
+// eml is an email message as saved by mail clients
+type eml struct{}
+
+func (eml) Name() string         { return "eml" }
+func (eml) Extensions() []string { return []string{".eml"} }
+func (eml) MIMETypes() []string  { return []string{"message/rfc822"} }
+
+func (eml) Extract(r io.ReaderAt, size int64) (*Document, error) {
+	msg, err := mail.ReadMessage(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+
+	dec := new(mime.WordDecoder)
+	header := func(key string) string {
+		v := msg.Header.Get(key)
+		if decoded, err := dec.DecodeHeader(v); err == nil {
+			return decoded
+		}
+		return v
+	}
+
+	meta := make(map[string]string)
+	setMeta(meta, "title", header("Subject"))
+	setMeta(meta, "author", header("From"))
+	setMeta(meta, "to", header("To"))
+	setMeta(meta, "date", header("Date"))
+
+	plain, html, err := emailBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages often have the same text twice, as plain text and as HTML
+	body := plain
+	if body == "" && html != "" {
+		doc, err := htmlDocument([]byte(html))
+		if err != nil {
+			return nil, err
+		}
+		body = doc.Text
+	}
+
+	return &Document{Text: joinText(meta["title"], cleanText(body)), Metadata: meta}, nil
+}
+
+// emailBody returns the plain text and the HTML of a message body or part, attachments
+// are left out
+func emailBody(contentType, encoding string, body io.Reader, depth int) (string, string, error) {
+	typ, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		typ = "text/plain"
+	}
+
+	if strings.HasPrefix(typ, "multipart/") {
+		if depth >= maxMultipartDepth || params["boundary"] == "" {
+			return "", "", nil
+		}
+
+		var plain, html []string
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", "", err
+			}
+
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+
+			p, h, err := emailBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err != nil {
+				return "", "", err
+			}
+			if p != "" {
+				plain = append(plain, p)
+			}
+			if h != "" {
+				html = append(html, h)
+			}
+		}
+		return strings.Join(plain, "\n\n"), strings.Join(html, "\n"), nil
+	}
+
+	if typ != "text/plain" && typ != "text/html" {
+		return "", "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxPartSize))
+	if err != nil {
+		return "", "", err
+	}
+
+	if typ == "text/html" {
+		return "", string(data), nil
+	}
+	return string(data), "", nil
+}
@@ -0,0 +1,90 @@
+package extract
+
+import (
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// epub is an e-book, a zip archive of XHTML chapters listed in a package document
+type epub struct{}
+
+func (epub) Name() string         { return "epub" }
+func (epub) Extensions() []string { return []string{".epub"} }
+func (epub) MIMETypes() []string  { return []string{"application/epub+zip"} }
+
+func (epub) Extract(r io.ReaderAt, size int64) (*Document, error) {
+	z, err := openZip(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// The container points at the package document
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if ok, err := decodePart(z, "META-INF/container.xml", &container); err != nil || !ok || len(container.Rootfiles) == 0 {
+		return nil, fmtMissing("epub", "META-INF/container.xml", err)
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg struct {
+		Title    []string `xml:"metadata>title"`
+		Creator  []string `xml:"metadata>creator"`
+		Language string   `xml:"metadata>language"`
+		Items    []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if ok, err := decodePart(z, opfPath, &pkg); err != nil || !ok {
+		return nil, fmtMissing("epub", opfPath, err)
+	}
+
+	hrefs := make(map[string]string, len(pkg.Items))
+	for _, item := range pkg.Items {
+		hrefs[item.ID] = item.Href
+	}
+
+	// The chapters in reading order
+	var parts []string
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+
+		rc, err := openPart(z, path.Join(path.Dir(opfPath), href))
+		if err != nil || rc == nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxPartSize))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := htmlDocument(data)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, doc.Text)
+	}
+
+	meta := make(map[string]string)
+	setMeta(meta, "title", strings.Join(pkg.Title, ", "))
+	setMeta(meta, "author", strings.Join(pkg.Creator, ", "))
+	setMeta(meta, "language", pkg.Language)
+
+	return &Document{Text: joinText(parts...), Metadata: meta}, nil
+}
@@ -0,0 +1,128 @@
+package extract
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNoExtractor is returned for a file that no extractor handles
+var ErrNoExtractor = errors.New("no extractor for file")
+
+// Most text read from one part of a document, so a small archive can't expand into gigabytes
+const maxPartSize = 32 << 20
+
+// Document is what an extractor found in a file
+type Document struct {
+	Text     string
+	Metadata map[string]string // Like "title" and "author", when the format has them
+}
+
+// Extractor gets the text out of files of some format
+type Extractor interface {
+	// Name of the format, like "docx"
+	Name() string
+
+	// Extensions with the dot, like ".docx", and MIME types, like "text/html", that the
+	// extractor handles
+	Extensions() []string
+	MIMETypes() []string
+
+	// Extract reads the text of a file of size bytes
+	Extract(r io.ReaderAt, size int64) (*Document, error)
+}
+
+// Registry finds the extractor for a file by its extension or, when the extension is not
+// known, by its MIME type
+type Registry struct {
+	lock   sync.RWMutex
+	byExt  map[string]Extractor
+	byMIME map[string]Extractor
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		byExt:  make(map[string]Extractor),
+		byMIME: make(map[string]Extractor),
+	}
+}
+
+// Default has the built in extractors, and is what the indexer uses unless configured otherwise
+var Default = NewRegistry()
+
+func init() {
+	for _, e := range []Extractor{docx{}, odt{}, xlsx{}, epub{}, htmlExtractor{}, eml{}} {
+		Default.Register(e)
+	}
+}
+
+// Register adds an extractor to the default registry
+func Register(e Extractor) {
+	Default.Register(e)
+}
+
+// Register adds an extractor, replacing any that handled the same extensions or MIME types
+func (r *Registry) Register(e Extractor) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, ext := range e.Extensions() {
+		r.byExt[strings.ToLower(ext)] = e
+	}
+	for _, typ := range e.MIMETypes() {
+		r.byMIME[strings.ToLower(typ)] = e
+	}
+}
+
+// ForExtension returns the extractor for an extension with the dot, or nil
+func (r *Registry) ForExtension(ext string) Extractor {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.byExt[strings.ToLower(ext)]
+}
+
+// ForMIME returns the extractor for a MIME type, parameters like charset are ignored
+func (r *Registry) ForMIME(typ string) Extractor {
+	if t, _, err := mime.ParseMediaType(typ); err == nil {
+		typ = t
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.byMIME[strings.ToLower(typ)]
+}
+
+// Extract reads the text of the file at path with the extractor for its extension, or for
+// the MIME type its first bytes look like
+func (r *Registry) Extract(path string) (*Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	e := r.ForExtension(filepath.Ext(path))
+	if e == nil {
+		head := make([]byte, 512)
+		n, _ := f.ReadAt(head, 0)
+		e = r.ForMIME(http.DetectContentType(head[:n]))
+	}
+	if e == nil {
+		return nil, ErrNoExtractor
+	}
+
+	return e.Extract(f, info.Size())
+}
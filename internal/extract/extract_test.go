@@ -0,0 +1,219 @@
+package extract_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/extract"
+)
+
+func zipDoc(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extractBytes(t *testing.T, ext string, data []byte) *extract.Document {
+	t.Helper()
+
+	e := extract.Default.ForExtension(ext)
+	if e == nil {
+		t.Fatalf("No extractor for %s", ext)
+	}
+
+	doc, err := e.Extract(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("%s: %v", ext, err)
+	}
+	return doc
+}
+
+const coreXML = `<?xml version="1.0" encoding="UTF-8"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title>Budget 2024</dc:title><dc:creator>Alice</dc:creator></cp:coreProperties>`
+
+func TestDocx(t *testing.T) {
+	doc := extractBytes(t, ".DOCX", zipDoc(t, map[string]string{
+		"word/document.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>The annual </w:t></w:r><w:r><w:t>budget</w:t></w:r></w:p>
+<w:p><w:r><w:instrText>HYPERLINK "x"</w:instrText><w:t>Second</w:t><w:tab/><w:t>line</w:t></w:r></w:p>
+</w:body></w:document>`,
+		"docProps/core.xml": coreXML,
+	}))
+
+	if doc.Text != "The annual budget\nSecond line" {
+		t.Errorf("Unexpected text %q", doc.Text)
+	}
+	if doc.Metadata["title"] != "Budget 2024" || doc.Metadata["author"] != "Alice" {
+		t.Errorf("Unexpected metadata %v", doc.Metadata)
+	}
+}
+
+func TestXlsx(t *testing.T) {
+	doc := extractBytes(t, ".xlsx", zipDoc(t, map[string]string{
+		"xl/workbook.xml":          `<workbook><sheets><sheet name="Costs" sheetId="1"/></sheets></workbook>`,
+		"xl/sharedStrings.xml":     `<sst><si><t>Rent</t></si><si><r><t>Trav</t></r><r><t>el</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c t="s"><v>0</v></c><c t="inlineStr"><is><t>Inline</t></is></c></row></sheetData></worksheet>`,
+	}))
+
+	if doc.Text != "Costs\n\nRent\nTravel\n\nInline" {
+		t.Errorf("Unexpected text %q", doc.Text)
+	}
+}
+
+func TestOdt(t *testing.T) {
+	doc := extractBytes(t, ".odt", zipDoc(t, map[string]string{
+		"content.xml": `<office:document-content xmlns:office="o" xmlns:text="t" xmlns:style="s">
+<office:automatic-styles><style:style style:name="P1">Hidden</style:style></office:automatic-styles>
+<office:body><office:text><text:h>Title</text:h><text:p>Hello<text:s/>world</text:p></office:text></office:body></office:document-content>`,
+		"meta.xml": `<office:document-meta xmlns:office="o" xmlns:meta="m" xmlns:dc="d"><office:meta><dc:title>Doc</dc:title><meta:initial-creator>Bob</meta:initial-creator></office:meta></office:document-meta>`,
+	}))
+
+	if doc.Text != "Title\nHello world" {
+		t.Errorf("Unexpected text %q", doc.Text)
+	}
+	if doc.Metadata["title"] != "Doc" || doc.Metadata["author"] != "Bob" {
+		t.Errorf("Unexpected metadata %v", doc.Metadata)
+	}
+}
+
+func TestEpub(t *testing.T) {
+	doc := extractBytes(t, ".epub", zipDoc(t, map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<package><metadata><dc:title xmlns:dc="d">A Book</dc:title><dc:creator xmlns:dc="d">Carol</dc:creator></metadata>
+<manifest><item id="c2" href="chapter%202.xhtml"/><item id="c1" href="text/one.xhtml"/></manifest>
+<spine><itemref idref="c1"/><itemref idref="c2"/></spine></package>`,
+		"OEBPS/text/one.xhtml":  `<html><head><title>One</title></head><body><h1>Chapter one</h1><p>It begins.</p></body></html>`,
+		"OEBPS/chapter 2.xhtml": `<html><body><p>It ends.</p></body></html>`,
+	}))
+
+	if doc.Text != "Chapter one\nIt begins.\n\nIt ends." {
+		t.Errorf("Unexpected text %q", doc.Text)
+	}
+	if doc.Metadata["title"] != "A Book" || doc.Metadata["author"] != "Carol" {
+		t.Errorf("Unexpected metadata %v", doc.Metadata)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	doc := extractBytes(t, ".html", []byte(`<!DOCTYPE html>
+<html><head><title>The page</title><style>p { color: red }</style></head>
+<body><script>var x = "<p>no</p>";</script>
+<p>First&nbsp;para<br>next line</p><ul><li>one<li>two</ul>
+<table><tr><td>a</td><td>b</td></tr></table><img src=x.png>
+</body></html>`))
+
+	want := "First para\nnext line\none\ntwo\na b"
+	if doc.Text != want {
+		t.Errorf("Expected %q, got %q", want, doc.Text)
+	}
+	if doc.Metadata["title"] != "The page" {
+		t.Errorf("Unexpected metadata %v", doc.Metadata)
+	}
+}
+
+func TestEml(t *testing.T) {
+	msg := strings.ReplaceAll(`From: Alice <alice@example.com>
+To: bob@example.com
+Subject: =?UTF-8?Q?Quarterly_r=C3=A9port?=
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Numbers are up, see the attach=
+ment.
+--inner
+Content-Type: text/html
+
+<p>Numbers are up</p>
+--inner--
+--outer
+Content-Type: text/plain
+Content-Disposition: attachment; filename="secret.txt"
+
+not indexed
+--outer
+Content-Type: text/plain
+Content-Transfer-Encoding: base64
+
+QnllLA0KQWxpY2U=
+--outer--
+`, "\n", "\r\n")
+
+	doc := extractBytes(t, ".eml", []byte(msg))
+
+	want := "Quarterly réport\n\nNumbers are up, see the attachment.\nBye,\nAlice"
+	if doc.Text != want {
+		t.Errorf("Expected %q, got %q", want, doc.Text)
+	}
+	if doc.Metadata["author"] != "Alice <alice@example.com>" || doc.Metadata["title"] != "Quarterly réport" {
+		t.Errorf("Unexpected metadata %v", doc.Metadata)
+	}
+}
+
+type upper struct{}
+
+func (upper) Name() string         { return "upper" }
+func (upper) Extensions() []string { return []string{".up"} }
+func (upper) MIMETypes() []string  { return []string{"text/html"} }
+
+func (upper) Extract(r io.ReaderAt, size int64) (*extract.Document, error) {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	return &extract.Document{Text: strings.ToUpper(string(data))}, err
+}
+
+func TestRegistry(t *testing.T) {
+	r := extract.NewRegistry()
+	r.Register(upper{})
+
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	doc, err := r.Extract(write("a.up", "custom"))
+	if err != nil || doc.Text != "CUSTOM" {
+		t.Errorf("Expected the custom extractor, got %v %v", doc, err)
+	}
+
+	// Picked by what the file looks like
+	doc, err = r.Extract(write("page", "<html><body>sniffed</body></html>"))
+	if err != nil || !strings.Contains(doc.Text, "SNIFFED") {
+		t.Errorf("Expected the extractor for the MIME type, got %v %v", doc, err)
+	}
+
+	if _, err := r.Extract(write("a.bin", "\x00\x01")); err != extract.ErrNoExtractor {
+		t.Errorf("Expected ErrNoExtractor, got %v", err)
+	}
+
+	if r.ForMIME("TEXT/HTML; charset=utf-8") == nil {
+		t.Error("Expected MIME types to be matched without parameters")
+	}
+}
@@ -0,0 +1,55 @@
+package extract
+
+import (
+	"bytes"
+	"io"
+)
+
+// Elements of HTML that end a line of text
+var htmlBreaks = set("p", "div", "br", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6",
+	"title", "section", "article", "header", "footer", "blockquote", "pre", "dt", "dd", "hr")
+
+// htmlExtractor gets the text of a web page without its scripts and styles
+type htmlExtractor struct{}
+
+func (htmlExtractor) Name() string         { return "html" }
+func (htmlExtractor) Extensions() []string { return []string{".html", ".htm", ".xhtml"} }
+func (htmlExtractor) MIMETypes() []string  { return []string{"text/html", "application/xhtml+xml"} }
+
+func (htmlExtractor) Extract(r io.ReaderAt, size int64) (*Document, error) {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, min(size, maxPartSize)))
+	if err != nil {
+		return nil, err
+	}
+	return htmlDocument(data)
+}
+
+// htmlDocument returns the text of a page and its title
+func htmlDocument(data []byte) (*Document, error) {
+	text, err := xmlText{
+		html:   true,
+		skip:   set("head", "script", "style", "noscript", "template", "svg"),
+		breaks: htmlBreaks,
+		spaces: set("td", "th"),
+	}.read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	title, err := xmlText{html: true, only: set("title")}.read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]string)
+	setMeta(meta, "title", title)
+
+	return &Document{Text: text, Metadata: meta}, nil
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,190 @@
+package extract
+
+import (
+	"archive/zip"
+	"io"
+	"sort"
+	"strings"
+)
+
+// docx is a Word document, the text is in word/document.xml
+type docx struct{}
+
+func (docx) Name() string         { return "docx" }
+func (docx) Extensions() []string { return []string{".docx", ".docm", ".dotx"} }
+func (docx) MIMETypes() []string {
+	return []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"}
+}
+
+func (docx) Extract(r io.ReaderAt, size int64) (*Document, error) {
+	z, err := openZip(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Runs of text are in w:t, what else is in a run (like field codes) is not text
+	body, err := readPart(z, "word/document.xml", xmlText{
+		only:   set("t"),
+		breaks: set("p", "br", "cr"),
+		spaces: set("tab"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := coreProperties(z)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{Text: body, Metadata: meta}, nil
+}
+
+// xlsx is an Excel workbook, the text of the cells is mostly in the shared strings
+type xlsx struct{}
+
+func (xlsx) Name() string         { return "xlsx" }
+func (xlsx) Extensions() []string { return []string{".xlsx", ".xlsm"} }
+func (xlsx) MIMETypes() []string {
+	return []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}
+}
+
+func (xlsx) Extract(r io.ReaderAt, size int64) (*Document, error) {
+	z, err := openZip(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if _, err := decodePart(z, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range workbook.Sheets {
+		names = append(names, s.Name)
+	}
+
+	shared, err := readPart(z, "xl/sharedStrings.xml", xmlText{only: set("t"), breaks: set("si")})
+	if err != nil {
+		return nil, err
+	}
+
+	// Strings typed into a cell without going through the shared strings
+	parts := []string{strings.Join(names, "\n"), shared}
+	for _, f := range sortedParts(z, "xl/worksheets/sheet") {
+		text, err := readPart(z, f, xmlText{only: set("t"), breaks: set("c")})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, text)
+	}
+
+	meta, err := coreProperties(z)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{Text: joinText(parts...), Metadata: meta}, nil
+}
+
+// odt is an OpenDocument text, the text is in content.xml
+type odt struct{}
+
+func (odt) Name() string         { return "odt" }
+func (odt) Extensions() []string { return []string{".odt", ".ott"} }
+func (odt) MIMETypes() []string {
+	return []string{"application/vnd.oasis.opendocument.text"}
+}
+
+func (odt) Extract(r io.ReaderAt, size int64) (*Document, error) {
+	z, err := openZip(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Styles and fonts are declared before the body
+	body, err := readPart(z, "content.xml", xmlText{
+		skip:   set("automatic-styles", "font-face-decls", "tracked-changes"),
+		breaks: set("p", "h", "line-break", "list-item"),
+		spaces: set("s", "tab"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Title          string   `xml:"meta>title"`
+		Subject        string   `xml:"meta>subject"`
+		Creator        string   `xml:"meta>creator"`
+		InitialCreator string   `xml:"meta>initial-creator"`
+		Keywords       []string `xml:"meta>keyword"`
+	}
+	if _, err := decodePart(z, "meta.xml", &doc); err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]string)
+	setMeta(meta, "title", doc.Title)
+	setMeta(meta, "subject", doc.Subject)
+	setMeta(meta, "author", doc.InitialCreator)
+	if meta["author"] == "" {
+		setMeta(meta, "author", doc.Creator)
+	}
+	setMeta(meta, "keywords", strings.Join(doc.Keywords, ", "))
+
+	return &Document{Text: body, Metadata: meta}, nil
+}
+
+// coreProperties reads the metadata of an Office Open XML document from docProps/core.xml
+func coreProperties(z *zip.Reader) (map[string]string, error) {
+	var core struct {
+		Title       string `xml:"title"`
+		Subject     string `xml:"subject"`
+		Creator     string `xml:"creator"`
+		Keywords    string `xml:"keywords"`
+		Description string `xml:"description"`
+	}
+	if _, err := decodePart(z, "docProps/core.xml", &core); err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]string)
+	setMeta(meta, "title", core.Title)
+	setMeta(meta, "subject", core.Subject)
+	setMeta(meta, "author", core.Creator)
+	setMeta(meta, "keywords", core.Keywords)
+	setMeta(meta, "description", core.Description)
+	return meta, nil
+}
+
+// sortedParts returns the names of the XML files in a zip archive that start with prefix,
+// with sheet2 before sheet10
+func sortedParts(z *zip.Reader, prefix string) []string {
+	var names []string
+	for _, f := range z.File {
+		if strings.HasPrefix(f.Name, prefix) && strings.HasSuffix(f.Name, ".xml") {
+			names = append(names, f.Name)
+		}
+	}
+
+	sort.Slice(names, func(a, b int) bool {
+		if len(names[a]) != len(names[b]) {
+			return len(names[a]) < len(names[b])
+		}
+		return names[a] < names[b]
+	})
+	return names
+}
+
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
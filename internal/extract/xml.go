@@ -0,0 +1,183 @@
+package extract
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlText collects the character data of an XML document. Elements in skip are left out
+// with everything in them, and elements in breaks are on lines of their own. Names are
+// without their namespace.
+type xmlText struct {
+	skip   map[string]bool
+	breaks map[string]bool
+	spaces map[string]bool // A space is written for these elements, like tabs
+	only   map[string]bool // When set, only text directly in these elements is kept
+	html   bool            // Parse leniently with the HTML entities and elements that close themselves
+}
+
+func (x xmlText) read(r io.Reader) (string, error) {
+	d := xml.NewDecoder(io.LimitReader(r, maxPartSize))
+	d.Strict = !x.html
+	if x.html {
+		d.AutoClose = xml.HTMLAutoClose
+		d.Entity = xml.HTMLEntity
+	}
+	// Documents say which encoding they use, anything but UTF-8 is read as it is
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var b strings.Builder
+	var stack []string
+	skipping := 0
+
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// What was read before a syntax error is still worth having in lenient mode
+			if x.html {
+				break
+			}
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			stack = append(stack, name)
+			if x.skip[name] {
+				skipping++
+			}
+			// Also at the start, for HTML where the end of an element is often left out
+			if x.breaks[name] && skipping == 0 {
+				b.WriteByte('\n')
+			}
+
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if x.skip[name] && skipping > 0 {
+				skipping--
+			}
+			if skipping == 0 {
+				if x.breaks[name] {
+					b.WriteByte('\n')
+				} else if x.spaces[name] {
+					b.WriteByte(' ')
+				}
+			}
+
+		case xml.CharData:
+			if skipping > 0 {
+				continue
+			}
+			if x.only != nil && (len(stack) == 0 || !x.only[stack[len(stack)-1]]) {
+				continue
+			}
+			b.Write(t)
+		}
+	}
+
+	return cleanText(b.String()), nil
+}
+
+// cleanText trims the spaces around every line and drops blank lines
+func cleanText(text string) string {
+	lines := strings.Split(text, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// openZip opens a document that is a zip archive
+func openZip(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip based document: %w", err)
+	}
+	return z, nil
+}
+
+// openPart opens a file in a zip archive, it returns nil for one that isn't there
+func openPart(z *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range z.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, nil
+}
+
+// readPart returns the text of an XML file in a zip archive, or "" when it isn't there
+func readPart(z *zip.Reader, name string, x xmlText) (string, error) {
+	rc, err := openPart(z, name)
+	if err != nil || rc == nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	text, err := x.read(rc)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return text, nil
+}
+
+// decodePart decodes an XML file in a zip archive into v, it returns false when it isn't there
+func decodePart(z *zip.Reader, name string, v interface{}) (bool, error) {
+	rc, err := openPart(z, name)
+	if err != nil || rc == nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	d := xml.NewDecoder(io.LimitReader(rc, maxPartSize))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := d.Decode(v); err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return true, nil
+}
+
+// joinText joins the parts of a document that have text with blank lines
+func joinText(parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, "\n\n")
+}
+
+// setMeta adds a metadata value unless it is blank
+func setMeta(meta map[string]string, key, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		meta[key] = value
+	}
+}
+
+// fmtMissing describes a document without a part it needs
+func fmtMissing(format, part string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", format, err)
+	}
+	return fmt.Errorf("%s: missing %s", format, part)
+}
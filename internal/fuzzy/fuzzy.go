@@ -0,0 +1,256 @@
+package fuzzy
+
+import (
+	"unicode"
+)
+
+// Scores of a subsequence match, close to what fzf uses
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+	bonusBoundary     = 8 // The character starts a word
+	bonusConsecutive  = 4 // The character follows the previous match
+	bonusFirstChar    = 2 // Multiplies the bonus of the first character of the pattern
+)
+
+// Match looks for the characters of pattern in text in the same order, ignoring case. It
+// returns a score that is higher for tighter matches and matches at the start of words, and the
+// positions of the matched characters counted in runes.
+func Match(pattern, text string) (int, []int, bool) {
+	p := lower(pattern)
+	t := lower(text)
+
+	if len(p) == 0 || len(p) > len(t) {
+		return 0, nil, false
+	}
+
+	// Find where the first match in order ends
+	pi := 0
+	end := -1
+	for ti := 0; ti < len(t); ti++ {
+		if t[ti] == p[pi] {
+			pi++
+			if pi == len(p) {
+				end = ti
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	// Then walk back from there for the shortest window that has the whole pattern
+	pi = len(p) - 1
+	start := end
+	for ti := end; ti >= 0; ti-- {
+		if t[ti] == p[pi] {
+			pi--
+			if pi < 0 {
+				start = ti
+				break
+			}
+		}
+	}
+
+	// Score the window, picking the characters from the left
+	original := []rune(text)
+	positions := make([]int, 0, len(p))
+	score := 0
+	inGap := false
+	prev := -1
+	pi = 0
+	for ti := start; ti <= end && pi < len(p); ti++ {
+		if t[ti] != p[pi] {
+			if prev >= 0 {
+				if inGap {
+					score += scoreGapExtension
+				} else {
+					score += scoreGapStart
+				}
+				inGap = true
+			}
+			continue
+		}
+
+		bonus := 0
+		if isBoundary(original, ti) {
+			bonus = bonusBoundary
+		}
+		if prev == ti-1 && prev >= 0 {
+			bonus += bonusConsecutive
+		}
+		if pi == 0 {
+			bonus *= bonusFirstChar
+		}
+
+		score += scoreMatch + bonus
+		positions = append(positions, ti)
+		prev = ti
+		inGap = false
+		pi++
+	}
+
+	return score, positions, true
+}
+
+// MaxScore is the score of a pattern of n runes matching the start of a word in one piece
+func MaxScore(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return n*scoreMatch + bonusBoundary*bonusFirstChar + (n-1)*bonusConsecutive
+}
+
+// isBoundary reports whether the rune at i starts a word: it is the first one, follows a
+// separator, or is an upper case letter after a lower case one
+func isBoundary(text []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+
+	prev, curr := text[i-1], text[i]
+	if !isWordRune(prev) {
+		return isWordRune(curr)
+	}
+
+	return unicode.IsLower(prev) && unicode.IsUpper(curr)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lower(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// MaxTypos returns how many typos are tolerated in a pattern of n runes. Short patterns have
+// too many words within one edit to tolerate any.
+func MaxTypos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// Distance returns the number of edits between a and b ignoring case, where an edit inserts,
+// removes or replaces a character or swaps two neighbouring ones. It gives up once the
+// distance is known to be more than max.
+func Distance(a, b string, max int) (int, bool) {
+	return distance(lower(a), lower(b), max)
+}
+
+func distance(a, b []rune, max int) (int, bool) {
+	if abs(len(a)-len(b)) > max {
+		return 0, false
+	}
+
+	// Three rows are enough, the one before the previous is needed for swaps
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d := min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < d {
+				d = prev2[j-2] + 1
+			}
+
+			curr[j] = d
+			if d < rowMin {
+				rowMin = d
+			}
+		}
+
+		if rowMin > max {
+			return 0, false
+		}
+
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	d := prev[len(b)]
+	return d, d <= max
+}
+
+// MatchWord looks for the word in text that is the fewest typos away from pattern, within
+// what MaxTypos tolerates. It returns the number of typos and the positions of the word.
+func MatchWord(pattern, text string) (int, []int, bool) {
+	p := lower(pattern)
+	max := MaxTypos(len(p))
+	if max == 0 {
+		return 0, nil, false
+	}
+
+	t := lower(text)
+
+	best := -1
+	var bestStart, bestEnd int
+	for start := 0; start < len(t); {
+		if !isWordRune(t[start]) {
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(t) && isWordRune(t[end]) {
+			end++
+		}
+
+		if d, ok := distance(p, t[start:end], max); ok && (best < 0 || d < best) {
+			best, bestStart, bestEnd = d, start, end
+		}
+
+		start = end
+	}
+
+	if best < 0 {
+		return 0, nil, false
+	}
+
+	positions := make([]int, 0, bestEnd-bestStart)
+	for i := bestStart; i < bestEnd; i++ {
+		positions = append(positions, i)
+	}
+
+	return best, positions, true
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
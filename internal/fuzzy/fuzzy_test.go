@@ -0,0 +1,94 @@
+package fuzzy_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/fuzzy"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		text      string
+		ok        bool
+		positions []int
+	}{
+		{"rep", "report.pdf", true, []int{0, 1, 2}},
+		{"RPT", "report.pdf", true, []int{0, 2, 5}},
+		{"rpdf", "report.pdf", true, []int{4, 7, 8, 9}},
+		{"ar", "annual report", true, []int{4, 7}}, // The shortest window
+		{"abc", "acb", false, nil},
+		{"", "abc", false, nil},
+		{"long", "lo", false, nil},
+		{"ö", "Över", true, []int{0}},
+	}
+
+	for _, tt := range tests {
+		_, positions, ok := fuzzy.Match(tt.pattern, tt.text)
+		if ok != tt.ok || fmt.Sprint(positions) != fmt.Sprint(tt.positions) {
+			t.Errorf("%q in %q: expected %v %v, got %v %v", tt.pattern, tt.text, tt.ok, tt.positions, ok, positions)
+		}
+	}
+}
+
+func TestMatchRanking(t *testing.T) {
+	// Better matches first
+	tests := [][2]string{
+		{"my_report.pdf", "prepare.pdf"},
+		{"my_report.pdf", "mirror_export.pdf"},
+		{"fooBar.go", "foobar.go"},
+	}
+
+	pattern := map[int]string{0: "rep", 1: "rep", 2: "fb"}
+	for n, tt := range tests {
+		better, _, _ := fuzzy.Match(pattern[n], tt[0])
+		worse, _, _ := fuzzy.Match(pattern[n], tt[1])
+		if better <= worse {
+			t.Errorf("%q: expected %q (%d) to score above %q (%d)", pattern[n], tt[0], better, tt[1], worse)
+		}
+	}
+
+	if s, _, _ := fuzzy.Match("report", "report"); s != fuzzy.MaxScore(6) {
+		t.Errorf("Expected a whole word to get the max score %d, got %d", fuzzy.MaxScore(6), s)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+		ok   bool
+	}{
+		{"report", "report", 1, 0, true},
+		{"reprot", "report", 1, 1, true}, // A swap
+		{"Report", "repot", 1, 1, true},
+		{"raport", "report", 1, 1, true},
+		{"reprt", "report", 1, 1, true},
+		{"rpeort", "report", 1, 1, true},
+		{"repotr", "report", 2, 1, true},
+		{"abcdef", "badcfe", 2, 0, false},
+		{"kitten", "sitting", 3, 3, true},
+		{"a", "abcd", 2, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := fuzzy.Distance(tt.a, tt.b, tt.max)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("%q to %q within %d: expected %d %v, got %d %v", tt.a, tt.b, tt.max, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestMatchWord(t *testing.T) {
+	d, positions, ok := fuzzy.MatchWord("reprot", "annual report.pdf")
+	if !ok || d != 1 || fmt.Sprint(positions) != "[7 8 9 10 11 12]" {
+		t.Errorf("Expected report with one typo, got %d %v %v", d, positions, ok)
+	}
+
+	// Too short to tolerate typos
+	if _, _, ok := fuzzy.MatchWord("cta", "cat.jpg"); ok {
+		t.Error("Expected no typos in a short pattern")
+	}
+}
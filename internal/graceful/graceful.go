@@ -1,6 +1,7 @@
 package graceful
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -12,6 +13,7 @@ import (
 type GracefulShutdown struct {
 	wg   sync.WaitGroup
 	once sync.Once
+	ctx  context.Context
 }
 
 var shutdownInstance *GracefulShutdown
@@ -32,23 +34,27 @@ func (gs *GracefulShutdown) init() {
 	gs.once.Do(func() {
 		gs.wg = sync.WaitGroup{}
 
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		var stop context.CancelFunc
+		gs.ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 		go func() {
 			// Block until a signal is received.
-			sig := <-sigChan
+			<-gs.ctx.Done()
+
+			// A second signal gets the default behaviour and kills a shutdown that hangs
+			stop()
 
 			log.Println("Graceful shutdown signal received.")
-			// Block until all tasks are done.
-			gs.Wait()
-			log.Println("Graceful shutdown tasks complete.")
-			// Exit with the signal status.
-			os.Exit(int(sig.(syscall.Signal)))
 		}()
 	})
 }
 
+// Context is done once SIGINT or SIGTERM is received. The process is not exited, it is up
+// to the caller to stop what it is doing and return. Another signal after that exits it.
+func (gs *GracefulShutdown) Context() context.Context {
+	return gs.ctx
+}
+
 func (gs *GracefulShutdown) AddTask() {
 	gs.wg.Add(1)
 }
@@ -0,0 +1,181 @@
+package hash
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	gohash "hash"
+	"hash/crc32"
+	"hash/crc64"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+)
+
+// Algorithm is one of the digests in a Hash, by its name in lower case
+type Algorithm string
+
+const (
+	MD5         Algorithm = "md5"
+	SHA1        Algorithm = "sha1"
+	SHA224      Algorithm = "sha224"
+	SHA256      Algorithm = "sha256"
+	SHA384      Algorithm = "sha384"
+	SHA512      Algorithm = "sha512"
+	SHA512_224  Algorithm = "sha512_224"
+	SHA512_256  Algorithm = "sha512_256"
+	SHA3_256    Algorithm = "sha3_256"
+	SHA3_512    Algorithm = "sha3_512"
+	CRC32       Algorithm = "crc32"
+	CRC64       Algorithm = "crc64"
+	Blake2b_256 Algorithm = "blake2b_256"
+	Blake2b_384 Algorithm = "blake2b_384"
+	Blake2b_512 Algorithm = "blake2b_512"
+	Blake2s_256 Algorithm = "blake2s_256"
+)
+
+// Algorithms are all the digests a Hash has room for
+var Algorithms = []Algorithm{
+	MD5, SHA1,
+	SHA224, SHA256, SHA384, SHA512, SHA512_224, SHA512_256,
+	SHA3_256, SHA3_512,
+	CRC32, CRC64,
+	Blake2b_256, Blake2b_384, Blake2b_512, Blake2s_256,
+}
+
+// ParseAlgorithm returns the algorithm for a name like "sha256", "SHA-256" or "sha3-512"
+func ParseAlgorithm(name string) (Algorithm, error) {
+	a := Algorithm(strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_"))
+
+	// "sha-256" is SHA256 and "sha3-256" is SHA3_256
+	if strings.HasPrefix(string(a), "sha_") {
+		a = "sha" + a[len("sha_"):]
+	}
+
+	for _, known := range Algorithms {
+		if a == known {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", name)
+}
+
+// newHasher returns a new hash.Hash computing the algorithm
+func newHasher(a Algorithm) gohash.Hash {
+	switch a {
+	case MD5:
+		return md5.New()
+	case SHA1:
+		return sha1.New()
+	case SHA224:
+		return sha256.New224()
+	case SHA256:
+		return sha256.New()
+	case SHA384:
+		return sha512.New384()
+	case SHA512:
+		return sha512.New()
+	case SHA512_224:
+		return sha512.New512_224()
+	case SHA512_256:
+		return sha512.New512_256()
+	case SHA3_256:
+		return sha3.New256()
+	case SHA3_512:
+		return sha3.New512()
+	case CRC32:
+		return crc32.NewIEEE()
+	case CRC64:
+		return crc64.New(crc64.MakeTable(crc64.ECMA))
+	case Blake2b_256:
+		h, _ := blake2b.New256(nil)
+		return h
+	case Blake2b_384:
+		h, _ := blake2b.New384(nil)
+		return h
+	case Blake2b_512:
+		h, _ := blake2b.New512(nil)
+		return h
+	case Blake2s_256:
+		h, _ := blake2s.New256(nil)
+		return h
+	}
+	return nil
+}
+
+// field returns where the digest of the algorithm is in the Hash, or nil
+func (h *Hash) field(a Algorithm) *string {
+	switch a {
+	case MD5:
+		return &h.MD5
+	case SHA1:
+		return &h.SHA1
+	case SHA224:
+		return &h.SHA2.SHA224
+	case SHA256:
+		return &h.SHA2.SHA256
+	case SHA384:
+		return &h.SHA2.SHA384
+	case SHA512:
+		return &h.SHA2.SHA512
+	case SHA512_224:
+		return &h.SHA2.SHA512_224
+	case SHA512_256:
+		return &h.SHA2.SHA512_256
+	case SHA3_256:
+		return &h.SHA3.SHA256
+	case SHA3_512:
+		return &h.SHA3.SHA512
+	case CRC32:
+		return &h.CRC.CRC32
+	case CRC64:
+		return &h.CRC.CRC64
+	case Blake2b_256:
+		return &h.Blake.Blake2b.Blake256
+	case Blake2b_384:
+		return &h.Blake.Blake2b.Blake384
+	case Blake2b_512:
+		return &h.Blake.Blake2b.Blake512
+	case Blake2s_256:
+		return &h.Blake.Blake2s.Blake256
+	}
+	return nil
+}
+
+// Get returns the digest of the algorithm in hex, or "" when it wasn't computed
+func (h Hash) Get(a Algorithm) string {
+	if f := h.field(a); f != nil {
+		return *f
+	}
+	return ""
+}
+
+// Set sets the digest of the algorithm
+func (h *Hash) Set(a Algorithm, digest string) {
+	if f := h.field(a); f != nil {
+		*f = digest
+	}
+}
+
+// Has reports whether the digests of all the algorithms were computed
+func (h Hash) Has(algs ...Algorithm) bool {
+	for _, a := range algs {
+		if h.Get(a) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge sets the digests other has on top of those in h
+func (h *Hash) Merge(other Hash) {
+	for _, a := range Algorithms {
+		if digest := other.Get(a); digest != "" {
+			h.Set(a, digest)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package hash_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/hash"
+)
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := map[string]hash.Algorithm{
+		"md5":         hash.MD5,
+		"SHA-256":     hash.SHA256,
+		"sha3-512":    hash.SHA3_512,
+		"Blake2b_256": hash.Blake2b_256,
+	}
+
+	for name, want := range tests {
+		if got, err := hash.ParseAlgorithm(name); err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %q, %v", name, got, err)
+		}
+	}
+
+	if _, err := hash.ParseAlgorithm("md6"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
+
+func TestHashReader(t *testing.T) {
+	h, err := hash.HashReader(strings.NewReader("hello"), hash.MD5, hash.CRC32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.MD5 != "5d41402abc4b2a76b9719d911017c592" || h.Get(hash.CRC32) != "3610a686" {
+		t.Errorf("Unexpected digests %+v", h)
+	}
+	if h.SHA1 != "" || !h.Has(hash.MD5, hash.CRC32) || h.Has(hash.SHA1) {
+		t.Errorf("Expected only the requested digests, got %+v", h)
+	}
+
+	all, err := hash.HashReader(strings.NewReader("hello"), hash.Algorithms...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range hash.Algorithms {
+		if all.Get(a) == "" {
+			t.Errorf("Expected a digest for %s", a)
+		}
+	}
+
+	h.Merge(hash.Hash{SHA1: "x"})
+	if h.SHA1 != "x" || h.MD5 == "" {
+		t.Errorf("Expected the digests of both, got %+v", h)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := hash.NewIndex()
+	x.Add("/a", hash.Hash{MD5: "0123456789abcdef0123456789abcdef", SHA2: hash.SHA2{SHA256: "FEDCBA9876543210"}})
+	x.Add("/b", hash.Hash{MD5: "0123456789abcdefffffffffffffffff"})
+
+	tests := []struct {
+		alg   hash.Algorithm
+		value string
+		want  string
+	}{
+		{hash.MD5, "0123456789abcdef0123456789abcdef", "[/a]"},
+		{hash.MD5, "0123456789ABCDEF", "[/a /b]"},
+		{hash.MD5, "0123456789abcdefff", "[/b]"},
+		{hash.SHA256, "fedcba98", "[/a]"},
+		{hash.SHA1, "fedcba98", "[]"},
+		{"", "fedcba98", "[/a]"},
+		{"", "00000000", "[]"},
+	}
+	for _, tt := range tests {
+		got, err := x.Lookup(tt.alg, tt.value)
+		if err != nil || fmt.Sprint(got) != tt.want {
+			t.Errorf("Lookup(%q, %q) = %v, %v, want %s", tt.alg, tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, err := x.Lookup(hash.MD5, "0123"); !errors.Is(err, hash.ErrShortDigest) {
+		t.Errorf("Expected ErrShortDigest, got %v", err)
+	}
+	if _, err := x.Lookup(hash.MD5, "0123456789xyz"); err == nil {
+		t.Error("Expected an error for a digest that isn't hex")
+	}
+
+	// Adding a key again replaces its digests
+	x.Add("/a", hash.Hash{SHA1: "aaaaaaaaaaaaaaaa"})
+	if got, _ := x.Lookup("", "0123456789abcdef"); fmt.Sprint(got) != "[/b]" {
+		t.Errorf("Expected the old digest of /a to be gone, got %v", got)
+	}
+
+	x.Remove("/b")
+	if got, _ := x.Lookup(hash.MD5, "0123456789abcdef"); len(got) != 0 || x.Len() != 1 {
+		t.Errorf("Expected /b to be removed, got %v and %d keys", got, x.Len())
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	alg, value, err := hash.ParseQuery("SHA-256:9F86D081")
+	if err != nil || alg != hash.SHA256 || value != "9f86d081" {
+		t.Errorf("ParseQuery = %q, %q, %v", alg, value, err)
+	}
+
+	alg, value, err = hash.ParseQuery("9f86d081")
+	if err != nil || alg != "" || value != "9f86d081" {
+		t.Errorf("ParseQuery without an algorithm = %q, %q, %v", alg, value, err)
+	}
+
+	if _, _, err := hash.ParseQuery("whirlpool:9f86d081"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
@@ -0,0 +1,180 @@
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MinPrefix is the fewest hex characters a digest can be looked up by, shorter prefixes
+// match too many files to be of use
+const MinPrefix = 8
+
+var ErrShortDigest = fmt.Errorf("a digest needs at least %d hex characters", MinPrefix)
+
+var errNotHex = errors.New("a digest is hex characters")
+
+// Index finds keys by the digests of their hashes, or by the start of one. Digests are put
+// in buckets by their first MinPrefix characters, so a lookup only looks at the few digests
+// in one bucket.
+type Index struct {
+	lock    sync.RWMutex
+	buckets map[bucket][]entry
+	digests map[string][]digest // Of each key, to remove them
+}
+
+type bucket struct {
+	alg    Algorithm
+	prefix string
+}
+
+type entry struct {
+	digest string
+	key    string
+}
+
+type digest struct {
+	alg   Algorithm
+	value string
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{
+		buckets: make(map[bucket][]entry),
+		digests: make(map[string][]digest),
+	}
+}
+
+// Len returns the number of keys with digests in the index
+func (x *Index) Len() int {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	return len(x.digests)
+}
+
+// Add adds the digests of a key, replacing those it had
+func (x *Index) Add(key string, h Hash) {
+	var ds []digest
+	for _, a := range Algorithms {
+		if v := strings.ToLower(h.Get(a)); len(v) >= MinPrefix {
+			ds = append(ds, digest{a, v})
+		}
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.remove(key)
+	if len(ds) == 0 {
+		return
+	}
+
+	x.digests[key] = ds
+	for _, d := range ds {
+		b := bucket{d.alg, d.value[:MinPrefix]}
+		x.buckets[b] = append(x.buckets[b], entry{d.value, key})
+	}
+}
+
+// Remove removes the digests of a key
+func (x *Index) Remove(key string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.remove(key)
+}
+
+func (x *Index) remove(key string) {
+	for _, d := range x.digests[key] {
+		b := bucket{d.alg, d.value[:MinPrefix]}
+		list := x.buckets[b]
+		for n := range list {
+			if list[n].key == key {
+				list[n] = list[len(list)-1]
+				list = list[:len(list)-1]
+				break
+			}
+		}
+
+		if len(list) == 0 {
+			delete(x.buckets, b)
+		} else {
+			x.buckets[b] = list
+		}
+	}
+	delete(x.digests, key)
+}
+
+// Lookup returns the sorted keys with a digest of the algorithm that is value or starts
+// with it. An empty algorithm looks at the digests of every algorithm.
+func (x *Index) Lookup(alg Algorithm, value string) ([]string, error) {
+	value, err := ParseDigest(value)
+	if err != nil {
+		return nil, err
+	}
+
+	algs := Algorithms
+	if alg != "" {
+		if alg, err = ParseAlgorithm(string(alg)); err != nil {
+			return nil, err
+		}
+		algs = []Algorithm{alg}
+	}
+
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	seen := make(map[string]struct{})
+	var keys []string
+	for _, a := range algs {
+		for _, e := range x.buckets[bucket{a, value[:MinPrefix]}] {
+			if _, ok := seen[e.key]; ok || !strings.HasPrefix(e.digest, value) {
+				continue
+			}
+			seen[e.key] = struct{}{}
+			keys = append(keys, e.key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ParseDigest returns a digest or the start of one in lower case, it has to be at least
+// MinPrefix hex characters
+func ParseDigest(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) < MinPrefix {
+		return "", ErrShortDigest
+	}
+
+	for n := 0; n < len(value); n++ {
+		if c := value[n]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return "", errNotHex
+		}
+	}
+	return value, nil
+}
+
+// ParseQuery splits a digest that can start with the algorithm, like "sha256:9f86d08…",
+// into the algorithm and the digest. The algorithm is empty when it isn't there.
+func ParseQuery(s string) (Algorithm, string, error) {
+	var alg Algorithm
+	if n := strings.IndexByte(s, ':'); n >= 0 {
+		a, err := ParseAlgorithm(s[:n])
+		if err != nil {
+			return "", "", err
+		}
+		alg, s = a, s[n+1:]
+	}
+
+	value, err := ParseDigest(s)
+	if err != nil {
+		return "", "", err
+	}
+	return alg, value, nil
+}
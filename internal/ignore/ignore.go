@@ -0,0 +1,254 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Paths are matched without regard to case where the file system usually does the same
+var caseInsensitive = runtime.GOOS == "windows"
+
+// Pattern is a single rule in the .gitignore format
+type Pattern struct {
+	Text    string // The rule as it was written
+	Source  string // Where the rule comes from, like a file or "config"
+	Line    int    // Line in the source, 0 when it is not from a file
+	Base    string // Directory the rule is relative to, empty for rules that apply everywhere
+	Negate  bool   // The rule starts with "!" and includes what an earlier rule excluded
+	DirOnly bool   // The rule ends with "/" and only matches directories
+
+	re *regexp.Regexp
+}
+
+func (p *Pattern) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Source, p.Line, p.Text)
+	}
+	return fmt.Sprintf("%s: %s", p.Source, p.Text)
+}
+
+// ParsePattern parses a rule in the .gitignore format. It returns nil for blank lines and
+// comments, which are not rules.
+func ParsePattern(text, base, source string, line int) (*Pattern, error) {
+	p := &Pattern{
+		Text:   text,
+		Source: source,
+		Line:   line,
+		Base:   strings.TrimSuffix(base, "/"),
+	}
+
+	glob := trimTrailingSpace(strings.TrimSuffix(text, "\r"))
+	if glob == "" || glob[0] == '#' {
+		return nil, nil
+	}
+
+	if glob[0] == '!' {
+		p.Negate = true
+		glob = glob[1:]
+	}
+
+	if strings.HasSuffix(glob, "/") {
+		p.DirOnly = true
+		glob = strings.TrimRight(glob, "/")
+	}
+
+	if glob == "" {
+		return nil, nil
+	}
+
+	// A slash anywhere but at the end ties the rule to the base directory
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	re, err := compile(glob, anchored)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", text, err)
+	}
+	p.re = re
+
+	return p, nil
+}
+
+// Parse reads the rules of a .gitignore file in the directory base
+func Parse(r io.Reader, base, source string) ([]*Pattern, error) {
+	var patterns []*Pattern
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		p, err := ParsePattern(scanner.Text(), base, source, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", source, line, err)
+		}
+
+		if p != nil {
+			patterns = append(patterns, p)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
+
+// Match reports whether the rule matches path, paths use "/" as the separator
+func (p *Pattern) Match(path string, isDir bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+
+	rel, ok := p.relative(path)
+	if !ok {
+		return false
+	}
+
+	return p.re.MatchString(rel)
+}
+
+// relative returns path relative to the base of the rule
+func (p *Pattern) relative(path string) (string, bool) {
+	if p.Base == "" {
+		return strings.TrimPrefix(path, "/"), true
+	}
+
+	if len(path) <= len(p.Base) || path[len(p.Base)] != '/' || !hasPrefix(path, p.Base) {
+		return "", false
+	}
+
+	return path[len(p.Base)+1:], true
+}
+
+func hasPrefix(s, prefix string) bool {
+	if caseInsensitive {
+		return strings.EqualFold(s[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(s, prefix)
+}
+
+// trimTrailingSpace removes trailing spaces unless they are escaped with a backslash
+func trimTrailingSpace(s string) string {
+	for strings.HasSuffix(s, " ") && !strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// compile translates a glob into a regular expression for a path relative to the base
+func compile(glob string, anchored bool) (*regexp.Regexp, error) {
+	return globRegexp(glob, anchored, caseInsensitive)
+}
+
+// Glob compiles a glob with the syntax of the rules, like *.tar.gz or **/testdata/*.json,
+// into a regexp that ignores case. It matches a whole name, or the end of a path from any
+// directory down unless the glob starts with "/".
+func Glob(glob string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(glob, "/") {
+		return globRegexp(glob, true, true)
+	}
+	return globRegexp(glob, false, true)
+}
+
+func globRegexp(glob string, anchored, ignoreCase bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+
+	if ignoreCase {
+		b.WriteString("(?i)")
+	}
+
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(^|/)")
+	}
+
+	for i := 0; i < len(glob); {
+		c := glob[i]
+
+		switch {
+		// "**/" at the start or after a slash matches any number of directories
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			b.WriteString("(.*/)?")
+			i += 3
+
+		// "/**" at the end matches everything inside
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			b.WriteString(".+")
+			i += 2
+
+		case c == '*':
+			for i < len(glob) && glob[i] == '*' {
+				i++
+			}
+			b.WriteString("[^/]*")
+
+		case c == '?':
+			b.WriteString("[^/]")
+			i++
+
+		case c == '[':
+			class, n := translateClass(glob[i:])
+			if n == 0 {
+				b.WriteString(`\[`)
+				i++
+				continue
+			}
+			b.WriteString(class)
+			i += n
+
+		case c == '\\' && i+1 < len(glob):
+			b.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i += 2
+
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			i++
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// translateClass translates a bracket expression like "[a-z]" or "[!0-9]" at the start of s and
+// returns how many bytes it used, 0 if there is no closing bracket
+func translateClass(s string) (string, int) {
+	var b strings.Builder
+	b.WriteByte('[')
+
+	i := 1
+	if i < len(s) && (s[i] == '!' || s[i] == '^') {
+		b.WriteByte('^')
+		i++
+	}
+
+	// A "]" right after the opening bracket is part of the class
+	first := true
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ']' && !first:
+			b.WriteByte(']')
+			return b.String(), i + 1
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		case c == '[' || c == ']' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+		first = false
+	}
+
+	return "", 0
+}
@@ -0,0 +1,146 @@
+package ignore_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/ignore"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// Without a slash the name matches at any depth
+		{"*.log", "", "/var/log/syslog.log", false, true},
+		{"*.log", "", "/var/log/syslog.log.1", false, false},
+		{"temp", "", "/home/user/temp", true, true},
+		{"temp", "", "/home/user/contemporary", true, false},
+		{"pagefile.sys", "", "C:/pagefile.sys", false, true},
+
+		// A slash ties it to the base
+		{"/build", "/src", "/src/build", true, true},
+		{"/build", "/src", "/src/app/build", true, false},
+		{"docs/*.md", "/src", "/src/docs/readme.md", false, true},
+		{"docs/*.md", "/src", "/src/docs/api/readme.md", false, false},
+		{"C:/Windows/", "", "C:/Windows", true, true},
+		{"C:/Windows/", "", "C:/WindowsApps", true, false},
+
+		// Only directories
+		{"cache/", "", "/home/user/cache", true, true},
+		{"cache/", "", "/home/user/cache", false, false},
+
+		// Double stars
+		{"**/node_modules", "/src", "/src/a/b/node_modules", true, true},
+		{"**/node_modules", "/src", "/src/node_modules", true, true},
+		{"a/**/z", "/src", "/src/a/z", false, true},
+		{"a/**/z", "/src", "/src/a/b/c/z", false, true},
+		{"out/**", "/src", "/src/out/x/y", false, true},
+		{"out/**", "/src", "/src/out", true, false},
+
+		// Wildcards and classes
+		{"file?.txt", "", "/file1.txt", false, true},
+		{"file?.txt", "", "/file10.txt", false, false},
+		{"[a-c].txt", "", "/b.txt", false, true},
+		{"[!a-c].txt", "", "/b.txt", false, false},
+		{"[!a-c].txt", "", "/d.txt", false, true},
+		{`\#notacomment`, "", "/#notacomment", false, true},
+
+		// Not below the base
+		{"*.log", "/src", "/other/x.log", false, false},
+		{"*.log", "/src", "/srcx/x.log", false, false},
+	}
+
+	for _, tt := range tests {
+		p, err := ignore.ParsePattern(tt.pattern, tt.base, "test", 0)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+
+		if got := p.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q in %q matching %q (dir %v): expected %v, got %v", tt.pattern, tt.base, tt.path, tt.isDir, tt.want, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := "# comment\n\n*.log\n!keep.log\ntrailing   \nescaped\\ \n"
+
+	patterns, err := ignore.Parse(strings.NewReader(input), "/src", "/src/.gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patterns) != 4 {
+		t.Fatalf("Expected 4 patterns, got %d", len(patterns))
+	}
+
+	if !patterns[1].Negate || patterns[1].Line != 4 {
+		t.Errorf("Expected a negated pattern on line 4, got %+v", patterns[1])
+	}
+
+	if !patterns[2].Match("/src/trailing", false) {
+		t.Error("Expected trailing spaces to be ignored")
+	}
+
+	if !patterns[3].Match("/src/escaped ", false) {
+		t.Error("Expected an escaped trailing space to be kept")
+	}
+
+	if got := patterns[1].String(); got != "/src/.gitignore:4: !keep.log" {
+		t.Errorf("Unexpected String() %q", got)
+	}
+}
+
+func mustParse(t *testing.T, input, base string) []*ignore.Pattern {
+	t.Helper()
+
+	patterns, err := ignore.Parse(strings.NewReader(input), base, base+"/.gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return patterns
+}
+
+func TestMatcher(t *testing.T) {
+	m := ignore.NewMatcher(mustParse(t, "*.tmp\nnode_modules/\n", ""))
+	m.SetDir("/src", mustParse(t, "*.log\n!important.log\n!keep.tmp\n", "/src"))
+	m.SetDir("/src/sub", mustParse(t, "important.log\n", "/src/sub"))
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/src/a.log", false, true},
+		{"/src/important.log", false, false},
+		{"/src/sub/important.log", false, true},   // The deeper file wins
+		{"/src/keep.tmp", false, false},           // An ignore file can include what the config excludes
+		{"/other/a.log", false, false},            // Rules of /src only apply below it
+		{"/other/a.tmp", false, true},             // Rules that apply everywhere
+		{"/src/node_modules/x/y.js", false, true}, // Excluded through the directory above
+	}
+
+	for _, tt := range tests {
+		got, p := m.Excluded(tt.path, tt.isDir)
+		if got != tt.want {
+			t.Errorf("%s: expected %v, got %v (rule %v)", tt.path, tt.want, got, p)
+		}
+	}
+
+	// Why it is excluded
+	_, p := m.Excluded("/src/sub/important.log", false)
+	if p == nil || p.Source != "/src/sub/.gitignore" || p.Line != 1 {
+		t.Errorf("Expected the rule from /src/sub/.gitignore, got %v", p)
+	}
+
+	m.SetDir("/src", nil)
+	if got, _ := m.Excluded("/src/a.log", false); got {
+		t.Error("Expected the rules of /src to be removed")
+	}
+}
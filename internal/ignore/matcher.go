@@ -0,0 +1,88 @@
+package ignore
+
+import (
+	"strings"
+	"sync"
+)
+
+// Matcher decides whether paths are excluded by rules that apply everywhere and by the rules
+// of ignore files in the directories above them. Like git the last rule that matches wins, the
+// rules of a deeper directory come after those of the directories above it.
+type Matcher struct {
+	global []*Pattern
+	dirs   sync.Map // Directory to the rules of its ignore files
+}
+
+// NewMatcher returns a Matcher with rules that apply everywhere
+func NewMatcher(global []*Pattern) *Matcher {
+	return &Matcher{global: global}
+}
+
+// SetDir replaces the rules of the ignore files in dir, no rules removes them
+func (m *Matcher) SetDir(dir string, patterns []*Pattern) {
+	dir = strings.TrimSuffix(dir, "/")
+
+	if len(patterns) == 0 {
+		m.dirs.Delete(dir)
+		return
+	}
+
+	m.dirs.Store(dir, patterns)
+}
+
+// Match returns the last rule matching path, nil when none does. It doesn't look at the
+// directories above path, use Excluded for that.
+func (m *Matcher) Match(path string, isDir bool) *Pattern {
+	var match *Pattern
+
+	for _, p := range m.global {
+		if p.Match(path, isDir) {
+			match = p
+		}
+	}
+
+	for _, dir := range parents(path) {
+		v, ok := m.dirs.Load(dir)
+		if !ok {
+			continue
+		}
+
+		for _, p := range v.([]*Pattern) {
+			if p.Match(path, isDir) {
+				match = p
+			}
+		}
+	}
+
+	return match
+}
+
+// Excluded reports whether path is excluded and by which rule. A path is also excluded when
+// a directory above it is, even if a rule includes the path again.
+func (m *Matcher) Excluded(path string, isDir bool) (bool, *Pattern) {
+	dirs := parents(path)
+
+	// The first entry is the root, which can't be excluded by a rule
+	for n := 1; n < len(dirs); n++ {
+		if p := m.Match(dirs[n], true); p != nil && !p.Negate {
+			return true, p
+		}
+	}
+
+	p := m.Match(path, isDir)
+
+	return p != nil && !p.Negate, p
+}
+
+// parents returns the directories above path from the top down, "/a/b/c" gives "", "/a" and "/a/b"
+func parents(path string) []string {
+	var dirs []string
+
+	for n := 0; n < len(path); n++ {
+		if path[n] == '/' {
+			dirs = append(dirs, path[:n])
+		}
+	}
+
+	return dirs
+}
@@ -0,0 +1,133 @@
+package indexing
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TechMDW/indexing/internal/archive"
+)
+
+// indexArchive indexes the entries of an archive as virtual children of it, when it is
+// enabled. Entries indexed before that are not in the archive anymore are removed.
+func (i *Index) indexArchive(file File) {
+	if !i.opts.Archive.Enabled || file.IsDir || !archive.IsArchive(file.Name) {
+		return
+	}
+
+	limits := archive.Limits{
+		MaxDepth:   i.opts.Archive.MaxDepth,
+		MaxEntries: i.opts.Archive.MaxEntries,
+		MaxSize:    int64(i.opts.Archive.MaxSize),
+		MaxRatio:   int64(i.opts.Archive.MaxRatio),
+	}
+
+	found := make(map[string]struct{})
+	var excludedDirs []string
+
+	err := archive.Walk(file.FullPath, limits, func(e archive.Entry) error {
+		key := file.FullPath + archive.Separator + e.Path
+
+		// Excluded directories are not walked on disk either
+		for _, dir := range excludedDirs {
+			if strings.HasPrefix(key, dir) {
+				return nil
+			}
+		}
+		if i.isExcluded(key, e.IsDir) {
+			if e.IsDir {
+				excludedDirs = append(excludedDirs, key+"/")
+			}
+			return nil
+		}
+
+		found[key] = struct{}{}
+
+		f := archiveFile(key, e)
+		if curr, err := i.GetIndex(key); err == nil && curr.IsDir == f.IsDir && curr.Size == f.Size && curr.ModTime.Equal(f.ModTime) {
+			return nil
+		}
+		return i.StoreIndex(key, f)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, archive.ErrUnsupported) {
+		// What was found before a limit or a broken entry is still indexed
+		log.Println(err)
+	}
+
+	i.removeArchiveEntries(file.FullPath, found)
+}
+
+// archiveFile returns the File of an entry in an archive. The parent of an entry at the
+// top of the archive is the archive itself.
+func archiveFile(key string, e archive.Entry) File {
+	dir := key[:strings.LastIndex(key, "/")]
+	name := key[len(dir)+1:]
+
+	return File{
+		Name:      name,
+		Extension: filepath.Ext(name),
+		Path:      strings.TrimSuffix(dir, "!"),
+		FullPath:  key,
+		PathInfo:  derivePathInfo(key),
+		Size:      e.Size,
+		IsHidden:  name[0] == '.',
+		IsDir:     e.IsDir,
+		ModTime:   e.ModTime,
+		Permissions: Permissions{
+			Permission: e.Mode,
+		},
+	}
+}
+
+// hasArchiveEntries reports whether anything in the archive at path is indexed
+func (i *Index) hasArchiveEntries(path string) bool {
+	i.archiveLock.Lock()
+	defer i.archiveLock.Unlock()
+
+	return len(i.archiveEntries[path]) > 0
+}
+
+// removeArchiveEntries removes the entries of the archive at path from the index, except
+// the ones in keep
+func (i *Index) removeArchiveEntries(path string, keep map[string]struct{}) {
+	i.archiveLock.Lock()
+	var stale []string
+	for key := range i.archiveEntries[path] {
+		if _, ok := keep[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+	i.archiveLock.Unlock()
+
+	for _, key := range stale {
+		i.RemoveIndex(key)
+	}
+}
+
+// trackArchiveEntry keeps what is indexed from each archive, by the path of the outermost one
+func (i *Index) trackArchiveEntry(key string, add bool) {
+	path, _, ok := archive.Split(key)
+	if !ok {
+		return
+	}
+
+	i.archiveLock.Lock()
+	defer i.archiveLock.Unlock()
+
+	entries := i.archiveEntries[path]
+	if add {
+		if entries == nil {
+			entries = make(map[string]struct{})
+			i.archiveEntries[path] = entries
+		}
+		entries[key] = struct{}{}
+		return
+	}
+
+	delete(entries, key)
+	if len(entries) == 0 {
+		delete(i.archiveEntries, path)
+	}
+}
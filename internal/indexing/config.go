@@ -0,0 +1,629 @@
+package indexing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/ignore"
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+const (
+	ConfigFileName = "config.json"
+
+	// Prefix of the environment variables that override the config file
+	EnvPrefix = "INDEXING_"
+)
+
+// Duration is a time.Duration that is written as "30s" or "5m" in the config file
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New(`expected a duration like "30s" or "5m"`)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// Size is a number of bytes that can be written as 1048576 or "1MB" in the config file
+type Size int64
+
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return errors.New(`expected a size like 1048576 or "1MB"`)
+	}
+
+	v, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+
+	*s = Size(v)
+	return nil
+}
+
+// ParseSize parses a size like "512", "10KB", "1.5MB" or "2GiB" into bytes
+func ParseSize(s string) (int64, error) {
+	return query.ParseSize(s)
+}
+
+type ScanOptions struct {
+	Interval        Duration `json:"interval"`        // How often the roots are crawled for new files
+	RemovedInterval Duration `json:"removedInterval"` // How often the index is checked for removed files
+	RootsInterval   Duration `json:"rootsInterval"`   // How often new drives or mounts are looked for
+}
+
+// WatchOptions configures how changes are picked up after the first crawl. When watching,
+// the roots are only crawled again if the watcher lost events.
+type WatchOptions struct {
+	Enabled      bool     `json:"enabled"`
+	PollInterval Duration `json:"pollInterval"` // Used where there is no native watcher like inotify, longer with many directories
+}
+
+// PersistOptions configures how the index is kept on disk. Changes are appended to a journal
+// that is synced to disk often, and compacted into a full snapshot of the index once it grows big.
+type PersistOptions struct {
+	MinChanges     int      `json:"minChanges"`     // Sync the journal once this many files changed
+	Interval       Duration `json:"interval"`       // Sync the journal at least this often when anything changed
+	CheckInterval  Duration `json:"checkInterval"`  // How often the thresholds are checked
+	CompactChanges int      `json:"compactChanges"` // Write a new snapshot once the journal has this many changes
+	CompactSize    Size     `json:"compactSize"`    // Write a new snapshot once the journal is this big, 0 means no limit
+}
+
+// HashOptions is the hash policy. Files are hashed in the background once they are indexed,
+// with only the algorithms listed.
+type HashOptions struct {
+	Enabled    bool     `json:"enabled"`
+	MaxSize    Size     `json:"maxSize"`    // Files bigger than this are not hashed, 0 means no limit
+	Algorithms []string `json:"algorithms"` // Like "md5" or "sha256", see hash.Algorithms
+}
+
+// ContentOptions configures indexing the words in text files, so content:"..." queries can
+// find them. It reads every matching file, so it is off unless enabled.
+type ContentOptions struct {
+	Enabled    bool     `json:"enabled"`
+	MaxSize    Size     `json:"maxSize"`    // Files bigger than this are not indexed, 0 means no limit
+	Extensions []string `json:"extensions"` // Files with these extensions are indexed if they look like text
+}
+
+// ArchiveOptions configures indexing the entries of zip, jar and tar archives as virtual
+// children of the archive, like "/a/b.zip!/dir/file.txt". The limits keep archives that
+// expand into far more than they look like from taking over, 0 gets the default.
+type ArchiveOptions struct {
+	Enabled    bool `json:"enabled"`
+	MaxDepth   int  `json:"maxDepth"`   // How many levels of archives in archives are opened, 0 opens none
+	MaxEntries int  `json:"maxEntries"` // Entries indexed from one archive
+	MaxSize    Size `json:"maxSize"`    // Bytes decompressed to list one archive
+	MaxRatio   int  `json:"maxRatio"`   // How many times bigger than compressed an archive may get
+}
+
+// LinuxOptions configures which mounts are indexed on Linux
+type LinuxOptions struct {
+	IgnoreFilesystems []string `json:"ignoreFilesystems"` // Types of the mounts that are never indexed, like proc
+	CrossDevices      bool     `json:"crossDevices"`      // Crawl into other mounts instead of indexing them as roots of their own
+}
+
+// DefaultOptions returns the options used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		Scan: ScanOptions{
+			Interval:        Duration(30 * time.Second),
+			RemovedInterval: Duration(5 * time.Minute),
+			RootsInterval:   Duration(10 * time.Second),
+		},
+		Watch: WatchOptions{
+			Enabled:      true,
+			PollInterval: Duration(30 * time.Second),
+		},
+		Persist: PersistOptions{
+			MinChanges:     50,
+			Interval:       Duration(1 * time.Minute),
+			CheckInterval:  Duration(10 * time.Second),
+			CompactChanges: 100000,
+			CompactSize:    64 * hash.MB,
+		},
+		Hash: HashOptions{
+			Enabled:    true,
+			MaxSize:    hash.DefaultMaxSize,
+			Algorithms: append([]string{}, DefaultHashAlgorithms...),
+		},
+		Content: ContentOptions{
+			MaxSize:    1 * hash.MB,
+			Extensions: append([]string{}, DefaultContentExtensions...),
+		},
+		Archive: ArchiveOptions{
+			MaxDepth:   2,
+			MaxEntries: 10000,
+			MaxSize:    1 * hash.GB,
+			MaxRatio:   100,
+		},
+		Linux: LinuxOptions{
+			IgnoreFilesystems: append([]string{}, DefaultIgnoredFilesystems...),
+		},
+		IgnoreFiles: append([]string{}, DefaultIgnoreFiles...),
+		MaxResults:  MaxResults,
+		Workers:     MaxGoRoutines,
+	}
+}
+
+// withDefaults fills in the zero durations and limits with the defaults
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+
+	durations := []struct{ v, def *Duration }{
+		{&o.Scan.Interval, &def.Scan.Interval},
+		{&o.Scan.RemovedInterval, &def.Scan.RemovedInterval},
+		{&o.Scan.RootsInterval, &def.Scan.RootsInterval},
+		{&o.Watch.PollInterval, &def.Watch.PollInterval},
+		{&o.Persist.Interval, &def.Persist.Interval},
+		{&o.Persist.CheckInterval, &def.Persist.CheckInterval},
+	}
+	for _, d := range durations {
+		if *d.v == 0 {
+			*d.v = *d.def
+		}
+	}
+
+	if o.Persist.MinChanges == 0 {
+		o.Persist.MinChanges = def.Persist.MinChanges
+	}
+
+	if o.Persist.CompactChanges == 0 {
+		o.Persist.CompactChanges = def.Persist.CompactChanges
+	}
+
+	if o.Hash.Algorithms == nil {
+		o.Hash.Algorithms = def.Hash.Algorithms
+	}
+
+	if o.Content.Extensions == nil {
+		o.Content.Extensions = def.Content.Extensions
+	}
+
+	if o.Archive.MaxEntries == 0 {
+		o.Archive.MaxEntries = def.Archive.MaxEntries
+	}
+
+	if o.Archive.MaxSize == 0 {
+		o.Archive.MaxSize = def.Archive.MaxSize
+	}
+
+	if o.Archive.MaxRatio == 0 {
+		o.Archive.MaxRatio = def.Archive.MaxRatio
+	}
+
+	if o.Linux.IgnoreFilesystems == nil {
+		o.Linux.IgnoreFilesystems = def.Linux.IgnoreFilesystems
+	}
+
+	// An empty list means no ignore files, only a missing one gets the defaults
+	if o.IgnoreFiles == nil {
+		o.IgnoreFiles = def.IgnoreFiles
+	}
+
+	if o.MaxResults == 0 {
+		o.MaxResults = def.MaxResults
+	}
+
+	if o.Workers == 0 {
+		o.Workers = def.Workers
+	}
+
+	return o
+}
+
+// ConfigPath returns where the config file is looked for
+func ConfigPath() (string, error) {
+	dir, err := getTechMDWDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, ConfigFileName), nil
+}
+
+// LoadConfig reads the config file at path on top of the default options and applies the
+// environment overrides. An empty path uses ConfigPath, a missing file is not an error.
+func LoadConfig(path string) (Options, error) {
+	opts := DefaultOptions()
+
+	if path == "" {
+		p, err := ConfigPath()
+		if err != nil {
+			return opts, err
+		}
+		path = p
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return opts, err
+	}
+
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&opts); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return opts, fmt.Errorf("config %s: %s: expected %s", path, typeErr.Field, typeErr.Type)
+			}
+
+			// The values that decode themselves, like durations, don't know their key
+			var value interface{}
+			if json.Unmarshal(data, &value) == nil {
+				if key, ok := badKey(reflect.TypeOf(opts), value, ""); ok {
+					return opts, fmt.Errorf("config %s: %s: %w", path, key, err)
+				}
+			}
+			return opts, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	if err := opts.ApplyEnv(); err != nil {
+		return opts, err
+	}
+
+	return opts, opts.Validate()
+}
+
+// badKey returns the key of the first value of the decoded JSON that the type doesn't decode
+func badKey(t reflect.Type, value interface{}, key string) (string, bool) {
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		if err := reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(b); err != nil {
+			return key, true
+		}
+		return "", false
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, _ := value.(map[string]interface{})
+		for n := 0; n < t.NumField(); n++ {
+			field := t.Field(n)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if v, ok := object[name]; ok {
+				if k, ok := badKey(field.Type, v, joinKey(key, name)); ok {
+					return k, true
+				}
+			}
+		}
+	case reflect.Map:
+		object, _ := value.(map[string]interface{})
+		for name, v := range object {
+			if k, ok := badKey(t.Elem(), v, joinKey(key, name)); ok {
+				return k, true
+			}
+		}
+	case reflect.Slice:
+		array, _ := value.([]interface{})
+		for n, v := range array {
+			if k, ok := badKey(t.Elem(), v, fmt.Sprintf("%s[%d]", key, n)); ok {
+				return k, true
+			}
+		}
+	case reflect.Pointer:
+		return badKey(t.Elem(), value, key)
+	}
+
+	return "", false
+}
+
+func joinKey(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
+
+// setting is an option that can be overridden from the environment or a flag
+type setting struct {
+	key   string
+	usage string
+	set   func(o *Options, v string) error
+}
+
+var settings = []setting{
+	{"roots", "list of directories to index separated by the OS path list separator", func(o *Options, v string) error {
+		o.Roots = nil
+		for _, p := range filepath.SplitList(v) {
+			if p != "" {
+				o.Roots = append(o.Roots, Root{Path: p})
+			}
+		}
+		return nil
+	}},
+	{"exclude", "comma separated paths that are not indexed in the .gitignore format", func(o *Options, v string) error {
+		o.Exclude = nil
+		for _, e := range strings.Split(v, ",") {
+			if e != "" {
+				o.Exclude = append(o.Exclude, e)
+			}
+		}
+		return nil
+	}},
+	{"ignoreFiles", "comma separated names of ignore files whose rules apply where they are found", func(o *Options, v string) error {
+		o.IgnoreFiles = []string{}
+		for _, name := range strings.Split(v, ",") {
+			if name != "" {
+				o.IgnoreFiles = append(o.IgnoreFiles, name)
+			}
+		}
+		return nil
+	}},
+	{"scan.interval", "how often the roots are crawled for new files", setDuration(func(o *Options) *Duration { return &o.Scan.Interval })},
+	{"scan.removedInterval", "how often the index is checked for removed files", setDuration(func(o *Options) *Duration { return &o.Scan.RemovedInterval })},
+	{"scan.rootsInterval", "how often new drives or mounts are looked for", setDuration(func(o *Options) *Duration { return &o.Scan.RootsInterval })},
+	{"watch.enabled", "apply file system events instead of crawling the roots over and over", setBool(func(o *Options) *bool { return &o.Watch.Enabled })},
+	{"watch.pollInterval", "how often directories are listed where there is no native watcher", setDuration(func(o *Options) *Duration { return &o.Watch.PollInterval })},
+	{"persist.minChanges", "sync the journal once this many files changed", setInt(func(o *Options) *int { return &o.Persist.MinChanges })},
+	{"persist.interval", "sync the journal at least this often when anything changed", setDuration(func(o *Options) *Duration { return &o.Persist.Interval })},
+	{"persist.checkInterval", "how often the persistence thresholds are checked", setDuration(func(o *Options) *Duration { return &o.Persist.CheckInterval })},
+	{"persist.compactChanges", "write a new snapshot once the journal has this many changes", setInt(func(o *Options) *int { return &o.Persist.CompactChanges })},
+	{"persist.compactSize", "write a new snapshot once the journal is this big, 0 means no limit", setSize(func(o *Options) *Size { return &o.Persist.CompactSize })},
+	{"hash.enabled", "hash the content of indexed files", setBool(func(o *Options) *bool { return &o.Hash.Enabled })},
+	{"hash.maxSize", "files bigger than this are not hashed, 0 means no limit", setSize(func(o *Options) *Size { return &o.Hash.MaxSize })},
+	{"hash.algorithms", "comma separated algorithms files are hashed with, like md5,sha256", func(o *Options, v string) error {
+		o.Hash.Algorithms = []string{}
+		for _, name := range strings.Split(v, ",") {
+			if name != "" {
+				o.Hash.Algorithms = append(o.Hash.Algorithms, name)
+			}
+		}
+		return nil
+	}},
+	{"content.enabled", "index the words in text files for content: queries", setBool(func(o *Options) *bool { return &o.Content.Enabled })},
+	{"content.maxSize", "files bigger than this are not content indexed, 0 means no limit", setSize(func(o *Options) *Size { return &o.Content.MaxSize })},
+	{"content.extensions", "comma separated extensions of the files that are content indexed", func(o *Options, v string) error {
+		o.Content.Extensions = []string{}
+		for _, ext := range strings.Split(v, ",") {
+			if ext != "" {
+				o.Content.Extensions = append(o.Content.Extensions, ext)
+			}
+		}
+		return nil
+	}},
+	{"archive.enabled", "index the entries of zip, jar and tar archives", setBool(func(o *Options) *bool { return &o.Archive.Enabled })},
+	{"archive.maxDepth", "how many levels of archives in archives are opened", setInt(func(o *Options) *int { return &o.Archive.MaxDepth })},
+	{"archive.maxEntries", "entries indexed from one archive", setInt(func(o *Options) *int { return &o.Archive.MaxEntries })},
+	{"archive.maxSize", "bytes decompressed to list one archive", setSize(func(o *Options) *Size { return &o.Archive.MaxSize })},
+	{"archive.maxRatio", "how many times bigger than compressed an archive may get", setInt(func(o *Options) *int { return &o.Archive.MaxRatio })},
+	{"linux.ignoreFilesystems", "comma separated types of the mounts that are never indexed, like proc,tmpfs", func(o *Options, v string) error {
+		o.Linux.IgnoreFilesystems = []string{}
+		for _, t := range strings.Split(v, ",") {
+			if t != "" {
+				o.Linux.IgnoreFilesystems = append(o.Linux.IgnoreFilesystems, t)
+			}
+		}
+		return nil
+	}},
+	{"linux.crossDevices", "crawl into other mounts instead of indexing them as roots of their own", setBool(func(o *Options) *bool { return &o.Linux.CrossDevices })},
+	{"maxResults", "maximum number of search results", setInt(func(o *Options) *int { return &o.MaxResults })},
+	{"profile", "scoring profile of the searches that don't pick one", func(o *Options, v string) error {
+		o.Profile = v
+		return nil
+	}},
+	{"workers", "number of files indexed at the same time", setInt(func(o *Options) *int { return &o.Workers })},
+	{"storagePath", "where the index is stored", func(o *Options, v string) error {
+		o.StoragePath = v
+		return nil
+	}},
+}
+
+func setDuration(field func(o *Options) *Duration) func(o *Options, v string) error {
+	return func(o *Options, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*field(o) = Duration(d)
+		return nil
+	}
+}
+
+func setSize(field func(o *Options) *Size) func(o *Options, v string) error {
+	return func(o *Options, v string) error {
+		n, err := ParseSize(v)
+		if err != nil {
+			return err
+		}
+		*field(o) = Size(n)
+		return nil
+	}
+}
+
+func setBool(field func(o *Options) *bool) func(o *Options, v string) error {
+	return func(o *Options, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*field(o) = b
+		return nil
+	}
+}
+
+func setInt(field func(o *Options) *int) func(o *Options, v string) error {
+	return func(o *Options, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*field(o) = n
+		return nil
+	}
+}
+
+// EnvName returns the environment variable for a config key, "scan.interval" becomes INDEXING_SCAN_INTERVAL
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ApplyEnv overrides the options with the INDEXING_* environment variables that are set
+func (o *Options) ApplyEnv() error {
+	for _, s := range settings {
+		v, ok := os.LookupEnv(EnvName(s.key))
+		if !ok {
+			continue
+		}
+
+		if err := s.set(o, v); err != nil {
+			return fmt.Errorf("%s (%s): %w", s.key, EnvName(s.key), err)
+		}
+	}
+
+	return nil
+}
+
+// RegisterFlags adds a flag for every config key to fs that overrides the options when parsed
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	for _, s := range settings {
+		s := s
+		fs.Func(s.key, s.usage, func(v string) error {
+			return s.set(o, v)
+		})
+	}
+}
+
+// Validate checks the options and names the key of the first bad value
+func (o Options) Validate() error {
+	for n, root := range o.Roots {
+		if root.Path == "" {
+			return fmt.Errorf("roots[%d].path: must not be empty", n)
+		}
+		if root.MaxDepth < 0 {
+			return fmt.Errorf("roots[%d].maxDepth: must not be negative", n)
+		}
+	}
+
+	for n, e := range o.Exclude {
+		if _, err := ignore.ParsePattern(e, "", "config", 0); err != nil {
+			return fmt.Errorf("exclude[%d]: %w", n, err)
+		}
+	}
+
+	for n, name := range o.IgnoreFiles {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("ignoreFiles[%d]: must be a file name", n)
+		}
+	}
+
+	durations := []struct {
+		key string
+		d   Duration
+	}{
+		{"scan.interval", o.Scan.Interval},
+		{"scan.removedInterval", o.Scan.RemovedInterval},
+		{"scan.rootsInterval", o.Scan.RootsInterval},
+		{"watch.pollInterval", o.Watch.PollInterval},
+		{"persist.interval", o.Persist.Interval},
+		{"persist.checkInterval", o.Persist.CheckInterval},
+	}
+	for _, d := range durations {
+		if d.d <= 0 {
+			return fmt.Errorf("%s: must be positive", d.key)
+		}
+	}
+
+	if o.Persist.MinChanges <= 0 {
+		return errors.New("persist.minChanges: must be positive")
+	}
+
+	if o.Persist.CompactChanges <= 0 {
+		return errors.New("persist.compactChanges: must be positive")
+	}
+
+	if o.Persist.CompactSize < 0 {
+		return errors.New("persist.compactSize: must not be negative")
+	}
+
+	if o.Hash.MaxSize < 0 {
+		return errors.New("hash.maxSize: must not be negative")
+	}
+
+	for n, name := range o.Hash.Algorithms {
+		if _, err := hash.ParseAlgorithm(name); err != nil {
+			return fmt.Errorf("hash.algorithms[%d]: %w", n, err)
+		}
+	}
+
+	if o.Content.MaxSize < 0 {
+		return errors.New("content.maxSize: must not be negative")
+	}
+
+	if o.Archive.MaxDepth < 0 {
+		return errors.New("archive.maxDepth: must not be negative")
+	}
+
+	if o.Archive.MaxEntries < 0 {
+		return errors.New("archive.maxEntries: must not be negative")
+	}
+
+	if o.Archive.MaxSize < 0 {
+		return errors.New("archive.maxSize: must not be negative")
+	}
+
+	if o.Archive.MaxRatio < 0 {
+		return errors.New("archive.maxRatio: must not be negative")
+	}
+
+	if o.MaxResults <= 0 {
+		return errors.New("maxResults: must be positive")
+	}
+
+	names := make([]string, 0, len(o.Profiles))
+	for name := range o.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := o.Profiles[name].validate("profiles." + name); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := o.Profiles[o.Profile]; o.Profile != "" && !ok {
+		return fmt.Errorf("profile: no profile named %q in profiles", o.Profile)
+	}
+
+	if o.Workers <= 0 {
+		return errors.New("workers: must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package indexing_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/indexing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), indexing.ConfigFileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"roots": [{"path": "/srv/projects", "maxDepth": 3, "hash": false}],
+		"scan": {"interval": "1m"},
+		"hash": {"maxSize": "10MB"}
+	}`)
+
+	opts, err := indexing.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opts.Roots) != 1 || opts.Roots[0].Path != "/srv/projects" || opts.Roots[0].MaxDepth != 3 {
+		t.Errorf("Unexpected roots %+v", opts.Roots)
+	}
+
+	if time.Duration(opts.Scan.Interval) != time.Minute {
+		t.Errorf("Expected scan interval 1m, but got %s", time.Duration(opts.Scan.Interval))
+	}
+
+	// Not in the file so it keeps the default
+	if time.Duration(opts.Scan.RemovedInterval) != 5*time.Minute {
+		t.Errorf("Expected removed interval 5m, but got %s", time.Duration(opts.Scan.RemovedInterval))
+	}
+
+	if opts.Hash.MaxSize != 10<<20 {
+		t.Errorf("Expected hash max size 10MB, but got %d", opts.Hash.MaxSize)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	opts, err := indexing.LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.MaxResults != indexing.MaxResults {
+		t.Errorf("Expected default max results, but got %d", opts.MaxResults)
+	}
+}
+
+func TestLoadConfigErrorsNameTheKey(t *testing.T) {
+	tests := []struct {
+		config string
+		key    string
+	}{
+		{`{"scan": {"interval": "-1s"}}`, "scan.interval"},
+		{`{"ignoreFiles": ["sub/.gitignore"]}`, "ignoreFiles[0]"},
+		{`{"roots": [{"path": "/tmp", "maxDepth": -1}]}`, "roots[0].maxDepth"},
+		{`{"persist": {"minChanges": "many"}}`, "persist.minChanges"},
+		{`{"scan": {"interval": "soon"}}`, "scan.interval"},
+		{`{"hash": {"maxSize": "lots"}}`, "hash.maxSize"},
+		{`{"hash": {"maxSize": true}}`, "hash.maxSize"},
+		{`{"maxResult": 10}`, "maxResult"},
+		{`{"hash": {"algorithms": ["md5", "md6"]}}`, "hash.algorithms[1]"},
+		{`{"profiles": {"code": {"rules": {"wrod": 2}}}}`, "profiles.code.rules.wrod"},
+		{`{"profiles": {"code": {"fields": {"path": -1}}}}`, "profiles.code.fields.path"},
+		{`{"profile": "media"}`, "profile"},
+	}
+
+	for _, test := range tests {
+		_, err := indexing.LoadConfig(writeConfig(t, test.config))
+		if err == nil {
+			t.Errorf("Expected an error for %s", test.config)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), test.key) {
+			t.Errorf("Expected error %q to name %s", err, test.key)
+		}
+	}
+}
+
+func TestLoadConfigEnvOverride(t *testing.T) {
+	t.Setenv(indexing.EnvName("maxResults"), "5")
+	t.Setenv(indexing.EnvName("hash.enabled"), "false")
+
+	opts, err := indexing.LoadConfig(writeConfig(t, `{"maxResults": 50}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.MaxResults != 5 {
+		t.Errorf("Expected max results 5 from the environment, but got %d", opts.MaxResults)
+	}
+
+	if opts.Hash.Enabled {
+		t.Error("Expected hashing to be disabled from the environment")
+	}
+}
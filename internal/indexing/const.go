@@ -1,11 +1,28 @@
 package indexing
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrFileNotFound = errors.New("file not found")
 
 	ErrNotAllowedToRead = errors.New("not allowed to read file/folder")
+
+	ErrAlreadyStarted = errors.New("index already started")
+
+	ErrCorruptIndex = errors.New("index file is corrupt")
+
+	ErrContentDisabled = errors.New("content indexing is not enabled")
+
+	ErrNotHashable = errors.New("directories and entries of archives are not hashed")
+
+	ErrFileChanged = errors.New("file changed since it was indexed")
+
+	ErrReadOnly = errors.New("index is read only")
+
+	ErrInvalidCursor = errors.New("cursor is not of this search")
 )
 
 const (
@@ -15,24 +32,72 @@ const (
 const (
 	MaxGoRoutines = 5
 
+	// How long after Start the removed file checker and root watcher first run
+	startDelay = 30 * time.Second
+
 	MaxResults = 30
 
 	WIN_PossibleDriveLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
 
-// Default blacklist
-var blacklist = []string{
-	`C:\\Windows.*`,
-	`C:\\Program Files.*`,         // TODO: Maybe
-	`C:\\Program Files \(x86\).*`, // TODO: Maybe
-	`C:\\Recovery.*`,
-	`C:\\System Volume Information.*`,
-	`.*pagefile\.sys`,
-	`.*hiberfil\.sys`,
-	`.*swapfile\.sys`,
-	// `C:\\Users\\.*\\AppData.*`, // TODO: Maybe
-	`C:\\Windows\\Temp.*`,
-	`C:\\Users\\.*\\AppData\\Local\\Temp.*`,
-	`.*\\Temp.*`,
-	`.*\\temp.*`,
+// Types of the mounts that are never indexed on Linux unless configured otherwise
+var DefaultIgnoredFilesystems = []string{
+	"proc",
+	"sysfs",
+	"cgroup",
+	"cgroup2",
+	"tmpfs",
+	"devtmpfs",
+	"devpts",
+	"mqueue",
+	"hugetlbfs",
+	"securityfs",
+	"debugfs",
+	"tracefs",
+	"pstore",
+	"bpf",
+	"configfs",
+	"fusectl",
+	"binfmt_misc",
+	"autofs",
+	"rpc_pipefs",
+	"nsfs",
+	"efivarfs",
+	"selinuxfs",
+	"overlay", // Container layers, the root filesystem is indexed whatever its type
+	"squashfs",
+}
+
+// Paths that are never indexed, in the .gitignore format. Configured excludes and ignore files
+// come after these and can include them again with "!".
+var defaultExcludes = []string{
+	"C:/Windows/",
+	"C:/Program Files/",       // TODO: Maybe
+	"C:/Program Files (x86)/", // TODO: Maybe
+	"C:/Recovery/",
+	"C:/System Volume Information/",
+	"pagefile.sys",
+	"hiberfil.sys",
+	"swapfile.sys",
+	// "C:/Users/*/AppData/", // TODO: Maybe
+	"C:/Users/*/AppData/Local/Temp/",
+}
+
+// Files in the .gitignore format whose rules apply to the directory they are in and below
+var DefaultIgnoreFiles = []string{".gitignore", ".ignore"}
+
+// Algorithms files are hashed with unless configured otherwise, the others can be asked for
+// with FileHash
+var DefaultHashAlgorithms = []string{"md5", "sha1", "sha256"}
+
+// Extensions of the files that are content indexed unless configured otherwise
+var DefaultContentExtensions = []string{
+	// Text and documentation
+	".txt", ".md", ".markdown", ".rst", ".adoc", ".org", ".tex", ".csv", ".tsv", ".log",
+	// Config
+	".json", ".yaml", ".yml", ".toml", ".ini", ".cfg", ".conf", ".env", ".properties", ".xml",
+	// Source
+	".go", ".c", ".h", ".cc", ".cpp", ".hpp", ".cs", ".java", ".kt", ".swift", ".rs", ".py",
+	".rb", ".php", ".js", ".jsx", ".ts", ".tsx", ".vue", ".html", ".css", ".scss", ".sql",
+	".sh", ".bash", ".zsh", ".ps1", ".bat", ".lua", ".pl", ".r", ".dart", ".scala",
 }
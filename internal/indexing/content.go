@@ -0,0 +1,191 @@
+package indexing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/TechMDW/indexing/internal/content"
+	"github.com/TechMDW/indexing/internal/extract"
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+// Lines of a file returned with a result that matched content: filters
+const maxSnippets = 3
+
+// indexContent adds the words of a file to the content index, when it is enabled and the
+// file is text or has an extractor
+func (i *Index) indexContent(file File) {
+	if i.content == nil || file.IsDir {
+		return
+	}
+
+	text, err := i.readContent(file.FullPath)
+	if err != nil {
+		// It may have been indexed while it was still text or small enough
+		i.content.Remove(file.FullPath)
+
+		if !errors.Is(err, content.ErrNotText) && !errors.Is(err, content.ErrTooBig) && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+		}
+		return
+	}
+
+	i.content.Add(file.FullPath, content.Tokens(text))
+}
+
+// readContent returns the text of a file. Files with one of the configured extensions are
+// read as they are, other formats go through the extractor for them.
+func (i *Index) readContent(path string) (string, error) {
+	maxSize := int64(i.opts.Content.MaxSize)
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if _, ok := i.contentExts[ext]; ok {
+		return content.ReadFile(path, maxSize)
+	}
+
+	// Without an extension it can be text like a README, or the extractor is picked by
+	// what the file looks like
+	if ext == "" {
+		text, err := content.ReadFile(path, maxSize)
+		if !errors.Is(err, content.ErrNotText) {
+			return text, err
+		}
+	} else if i.extractors.ForExtension(ext) == nil {
+		return "", content.ErrNotText
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", content.ErrNotText
+	}
+	if maxSize > 0 && info.Size() > maxSize {
+		return "", content.ErrTooBig
+	}
+
+	doc, err := i.extractors.Extract(path)
+	if errors.Is(err, extract.ErrNoExtractor) {
+		return "", content.ErrNotText
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The title, author and such can be searched for too
+	keys := make([]string, 0, len(doc.Metadata))
+	for key := range doc.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	text := doc.Text
+	for _, key := range keys {
+		text += "\n" + doc.Metadata[key]
+	}
+	return text, nil
+}
+
+// indexMatches are the keys of the files that each filter of a query matches, for filters
+// like content: that are looked up in an index of their own before the search
+type indexMatches map[*query.Field]map[string]struct{}
+
+// fieldsNamed returns the filters of a query on a field
+func fieldsNamed(node query.Node, name string) []*query.Field {
+	switch n := node.(type) {
+	case *query.And:
+		var fields []*query.Field
+		for _, child := range n.Nodes {
+			fields = append(fields, fieldsNamed(child, name)...)
+		}
+		return fields
+	case *query.Or:
+		var fields []*query.Field
+		for _, child := range n.Nodes {
+			fields = append(fields, fieldsNamed(child, name)...)
+		}
+		return fields
+	case *query.Not:
+		return fieldsNamed(n.Node, name)
+	case *query.Field:
+		if n.Name == name {
+			return []*query.Field{n}
+		}
+	}
+	return nil
+}
+
+// matchContent looks up the files that the content: filters of a query match. The index
+// has every file with all the words, a phrase of more than one word is then checked
+// against the file itself.
+func (i *Index) matchContent(ctx context.Context, node query.Node, matches indexMatches) error {
+	fields := fieldsNamed(node, "content")
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if i.content == nil {
+		return ErrContentDisabled
+	}
+
+	for _, f := range fields {
+		set := make(map[string]struct{})
+		matches[f] = set
+
+		phrase := content.Split(f.Value)
+		if len(phrase) == 0 {
+			continue
+		}
+
+		for _, key := range i.content.Lookup(content.Tokens(f.Value)) {
+			if len(phrase) > 1 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				text, err := i.readContent(key)
+				if err != nil || !content.ContainsPhrase(content.Split(text), phrase) {
+					continue
+				}
+			}
+
+			set[key] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// addSnippets adds the lines where the content: filters of a query matched to the results
+func (i *Index) addSnippets(results []File, node query.Node) {
+	var phrases [][]string
+	for _, f := range fieldsNamed(node, "content") {
+		if phrase := content.Split(f.Value); len(phrase) > 0 {
+			phrases = append(phrases, phrase)
+		}
+	}
+
+	if len(phrases) == 0 {
+		return
+	}
+
+	for n := range results {
+		if results[n].IsDir {
+			continue
+		}
+
+		text, err := i.readContent(results[n].FullPath)
+		if err != nil {
+			continue
+		}
+
+		results[n].Internal_metadata.Snippets = content.Snippets(text, phrases, maxSnippets)
+	}
+}
@@ -0,0 +1,178 @@
+package indexing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/TechMDW/indexing/internal/archive"
+	"github.com/TechMDW/indexing/internal/hash"
+)
+
+// DuplicateOptions picks the files Duplicates looks at
+type DuplicateOptions struct {
+	Roots      []string       // Only files below one of these paths, every file when empty
+	Extensions []string       // Only files with one of these extensions, with or without the dot
+	MinSize    int64          // Only files of at least this many bytes, empty files are never duplicates
+	Algorithm  hash.Algorithm // Digest the files are compared by, SHA256 when empty
+}
+
+// DuplicateGroup is files with the same content
+type DuplicateGroup struct {
+	Algorithm   hash.Algorithm `json:"algorithm"`
+	Hash        string         `json:"hash"`
+	Size        int64          `json:"size"`        // Of each file
+	Paths       []string       `json:"paths"`       // Sorted, with every name of files that are hard linked
+	Reclaimable int64          `json:"reclaimable"` // Bytes freed by keeping only one of the files
+}
+
+// inode is a file on disk that can have more than one name
+type inode struct {
+	device, inode uint64
+}
+
+// Duplicates finds the files with the same content. Files are grouped by size first, only
+// those with the same size as another file are compared by their digest, and files without
+// the digest are hashed when they get compared. Groups that free the most space come first.
+//
+// Hard links are the same file under more names, they are compared and counted as one file.
+func (i *Index) Duplicates(ctx context.Context, opts DuplicateOptions) ([]DuplicateGroup, error) {
+	alg := opts.Algorithm
+	if alg == "" {
+		alg = hash.SHA256
+	}
+	if _, err := hash.ParseAlgorithm(string(alg)); err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for _, root := range opts.Roots {
+		roots = append(roots, strings.TrimSuffix(filepath.ToSlash(root), "/"))
+	}
+
+	exts := make(map[string]struct{}, len(opts.Extensions))
+	for _, ext := range opts.Extensions {
+		exts[strings.ToLower("."+strings.TrimPrefix(ext, "."))] = struct{}{}
+	}
+
+	minSize := opts.MinSize
+	if minSize < 1 {
+		minSize = 1
+	}
+
+	bySize := make(map[int64][]File)
+	i.FilesMap.Range(func(key, value interface{}) bool {
+		file := value.(File)
+		if file.IsDir || file.Size < minSize || file.Error != "" || file.IsOneDrivePlaceholder {
+			return true
+		}
+		if _, _, ok := archive.Split(file.FullPath); ok {
+			return true
+		}
+		if len(exts) > 0 {
+			if _, ok := exts[strings.ToLower(file.Extension)]; !ok {
+				return true
+			}
+		}
+		if len(roots) > 0 && !underAny(file.FullPath, roots) {
+			return true
+		}
+
+		bySize[file.Size] = append(bySize[file.Size], file)
+		return true
+	})
+
+	var groups []DuplicateGroup
+	for size, files := range bySize {
+		// Only the first name of a file that is hard linked is compared
+		names := make(map[inode][]string)
+		distinct := files[:0]
+		for _, file := range files {
+			if file.Links > 0 {
+				key := inode{file.Device, file.Inode}
+				if _, ok := names[key]; ok {
+					names[key] = append(names[key], file.FullPath)
+					continue
+				}
+				names[key] = []string{file.FullPath}
+			}
+			distinct = append(distinct, file)
+		}
+
+		if len(distinct) < 2 {
+			continue
+		}
+
+		byHash := make(map[string][]File)
+		for _, file := range distinct {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			digest := file.Hash.Get(alg)
+			if digest == "" {
+				hashed, err := i.hashFile(ctx, file.FullPath, []hash.Algorithm{alg})
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					// Files that changed or are gone since they were indexed are left out
+					if !errors.Is(err, ErrFileChanged) && !errors.Is(err, ErrFileNotFound) && !errors.Is(err, os.ErrNotExist) {
+						log.Println(err)
+					}
+					continue
+				}
+				digest = hashed.Hash.Get(alg)
+			}
+
+			byHash[digest] = append(byHash[digest], file)
+		}
+
+		for digest, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+
+			var paths []string
+			for _, file := range same {
+				if file.Links > 0 {
+					paths = append(paths, names[inode{file.Device, file.Inode}]...)
+				} else {
+					paths = append(paths, file.FullPath)
+				}
+			}
+
+			sort.Strings(paths)
+			groups = append(groups, DuplicateGroup{
+				Algorithm:   alg,
+				Hash:        digest,
+				Size:        size,
+				Paths:       paths,
+				Reclaimable: size * int64(len(same)-1),
+			})
+		}
+	}
+
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].Reclaimable != groups[b].Reclaimable {
+			return groups[a].Reclaimable > groups[b].Reclaimable
+		}
+		return groups[a].Hash < groups[b].Hash
+	})
+
+	return groups, nil
+}
+
+// underAny reports whether path is one of the roots or below one of them
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package indexing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+// Explanation is how a search scores one file, to find out why a file ranks where it does
+type Explanation struct {
+	Path    string      `json:"path"`
+	Query   string      `json:"query"`
+	Matched bool        `json:"matched"`
+	Score   int         `json:"score"` // As ranked, 0 when the file didn't match
+	Parts   []Part      `json:"parts"` // Of the query, in the order they are written
+	Profile string      `json:"profile,omitempty"`
+	Boosts  []PathBoost `json:"boosts,omitempty"` // Of the profile, added to the score
+}
+
+// Part is one word, filter or pattern of a search and what it did for the file
+type Part struct {
+	Text    string `json:"text"`              // As in the query, like report or ext:pdf
+	Negated bool   `json:"negated,omitempty"` // Inside a NOT, the file matches when the part doesn't
+	Matched bool   `json:"matched"`
+	Score   int    `json:"score"` // The sum of the points of the rules
+	Rules   []Rule `json:"rules,omitempty"`
+}
+
+// Rule is one of the scoring rules that fired for a word or pattern. The rules are:
+//
+//	contains   the word is in the field, without regard to case
+//	exact      the word is in the field with the same case
+//	start      the field starts with the word
+//	word       the word is a whole word of the field
+//	length     how much of the name the word covers, or for a path how many of its directories match
+//	extension  the word is the extension
+//	hash       the word is part of a digest
+//	fuzzy      the letters of the word are in the name in order, or it is a typo away
+//	pattern    how much of the name or path a glob or regex covers
+type Rule struct {
+	Name   string  `json:"name"`
+	Field  string  `json:"field"`  // What the rule looked at, like name, path or hash
+	Points int     `json:"points"` // After the weight
+	Weight float64 `json:"weight"` // Of the rule and field in the scoring profile, 1 without one
+}
+
+// Explain searches for q like SearchWith but only scores the file at path, and explains what
+// every part of the query did for it. It returns ErrFileNotFound when the file isn't indexed.
+func (i *Index) Explain(ctx context.Context, q, path string, opts SearchOptions) (*Explanation, error) {
+	file, err := i.GetIndex(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := i.prepareSearch(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{Path: file.FullPath, Query: q}
+	if s == nil {
+		return e, nil
+	}
+	if s.profile != nil {
+		e.Profile = s.profile.name
+	}
+
+	matched, score, data := s.match(file)
+	if matched {
+		e.Matched, e.Score = true, score
+		e.Boosts = s.profile.boosts(file.FullPath)
+	}
+
+	if s.node == nil {
+		part := Part{Text: q, Matched: matched}
+		for _, d := range data {
+			part.Rules = append(part.Rules, scoreRules(d)...)
+		}
+		s.profile.weigh(part.Rules)
+		part.Score = sumRules(part.Rules)
+		e.Parts = []Part{part}
+		return e, nil
+	}
+
+	e.Parts = explainNode(s.node, file, s.matches, s.profile, false)
+	return e, nil
+}
+
+// explainNode explains the words and filters below a node of a query
+func explainNode(node query.Node, file File, im indexMatches, p *profile, negated bool) []Part {
+	switch n := node.(type) {
+	case *query.And:
+		var parts []Part
+		for _, child := range n.Nodes {
+			parts = append(parts, explainNode(child, file, im, p, negated)...)
+		}
+		return parts
+	case *query.Or:
+		var parts []Part
+		for _, child := range n.Nodes {
+			parts = append(parts, explainNode(child, file, im, p, negated)...)
+		}
+		return parts
+	case *query.Not:
+		return explainNode(n.Node, file, im, p, !negated)
+	case *query.Term:
+		part := Part{Text: n.String(), Negated: negated}
+		if file.IsDir {
+			_, s := ScoreDir(file, n.Text)
+			part.Rules = scoreRules(s)
+		} else {
+			_, s := ScoreFile(file, n.Text)
+			part.Rules = scoreRules(s)
+		}
+		// Whether the word matched doesn't depend on the weights
+		part.Matched = sumRules(part.Rules) > 0
+		p.weigh(part.Rules)
+		part.Score = sumRules(part.Rules)
+		return []Part{part}
+	case *query.Field:
+		return []Part{{Text: n.String(), Negated: negated, Matched: matchField(n, file, im)}}
+	}
+	return nil
+}
+
+// scoreRules returns the rules that gave points in the score data of a word or pattern
+func scoreRules(data interface{}) []Rule {
+	var rules []Rule
+	add := func(name, field string, points int) {
+		if points != 0 {
+			rules = append(rules, Rule{Name: name, Field: field, Points: points})
+		}
+	}
+
+	switch s := data.(type) {
+	case FileScore:
+		add("contains", "name", s.Contains)
+		add("exact", "name", s.Exact)
+		add("start", "name", s.Start)
+		add("word", "name", s.Word)
+		add("length", "name", s.Length)
+		add("extension", "name", s.Extension)
+		add("hash", "hash", s.Hash)
+		add("fuzzy", "name", s.Fuzzy)
+	case DirScore:
+		for _, f := range []struct {
+			name  string
+			score FieldScore
+		}{{"path", s.Path}, {"name", s.Name}, {"permissions", s.Permissions}} {
+			add("contains", f.name, f.score.Contains)
+			add("exact", f.name, f.score.Exact)
+			add("start", f.name, f.score.Start)
+			add("word", f.name, f.score.Word)
+			add("length", f.name, f.score.Length)
+		}
+		add("fuzzy", "name", s.Fuzzy)
+	case PatternScore:
+		field := "name"
+		if s.Path {
+			field = "path"
+		}
+		// A pattern that matched gets a point on top of how much it covers
+		add("pattern", field, 1)
+		add("length", field, s.Length)
+	}
+
+	return rules
+}
+
+// sumRules adds up the points of the rules
+func sumRules(rules []Rule) int {
+	total := 0
+	for _, r := range rules {
+		total += r.Points
+	}
+	return total
+}
+
+// String formats the explanation to be read, one line for each part and rule
+func (e *Explanation) String() string {
+	var b strings.Builder
+
+	if e.Matched {
+		fmt.Fprintf(&b, "%s matches %q with a score of %d\n", e.Path, e.Query, e.Score)
+	} else {
+		fmt.Fprintf(&b, "%s doesn't match %q\n", e.Path, e.Query)
+	}
+
+	for _, p := range e.Parts {
+		state := "no match"
+		if p.Matched {
+			state = "match"
+		}
+		if p.Negated {
+			state += ", negated"
+		}
+		if len(p.Rules) > 0 {
+			fmt.Fprintf(&b, "  %-24s %3d  (%s)\n", p.Text, p.Score, state)
+		} else {
+			fmt.Fprintf(&b, "  %-24s      (%s)\n", p.Text, state)
+		}
+
+		for _, r := range p.Rules {
+			if r.Weight != 1 {
+				fmt.Fprintf(&b, "    %-22s %+3d  (weight %g)\n", r.Field+" "+r.Name, r.Points, r.Weight)
+			} else {
+				fmt.Fprintf(&b, "    %-22s %+3d\n", r.Field+" "+r.Name, r.Points)
+			}
+		}
+	}
+
+	for _, boost := range e.Boosts {
+		fmt.Fprintf(&b, "  %-24s %+3d  (path boost of %s)\n", boost.Path, boost.Points, e.Profile)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,14 @@
+package indexing
+
+import "github.com/TechMDW/indexing/internal/query"
+
+// CandidateQuery returns the trigram query that a search for q narrows the files down with
+func CandidateQuery(q string) (string, error) {
+	node, err := query.Parse(q)
+	if err != nil {
+		return "", err
+	}
+	return candidateQuery(node, nil).String(), nil
+}
+
+var MountRoots = mountRoots
@@ -0,0 +1,251 @@
+package indexing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/archive"
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+// How long hashing waits before checking again whether files are still being indexed
+const hashBackoff = 100 * time.Millisecond
+
+// hashQueue is the files waiting to be hashed in the order they were found, each once
+type hashQueue struct {
+	lock   sync.Mutex
+	paths  []string
+	queued map[string]struct{}
+	wake   chan struct{} // Signaled when a path is pushed
+}
+
+func newHashQueue() *hashQueue {
+	return &hashQueue{
+		queued: make(map[string]struct{}),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (q *hashQueue) push(path string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.queued[path]; ok {
+		return
+	}
+	q.queued[path] = struct{}{}
+	q.paths = append(q.paths, path)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *hashQueue) pop() (string, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.paths) == 0 {
+		return "", false
+	}
+
+	path := q.paths[0]
+	q.paths[0] = ""
+	q.paths = q.paths[1:]
+	delete(q.queued, path)
+
+	return path, true
+}
+
+func (q *hashQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.paths)
+}
+
+// PendingHashes returns the number of files waiting to be hashed
+func (i *Index) PendingHashes() int {
+	return i.hashQueue.len()
+}
+
+// indexMetadata indexes the metadata of a directory entry, the content is hashed later by the
+// hash queue
+func indexMetadata(path string, file fs.DirEntry) (*File, error) {
+	return indexFile(path, file, false, 0)
+}
+
+// queueHash queues a file to be hashed in the background, when the hash policy and its root
+// want it hashed and it isn't yet
+func (i *Index) queueHash(file File, root Root) {
+	if root.hashEnabled(i.opts.Hash) && i.needsHash(file) {
+		i.hashQueue.push(file.FullPath)
+	}
+}
+
+// needsHash reports whether a file is missing any of the digests of the hash policy
+func (i *Index) needsHash(file File) bool {
+	if file.IsDir || file.Error != "" || file.IsOneDrivePlaceholder || len(i.hashAlgs) == 0 {
+		return false
+	}
+	if _, _, ok := archive.Split(file.FullPath); ok {
+		return false
+	}
+	if maxSize := int64(i.opts.Hash.MaxSize); maxSize > 0 && file.Size > maxSize {
+		return false
+	}
+	return !file.Hash.Has(i.hashAlgs...)
+}
+
+// hashFiles hashes the queued files one at a time until ctx is done. Indexing goes first,
+// hashing waits while files are being indexed.
+func (i *Index) hashFiles(ctx context.Context) {
+	for {
+		path, ok := i.hashQueue.pop()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-i.hashQueue.wake:
+			}
+			continue
+		}
+
+		for len(i.lim) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(hashBackoff):
+			}
+		}
+
+		// The file may be gone or changed by now, then it is queued again if it's still there
+		_, err := i.hashFile(ctx, path, i.hashAlgs)
+		if err != nil && !errors.Is(err, ErrFileNotFound) && !errors.Is(err, ErrFileChanged) && !errors.Is(err, os.ErrNotExist) && ctx.Err() == nil {
+			log.Println(err)
+		}
+	}
+}
+
+// LookupHash returns the sorted paths of the indexed files with a digest of the algorithm
+// that is digest or starts with it, which takes at least hash.MinPrefix characters. An empty
+// algorithm matches the digests of every algorithm.
+func (i *Index) LookupHash(alg hash.Algorithm, digest string) ([]string, error) {
+	return i.hashes.Lookup(alg, digest)
+}
+
+// matchHashes looks up the files that the hash: filters of a query match, like
+// hash:9f86d081 or hash:sha256:9f86d081
+func (i *Index) matchHashes(node query.Node, matches indexMatches) error {
+	for _, f := range fieldsNamed(node, "hash") {
+		alg, digest, err := hash.ParseQuery(f.Value)
+		if err != nil {
+			return fmt.Errorf("hash:%s: %w", f.Value, err)
+		}
+
+		keys, err := i.hashes.Lookup(alg, digest)
+		if err != nil {
+			return err
+		}
+
+		set := make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			set[key] = struct{}{}
+		}
+		matches[f] = set
+	}
+
+	return nil
+}
+
+// FileHash returns the digest of an algorithm for an indexed file, hashing it now when it
+// wasn't yet. The digest is stored with the file. The size limit of the hash policy doesn't
+// apply, the file is hashed whatever its size.
+func (i *Index) FileHash(ctx context.Context, path string, alg hash.Algorithm) (string, error) {
+	if _, err := hash.ParseAlgorithm(string(alg)); err != nil {
+		return "", err
+	}
+
+	file, err := i.hashFile(ctx, path, []hash.Algorithm{alg})
+	if err != nil {
+		return "", err
+	}
+	return file.Hash.Get(alg), nil
+}
+
+// hashFile computes the digests of the algorithms an indexed file doesn't have yet and
+// stores them with it
+func (i *Index) hashFile(ctx context.Context, path string, algs []hash.Algorithm) (File, error) {
+	file, err := i.GetIndex(path)
+	if err != nil {
+		return file, err
+	}
+	if file.IsDir {
+		return file, ErrNotHashable
+	}
+	if _, _, ok := archive.Split(path); ok {
+		return file, ErrNotHashable
+	}
+
+	var missing []hash.Algorithm
+	for _, a := range algs {
+		if file.Hash.Get(a) == "" {
+			missing = append(missing, a)
+		}
+	}
+	if len(missing) == 0 {
+		return file, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return file, err
+	}
+	if info.Size() != file.Size || !info.ModTime().Equal(file.ModTime) {
+		return file, ErrFileChanged
+	}
+
+	h, err := hash.HashReader(&ctxReader{ctx: ctx, r: f}, missing...)
+	if err != nil {
+		return file, err
+	}
+
+	// The crawler may have stored the file again while it was hashed, the digests are merged
+	// into what is indexed now unless that is another version of the file
+	return i.updateIndex(path, func(curr File) (File, error) {
+		if curr.Size != file.Size || !curr.ModTime.Equal(file.ModTime) {
+			return curr, ErrFileChanged
+		}
+
+		curr.Hash.Merge(h)
+		return curr, nil
+	})
+}
+
+// ctxReader stops reading once ctx is done, so hashing a big file can be cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -3,38 +3,42 @@ package indexing
 import (
 	"container/heap"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/TechMDW/indexing/internal/archive"
 	"github.com/TechMDW/indexing/internal/attributes"
-	"github.com/TechMDW/indexing/internal/graceful"
+	"github.com/TechMDW/indexing/internal/content"
+	"github.com/TechMDW/indexing/internal/extract"
 	"github.com/TechMDW/indexing/internal/hash"
-
-	"github.com/pierrec/lz4/v4"
+	"github.com/TechMDW/indexing/internal/ignore"
+	"github.com/TechMDW/indexing/internal/query"
+	"github.com/TechMDW/indexing/internal/trigram"
+	"github.com/TechMDW/indexing/internal/watcher"
 )
 
-// singelton main struct for the indexing package
+// shared index returned by GetIndexInstance
 var (
-	once   sync.Once
-	idx    *Index
-	ErrIdx error
+	instanceLock sync.Mutex
+	instance     *Index
 )
 
-var lim = make(chan struct{}, MaxGoRoutines)
-
+// IndexFile indexes a single directory entry and hashes its content
 func IndexFile(path string, file fs.DirEntry) (*File, error) {
+	return indexFile(path, file, true, hash.DefaultMaxSize)
+}
+
+func indexFile(path string, file fs.DirEntry, withHash bool, maxHashSize int64) (*File, error) {
 	windowsAttr, err := attributes.GetFileAttributes(path)
 
 	if windowsAttr.OneDrive || err != nil {
@@ -43,7 +47,7 @@ func IndexFile(path string, file fs.DirEntry) (*File, error) {
 			Extension:             filepath.Ext(file.Name()),
 			Path:                  path,
 			PathInfo:              *pathInfo(path),
-			FullPath:              fmt.Sprintf("%s/%s", path, file.Name()),
+			FullPath:              joinPath(path, file.Name()),
 			IsHidden:              file.Name()[0] == '.',
 			IsDir:                 file.IsDir(),
 			WindowsAttributes:     windowsAttr,
@@ -61,15 +65,15 @@ func IndexFile(path string, file fs.DirEntry) (*File, error) {
 
 	var hashes hash.Hash
 	var Error error = nil
-	if !file.IsDir() {
-		f, err := os.Open(fmt.Sprintf("%s/%s", path, file.Name()))
+	if withHash && !file.IsDir() {
+		f, err := os.Open(joinPath(path, file.Name()))
 		if err != nil {
 			Error = err
 		}
 		defer f.Close()
 
 		if Error == nil {
-			hashes, err = hash.HashFile(f)
+			hashes, err = hash.HashFileMaxSize(f, maxHashSize)
 			if err != nil {
 				Error = err
 			}
@@ -80,8 +84,8 @@ func IndexFile(path string, file fs.DirEntry) (*File, error) {
 		Name:              file.Name(),
 		Extension:         filepath.Ext(file.Name()),
 		Path:              path,
-		FullPath:          fmt.Sprintf("%s/%s", path, file.Name()),
-		PathInfo:          *pathInfo(fmt.Sprintf("%s/%s", path, file.Name())),
+		FullPath:          joinPath(path, file.Name()),
+		PathInfo:          *pathInfo(joinPath(path, file.Name())),
 		Size:              info.Size(),
 		IsHidden:          file.Name()[0] == '.',
 		IsDir:             file.IsDir(),
@@ -92,6 +96,7 @@ func IndexFile(path string, file fs.DirEntry) (*File, error) {
 		},
 		Hash: hashes,
 	}
+	statMetadata(fileInfo.FullPath, info, &fileInfo)
 
 	if Error != nil {
 		fileInfo.Error = Error.Error()
@@ -148,7 +153,7 @@ func IndexFileWithoutPermissions(path string, info fs.FileInfo) File {
 	if info.IsDir() {
 		fullPath = path
 	} else {
-		fullPath = fmt.Sprintf("%s/%s", path, info.Name())
+		fullPath = joinPath(path, info.Name())
 	}
 
 	attr, _ := attributes.GetFileAttributes(path)
@@ -166,6 +171,7 @@ func IndexFileWithoutPermissions(path string, info fs.FileInfo) File {
 		WindowsAttributes: attr,
 		Error:             ErrNotAllowedToRead.Error(),
 	}
+	statMetadata(fullPath, info, &file)
 
 	return file
 }
@@ -193,11 +199,11 @@ func IndexDirectory(path string, index *Index) error {
 	var wg = sync.WaitGroup{}
 
 	for _, file := range files {
-		lim <- struct{}{}
+		index.lim <- struct{}{}
 		wg.Add(1)
 		go func(file fs.DirEntry) {
 			defer wg.Done()
-			defer func() { <-lim }()
+			defer func() { <-index.lim }()
 
 			indexedFile, err := IndexFile(path, file)
 			if err != nil {
@@ -208,7 +214,7 @@ func IndexDirectory(path string, index *Index) error {
 			index.StoreIndex(indexedFile.FullPath, *indexedFile)
 
 			if file.IsDir() {
-				go IndexDirectory(fmt.Sprintf("%s/%s", path, file.Name()), index)
+				go IndexDirectory(joinPath(path, file.Name()), index)
 			}
 		}(file)
 	}
@@ -218,165 +224,744 @@ func IndexDirectory(path string, index *Index) error {
 	return nil
 }
 
+// New creates an independent index for the given options, call Start to load it from disk
+// and begin crawling.
+//
+// Options usually come from DefaultOptions or LoadConfig, zero intervals and limits fall
+// back to the defaults.
+func New(opts Options) (*Index, error) {
+	opts = opts.withDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.StoragePath == "" {
+		path, err := getIndexFilePath()
+		if err != nil {
+			return nil, err
+		}
+		opts.StoragePath = path
+	}
+
+	excludes, err := compileExcludes(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	ignoreFiles := make(map[string]struct{}, len(opts.IgnoreFiles))
+	for _, name := range opts.IgnoreFiles {
+		ignoreFiles[name] = struct{}{}
+	}
+
+	i := &Index{
+		FilesMap:        sync.Map{},
+		Roots:           &[]Root{},
+		FindNewFilesMap: sync.Map{},
+		discoverRoots:   len(opts.Roots) == 0,
+		opts:            opts,
+		excludes:        ignore.NewMatcher(excludes),
+		ignoreFiles:     ignoreFiles,
+		lim:             make(chan struct{}, opts.Workers),
+		rescan:          make(chan struct{}, 1),
+		trigrams:        trigram.New(),
+		hashQueue:       newHashQueue(),
+		hashes:          hash.NewIndex(),
+		archiveEntries:  make(map[string]map[string]struct{}),
+	}
+
+	if i.profiles, err = compileProfiles(opts.Profiles); err != nil {
+		return nil, err
+	}
+
+	for _, name := range opts.Hash.Algorithms {
+		alg, err := hash.ParseAlgorithm(name)
+		if err != nil {
+			return nil, err
+		}
+		i.hashAlgs = append(i.hashAlgs, alg)
+	}
+
+	if opts.Content.Enabled {
+		i.content = content.NewIndex()
+		i.extractors = opts.Extractors
+		if i.extractors == nil {
+			i.extractors = extract.Default
+		}
+		i.contentExts = make(map[string]struct{}, len(opts.Content.Extensions))
+		for _, ext := range opts.Content.Extensions {
+			i.contentExts[strings.ToLower("."+strings.TrimPrefix(ext, "."))] = struct{}{}
+		}
+	}
+
+	for _, root := range opts.Roots {
+		root, err := cleanRoot(root)
+		if err != nil {
+			return nil, err
+		}
+
+		i.addRoot(root)
+	}
+
+	// Get windows or linux
+	oss := runtime.GOOS
+
+	log.Println("Operating system:", oss)
+
+	switch oss {
+	case "windows":
+		i.refreshWindowsDrives()
+	case "linux":
+		// Configured roots are crawled without the mount table, only the mounts to skip are missing
+		if err := i.refreshLinuxMounts(); err != nil {
+			if i.discoverRoots {
+				return nil, err
+			}
+			log.Println(err)
+		}
+	default:
+		if i.discoverRoots {
+			return nil, errors.New("unsupported operating system, roots must be configured")
+		}
+	}
+
+	return i, nil
+}
+
+// GetIndexInstance returns a shared, started index configured by the config file and
+// environment.
+//
+// It is kept for compatibility, use New to get an index of your own. A failed
+// initialization is retried on the next call.
 func GetIndexInstance() (*Index, error) {
-	once.Do(func() {
-		idx = &Index{
-			FilesMap:        sync.Map{},
-			WindowsDrives:   &[]string{},
-			FindNewFilesMap: sync.Map{},
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+
+	if instance != nil {
+		return instance, nil
+	}
+
+	opts, err := LoadConfig("")
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := i.Start(context.Background()); err != nil {
+		return nil, err
+	}
+
+	instance = i
+	return instance, nil
+}
+
+// Start loads the index from disk and runs the scanner, file watcher, persister, removed file
+// checker and root watcher in the background until ctx is done or Stop is called
+func (i *Index) Start(ctx context.Context) error {
+	i.lifecycleLock.Lock()
+	defer i.lifecycleLock.Unlock()
+
+	if i.cancel != nil {
+		return ErrAlreadyStarted
+	}
+
+	ctx, i.cancel = context.WithCancel(ctx)
+
+	log.Println("Starting handler")
+
+	loaded := make(chan struct{})
+	i.goTask(func() {
+		defer close(loaded)
+
+		err := i.LoadFileIndex()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
 		}
+	})
 
-		// Load index from file
-		go idx.LoadFileIndex()
+	if i.opts.Watch.Enabled {
+		w, err := watcher.New(time.Duration(i.opts.Watch.PollInterval))
+		if err != nil {
+			log.Println(err)
+		} else {
+			i.watcher = w
+		}
+	}
 
-		// Get windows or linux
-		oss := runtime.GOOS
+	i.goTask(func() {
+		// Don't look for new files before we know what is already indexed
+		select {
+		case <-loaded:
+		case <-ctx.Done():
+			return
+		}
 
-		fmt.Println("Operating system:", oss)
+		i.scanRoots(ctx)
 
-		switch oss {
-		case "windows":
-			// Loop through all possible drives on Windows
-			for _, driveLetter := range WIN_PossibleDriveLetters {
-				drivePath := fmt.Sprintf("%s:/", string(driveLetter))
-				if _, err := os.Stat(drivePath); !os.IsNotExist(err) {
-					log.Printf("Found drive %s\n", drivePath)
+		ticker := time.NewTicker(time.Duration(i.opts.Scan.Interval))
+		defer ticker.Stop()
 
-					idx.WindowsDrivesLock.Lock()
-					*idx.WindowsDrives = append(*idx.WindowsDrives, drivePath)
-					idx.WindowsDrivesLock.Unlock()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// With a watcher the changes come in as events after the first crawl
+				if i.watcher == nil {
+					i.scanRoots(ctx)
 				}
+			case <-i.rescan:
+				i.scanRoots(ctx)
+				i.CheckForRemovedFiles(ctx)
 			}
-		case "linux":
-			ErrIdx = errors.New("unsupported operating system")
-			return
-			// rootPath := "/"
-			// go func() {
-			// 	idx.FindNewFiles(rootPath)
-			// }()
-		case "darwin":
-			ErrIdx = errors.New("unsupported operating system")
-			return
-			// rootPath := "/"
-			// go func() {
-			// 	idx.FindNewFiles(rootPath)
-			// }()
-		default:
-			ErrIdx = errors.New("unsupported operating system")
+		}
+	})
+
+	if i.watcher != nil {
+		i.goTask(func() {
+			i.applyEvents(ctx, i.watcher)
+		})
+	}
+
+	i.goTask(func() {
+		i.hashFiles(ctx)
+	})
+
+	i.goTask(func() {
+		// Nothing is stored before the index on disk is loaded
+		select {
+		case <-loaded:
+		case <-ctx.Done():
 			return
 		}
 
-		log.Println("Starting handler")
-		go idx.handler()
+		every(ctx, time.Duration(i.opts.Persist.CheckInterval), time.Duration(i.opts.Persist.CheckInterval), func() {
+			if err := i.storeIfNeeded(); err != nil {
+				log.Println(err)
+			}
+		})
 	})
 
-	return idx, ErrIdx
+	i.goTask(func() {
+		// Files removed while we were not running are only noticed here when watching
+		checked := false
+		every(ctx, startDelay, time.Duration(i.opts.Scan.RemovedInterval), func() {
+			if i.watcher != nil && checked {
+				return
+			}
+
+			i.CheckForRemovedFiles(ctx)
+			checked = true
+		})
+	})
+
+	i.goTask(func() {
+		every(ctx, startDelay, time.Duration(i.opts.Scan.RootsInterval), func() {
+			if err := i.refreshRoots(); err != nil {
+				log.Println(err)
+			}
+		})
+	})
+
+	return nil
 }
 
-// TODO: Don't like this function...
-func (i *Index) handler() {
-	var storeFunc func()
-	storeFunc = func() {
-		newFilesSinceStore := atomic.LoadInt32(&i.newFilesSinceStore)
-		if newFilesSinceStore >= 50 {
-			i.StoreFileIndex()
-		} else if time.Since(i.getLastStore()) >= 1*time.Minute && newFilesSinceStore != 0 {
-			i.StoreFileIndex()
+// Stop stops the background tasks, waits for them to finish and syncs the journal to disk.
+// It can be called more than once and before Start.
+func (i *Index) Stop() error {
+	i.lifecycleLock.Lock()
+	defer i.lifecycleLock.Unlock()
+
+	if i.cancel == nil {
+		return i.closeJournal()
+	}
+
+	i.cancel()
+	i.tasks.Wait()
+	i.cancel = nil
+
+	if i.watcher != nil {
+		if err := i.watcher.Close(); err != nil {
+			log.Println(err)
 		}
-		time.AfterFunc(10*time.Second, storeFunc)
+		i.watcher = nil
 	}
-	go storeFunc()
 
-	var newFilesFunc func()
-	newFilesFunc = func() {
-		if atomic.LoadInt64(&i.lastFileIndexLoad) == 0 {
-			fmt.Println("Loading index from file still in progress...")
-			time.AfterFunc(15*time.Second, newFilesFunc)
-			return
+	// Without a journal, when the index never loaded, the changes only make it to disk in a snapshot
+	i.journalLock.Lock()
+	journaled := i.journal != nil
+	i.journalLock.Unlock()
+
+	if !journaled && !i.opts.ReadOnly && atomic.LoadInt32(&i.newFilesSinceStore) != 0 {
+		if err := i.StoreFileIndex(); err != nil {
+			return err
 		}
-		oss := runtime.GOOS
-		switch oss {
-		case "windows":
-			sem := make(chan struct{}, 2)
-			for _, drive := range *idx.WindowsDrives {
-				sem <- struct{}{}
-				go func(drive string) {
-					defer func() { <-sem }()
-					idx.FindNewFiles(drive)
-				}(drive)
-			}
+	}
 
-			for i := 0; i < cap(sem); i++ {
-				sem <- struct{}{}
-			}
-		case "linux":
-			idx.FindNewFiles("/")
-		case "darwin":
-			idx.FindNewFiles("/")
-		default:
-			log.Println("Unsupported operating system")
+	return i.closeJournal()
+}
+
+// Close is the same as Stop
+func (i *Index) Close() error {
+	return i.Stop()
+}
+
+// goTask runs f in a goroutine that Stop waits for
+func (i *Index) goTask(f func()) {
+	i.tasks.Add(1)
+	go func() {
+		defer i.tasks.Done()
+		f()
+	}()
+}
+
+// every runs f after delay and then every interval until ctx is done
+func every(ctx context.Context, delay, interval time.Duration, f func()) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-timer.C:
+			f()
+			timer.Reset(interval)
 		}
+	}
+}
 
-		time.AfterFunc(30*time.Second, newFilesFunc)
+// scanRoots crawls the roots for new files, two at a time
+func (i *Index) scanRoots(ctx context.Context) {
+	sem := make(chan struct{}, 2)
+	for _, root := range i.getRoots() {
+		sem <- struct{}{}
+		go func(root Root) {
+			defer func() { <-sem }()
+			i.FindNewFiles(ctx, root)
+		}(root)
 	}
-	go newFilesFunc()
 
-	var removedFilesFunc func()
-	removedFilesFunc = func() {
-		i.CheckForRemovedFiles()
-		time.AfterFunc(5*time.Minute, removedFilesFunc)
+	for i := 0; i < cap(sem); i++ {
+		sem <- struct{}{}
 	}
+}
 
-	var checkForNewDrives func()
-	checkForNewDrives = func() {
-		if runtime.GOOS == "windows" {
-			for _, driveLetter := range WIN_PossibleDriveLetters {
-				drivePath := fmt.Sprintf("%s:/", string(driveLetter))
-				if _, err := os.Stat(drivePath); !os.IsNotExist(err) {
-					var found bool
-					for _, drive := range *idx.WindowsDrives {
-						if drive == drivePath {
-							found = true
-							break
-						}
-					}
+// storeIfNeeded syncs the journal once enough files changed or when it has not been synced for
+// a while, and compacts it into a new snapshot once it has grown too big
+func (i *Index) storeIfNeeded() error {
+	newFilesSinceStore := atomic.LoadInt32(&i.newFilesSinceStore)
+	if newFilesSinceStore >= int32(i.opts.Persist.MinChanges) ||
+		time.Since(i.getLastStore()) >= time.Duration(i.opts.Persist.Interval) && newFilesSinceStore != 0 {
+		if err := i.SyncJournal(); err != nil {
+			return err
+		}
+	}
 
-					if !found {
-						log.Println("Found new drive", drivePath)
-						idx.WindowsDrivesLock.Lock()
-						*idx.WindowsDrives = append(*idx.WindowsDrives, drivePath)
-						idx.WindowsDrivesLock.Unlock()
-						continue
-					}
-				}
+	if i.needsCompaction() {
+		return i.StoreFileIndex()
+	}
+
+	return nil
+}
+
+// refreshRoots looks for new drives or mounts
+func (i *Index) refreshRoots() error {
+	switch runtime.GOOS {
+	case "windows":
+		i.refreshWindowsDrives()
+	case "linux":
+		return i.refreshLinuxMounts()
+	}
+	return nil
+}
+
+// cleanRoot expands a leading ~ and makes the root path absolute
+func cleanRoot(root Root) (Root, error) {
+	if root.MaxDepth < 0 {
+		return root, fmt.Errorf("root %q: max depth can't be negative", root.Path)
+	}
+
+	path := root.Path
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return root, fmt.Errorf("root %q: %w", root.Path, err)
+		}
+		path = filepath.Join(home, path[1:])
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return root, fmt.Errorf("root %q: %w", root.Path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return root, fmt.Errorf("root %q: %w", root.Path, err)
+	}
+
+	if !info.IsDir() {
+		return root, fmt.Errorf("root %q: not a directory", root.Path)
+	}
+
+	root.Path = filepath.ToSlash(path)
+	return root, nil
+}
+
+// addRoot adds a root that gets crawled, it returns false if the path was already known
+func (i *Index) addRoot(root Root) bool {
+	i.RootsLock.Lock()
+	defer i.RootsLock.Unlock()
+
+	for _, r := range *i.Roots {
+		if r.Path == root.Path {
+			return false
+		}
+	}
+
+	*i.Roots = append(*i.Roots, root)
+	return true
+}
+
+// getRoots returns a copy of the roots that get crawled
+func (i *Index) getRoots() []Root {
+	i.RootsLock.RLock()
+	defer i.RootsLock.RUnlock()
+
+	roots := make([]Root, len(*i.Roots))
+	copy(roots, *i.Roots)
+
+	return roots
+}
+
+// refreshWindowsDrives loops through all possible drives on Windows and adds the ones that exist
+func (i *Index) refreshWindowsDrives() {
+	if !i.discoverRoots {
+		return
+	}
+
+	for _, driveLetter := range WIN_PossibleDriveLetters {
+		drivePath := fmt.Sprintf("%s:/", string(driveLetter))
+		if _, err := os.Stat(drivePath); !os.IsNotExist(err) {
+			if i.addRoot(Root{Path: drivePath}) {
+				log.Printf("Found drive %s\n", drivePath)
+				i.requestRescan()
 			}
 		}
-		time.AfterFunc(10*time.Second, checkForNewDrives)
 	}
+}
 
-	delayRemovedFiles := time.NewTimer(30 * time.Second)
-	delayCheckForNewDrives := time.NewTimer(30 * time.Second)
+// refreshLinuxMounts reads the mount table, the ignored mounts are always updated while the
+// other mounts are only added as roots when the roots are discovered
+func (i *Index) refreshLinuxMounts() error {
+	mounts, err := linuxMounts()
+	if err != nil {
+		return err
+	}
 
-	for {
-		select {
-		case <-delayRemovedFiles.C:
-			go removedFilesFunc()
-		case <-delayCheckForNewDrives.C:
-			go checkForNewDrives()
+	roots, skip := mountRoots(mounts, i.opts.Linux)
+
+	i.skipMounts.Range(func(key, value interface{}) bool {
+		if _, ok := skip[key.(string)]; !ok {
+			i.skipMounts.Delete(key)
 		}
+		return true
+	})
+	for path := range skip {
+		i.skipMounts.Store(path, struct{}{})
+	}
+
+	if !i.discoverRoots {
+		return nil
+	}
+
+	for _, root := range roots {
+		if i.addRoot(Root{Path: root}) {
+			log.Printf("Found mount %s\n", root)
+			i.requestRescan()
+		}
+	}
+
+	return nil
+}
+
+// isSkippedMount reports whether the crawler should stay out of a directory because it
+// is an ignored filesystem or lives on another device than the one being crawled
+func (i *Index) isSkippedMount(path string, file fs.DirEntry, dev uint64) bool {
+	if _, ok := i.skipMounts.Load(path); ok {
+		return true
+	}
+
+	// Device 0 means we don't know what device the crawl started on
+	if i.opts.Linux.CrossDevices || dev == 0 {
+		return false
+	}
+
+	info, err := file.Info()
+	if err != nil {
+		return false
 	}
+
+	fileDev, ok := deviceID(info)
+	return ok && fileDev != dev
 }
 
 // Search searches the index based on the query string
 //
-// It will score the indexes based on the query string and return the top 30 results
-func (i *Index) Search(ctx context.Context, q string) []File {
+// The query is parsed with the query package, so it can filter on fields like ext:go or
+// size:>10MB and combine terms with AND, OR and NOT. It will score the indexes based on the
+// terms and return the top results, 30 unless configured. A query that can't be parsed
+// returns a *query.Error.
+//
+// Words also match names fuzzily, with their letters spread out or a typo away, but only when
+// no file has the words as they are. Looking for those means scoring every file, so a search
+// for reprot finds reprot.txt and not report.docx once there is a reprot.txt.
+func (i *Index) Search(ctx context.Context, q string) ([]File, error) {
+	return i.SearchWith(ctx, q, SearchOptions{})
+}
+
+// SearchWith searches the index like Search, but the query can also be a glob or regex that
+// is matched against the name or full path of every file. The pattern is compiled once and
+// the results are ranked the same way. Only the first page of results is returned, see
+// SearchPage for the ones after it.
+func (i *Index) SearchWith(ctx context.Context, q string, opts SearchOptions) ([]File, error) {
+	page, err := i.SearchPage(ctx, q, opts)
+	return page.Files, err
+}
+
+// SearchPage searches the index like SearchWith and returns the page of results that
+// opts.Offset, opts.Limit and opts.Cursor ask for. The cursor of the next page is in the
+// page, it picks up right after the last result even when files were added to the index in
+// between.
+//
+// The trigram index narrows down the files that are scored to those with the text the query
+// needs. Fuzzy matches don't need it, so when a query with words finds nothing that way
+// every file is scored after all.
+func (i *Index) SearchPage(ctx context.Context, q string, opts SearchOptions) (Page, error) {
 	startTime := time.Now()
-	const numWorkers = 100
-	results := make([]File, 0, MaxResults)
 
-	pq := NewPriorityQueue(MaxResults)
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return Page{}, errors.New("offset and limit can't be negative")
+	}
+
+	after, err := decodeCursor(opts.Cursor, q, opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	s, err := i.prepareSearch(ctx, q, opts)
+	if err != nil || s == nil {
+		return Page{Files: []File{}}, err
+	}
+
+	limit := opts.Limit
+	if limit == 0 {
+		limit = i.opts.MaxResults
+	}
+
+	if opts.Offset > math.MaxInt-limit-1 {
+		return Page{}, fmt.Errorf("offset %d and limit %d are out of range", opts.Offset, limit)
+	}
+
+	// One more than the page to know whether there is a next one. There are never more
+	// results than files, so the heap doesn't start out bigger than that.
+	keep := opts.Offset + limit + 1
+	size := keep
+	if n := i.trigrams.Len() + 1; n < size {
+		size = n
+	}
+	h := &rankHeap{files: make([]File, 0, size), ranking: s.ranking}
+	var last File
+	if after != nil {
+		last = after.file()
+	}
+	i.scan(ctx, s, func(file File) {
+		if after != nil && !s.ranking.before(last, file) {
+			return
+		}
+
+		heap.Push(h, file)
+		if h.Len() > keep {
+			heap.Pop(h)
+		}
+	})
+
+	results := make([]File, h.Len())
+	for n := len(results) - 1; n >= 0; n-- {
+		results[n] = heap.Pop(h).(File)
+	}
+
+	var page Page
+	if len(results) == keep {
+		results = results[:keep-1]
+		page.Next = encodeCursor(results[len(results)-1], q, opts)
+	}
+	if opts.Offset < len(results) {
+		page.Files = results[opts.Offset:]
+	} else {
+		page.Files = []File{}
+	}
+
+	if s.node != nil {
+		i.addSnippets(page.Files, s.node)
+	}
+
+	log.Printf("Search took %s", time.Since(startTime))
+	return page, nil
+}
+
+// SearchStream searches the index like SearchWith, but sends the results on the channel as
+// they are found instead of waiting for every file to be scored. They come in no particular
+// order whatever the sort, with their score. opts.Limit stops the search after that many results, 0 sends them
+// all, and opts.Offset and opts.Cursor are ignored. The channel is closed when the search is
+// done or ctx is.
+func (i *Index) SearchStream(ctx context.Context, q string, opts SearchOptions) (<-chan File, error) {
+	if opts.Limit < 0 {
+		return nil, errors.New("limit can't be negative")
+	}
+
+	s, err := i.prepareSearch(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan File)
+	if s == nil {
+		close(out)
+		return out, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		sent := 0
+		i.scan(ctx, s, func(file File) {
+			if opts.Limit > 0 && sent >= opts.Limit {
+				return
+			}
+
+			if s.node != nil {
+				results := []File{file}
+				i.addSnippets(results, s.node)
+				file = results[0]
+			}
+
+			select {
+			case out <- file:
+				sent++
+				if sent == opts.Limit {
+					cancel()
+				}
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	return out, nil
+}
+
+// search is a query that was parsed or a pattern that was compiled, ready to match files
+type search struct {
+	node       query.Node // Nil for patterns
+	match      func(File) (bool, int, []interface{})
+	candidates *trigram.Query
+	fuzzy      bool         // Whether the query can match files the candidates leave out
+	ranking    ranking      // Order of the results
+	matches    indexMatches // Of the content: and hash: filters
+	profile    *profile     // Weighs the scores, nil weighs every rule 1
+}
+
+// prepareSearch parses the query or compiles the pattern of a search, it returns nil when
+// there is nothing to search for
+func (i *Index) prepareSearch(ctx context.Context, q string, opts SearchOptions) (*search, error) {
+	p, err := i.profile(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Mode != ModeQuery {
+		if q == "" {
+			return nil, nil
+		}
+
+		match, err := compilePattern(q, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &search{match: ranked(match, p), ranking: ranking{opts.Sort, opts.Reverse}, profile: p}
+		if opts.Mode == ModeGlob {
+			s.candidates = trigram.GlobQuery(q)
+		} else {
+			s.candidates = trigram.RegexpQuery(q)
+		}
+		return s, nil
+	}
+
+	node, err := query.Parse(q)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	r, ok, err := querySort(node)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		r = ranking{opts.Sort, opts.Reverse}
+	}
+
+	im := make(indexMatches)
+	if err := i.matchContent(ctx, node, im); err != nil {
+		return nil, err
+	}
+	if err := i.matchHashes(node, im); err != nil {
+		return nil, err
+	}
+
+	return &search{
+		node: node,
+		match: ranked(func(file File) (bool, int, []interface{}) {
+			return matchQuery(node, file, im)
+		}, p),
+		candidates: candidateQuery(node, im),
+		fuzzy:      hasTerms(node),
+		ranking:    r,
+		matches:    im,
+		profile:    p,
+	}, nil
+}
+
+// scan scores the candidates of a search, or every file when the candidates found nothing
+// a fuzzy match could have, and calls fn with each file that matched. fn is called from one
+// goroutine at a time.
+func (i *Index) scan(ctx context.Context, s *search, fn func(File)) {
+	keys, narrowed := i.trigrams.Lookup(s.candidates)
+
+	if narrowed {
+		found := false
+		i.score(ctx, keys, s.match, func(file File) {
+			found = true
+			fn(file)
+		})
+		if found || !s.fuzzy || ctx.Err() != nil {
+			return
+		}
+	}
+
+	i.score(ctx, nil, s.match, fn)
+}
+
+// score scores the files with the keys, or every file when keys is nil, and calls fn with
+// each file that matched
+func (i *Index) score(ctx context.Context, keys []string, match func(File) (bool, int, []interface{}), fn func(File)) {
+	const numWorkers = 100
 
 	filesCh := make(chan File, numWorkers)
 	resCh := make(chan File, numWorkers)
@@ -387,18 +972,12 @@ func (i *Index) Search(ctx context.Context, q string) []File {
 		go func() {
 			defer wg.Done()
 			for file := range filesCh {
-				var scoreTotal int
-				var scoreData interface{}
+				matched, scoreTotal, scoreData := match(file)
 
-				if file.IsDir {
-					scoreTotal, scoreData = ScoreDir(file, q)
-				} else {
-					scoreTotal, scoreData = ScoreFile(file, q)
-				}
-
-				if scoreTotal > 0 {
+				if matched {
 					file.Internal_metadata.Score = scoreTotal
 					file.Internal_metadata.Score_data = scoreData
+					file.Internal_metadata.Positions = matchedPositions(scoreData)
 					select {
 					case resCh <- file:
 					case <-ctx.Done():
@@ -410,18 +989,34 @@ func (i *Index) Search(ctx context.Context, q string) []File {
 	}
 
 	go func() {
-		i.FilesMap.Range(func(key, value interface{}) bool {
-			file := value.(File)
+		defer close(filesCh)
 
+		send := func(value interface{}) bool {
 			select {
-			case filesCh <- file:
+			case filesCh <- value.(File):
+				return true
 			case <-ctx.Done():
 				return false
 			}
+		}
 
-			return true
-		})
-		close(filesCh)
+		if keys == nil {
+			i.FilesMap.Range(func(key, value interface{}) bool {
+				return send(value)
+			})
+			return
+		}
+
+		for _, key := range keys {
+			// Removed since the lookup
+			value, ok := i.FilesMap.Load(key)
+			if !ok {
+				continue
+			}
+			if !send(value) {
+				return
+			}
+		}
 	}()
 
 	go func() {
@@ -430,38 +1025,49 @@ func (i *Index) Search(ctx context.Context, q string) []File {
 	}()
 
 	for file := range resCh {
-		item := &Item{
-			Value:    file,
-			Priority: file.Internal_metadata.Score,
-		}
-
-		heap.Push(pq, item)
-		if pq.Len() > MaxResults {
-			heap.Pop(pq)
-		}
+		fn(file)
 	}
+}
+
+// crawl is the state shared by everything crawled below one root
+type crawl struct {
+	ctx  context.Context
+	root Root
+	dev  uint64         // Device the crawl started on
+	wg   sync.WaitGroup // Directories still being crawled
+}
 
-	for pq.Len() > 0 {
-		item := pq.Pop().(*Item)
-		results = append(results, item.Value.(File))
+// FindNewFiles crawls a root and stores the new files in the FilesMap, it returns once the
+// whole root is crawled or ctx is done
+func (i *Index) FindNewFiles(ctx context.Context, root Root) {
+	c := &crawl{
+		ctx:  ctx,
+		root: root,
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Internal_metadata.Score > results[j].Internal_metadata.Score
-	})
+	if info, err := os.Stat(root.Path); err == nil {
+		c.dev, _ = deviceID(info)
+	}
 
-	log.Printf("Search took %s", time.Since(startTime))
-	return results
+	c.wg.Add(1)
+	i.findNewFiles(c, root.Path, 0)
+	c.wg.Wait()
 }
 
-// FindNewFiles finds new files in a directory and stores them in the FilesMap
-func (i *Index) FindNewFiles(path string) {
+// findNewFiles crawls path which is depth directories below the root
+func (i *Index) findNewFiles(c *crawl, path string, depth int) {
+	defer c.wg.Done()
+
+	if c.ctx.Err() != nil {
+		return
+	}
+
 	// TODO: Issues with onedrive
 	if strings.Contains(path, "OneDrive") {
 		return
 	}
 
-	if isBlacklisted(path) {
+	if i.isExcluded(path, true) {
 		return
 	}
 
@@ -510,36 +1116,55 @@ func (i *Index) FindNewFiles(path string) {
 		return
 	}
 
+	i.watchDir(path)
+	i.loadIgnoreFiles(path, files)
+
 	wg := sync.WaitGroup{}
 
 	for _, file := range files {
-		lim <- struct{}{}
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		if i.isExcluded(joinPath(path, file.Name()), file.IsDir()) {
+			continue
+		}
+
+		i.lim <- struct{}{}
 		wg.Add(1)
 		go func(file fs.DirEntry) {
 			defer wg.Done()
-			defer func() { <-lim }()
-
-			filePath := fmt.Sprintf("%s/%s", path, file.Name())
+			defer func() { <-i.lim }()
 
-			// Check if this file is already in the index
-			if i.ExistIndex(filePath) {
-				return
-			}
+			filePath := joinPath(path, file.Name())
 
 			if file.IsDir() {
-				f, err := IndexFile(path, file)
-
-				if err != nil {
+				if i.isSkippedMount(filePath, file, c.dev) {
 					return
 				}
 
-				err = i.StoreIndex(filePath, *f)
+				// Directories already in the index are still crawled for what changed inside them
+				if !i.ExistIndex(filePath) {
+					f, err := indexMetadata(path, file)
+
+					if err != nil {
+						return
+					}
+
+					err = i.StoreIndex(filePath, *f)
 
-				if err != nil {
+					if err != nil {
+						return
+					}
+				}
+
+				// The directory itself is indexed but not what is in it
+				if c.root.MaxDepth > 0 && depth+1 >= c.root.MaxDepth {
 					return
 				}
 
-				go i.FindNewFiles(filePath)
+				c.wg.Add(1)
+				go i.findNewFiles(c, filePath, depth+1)
 				return
 			}
 
@@ -547,7 +1172,7 @@ func (i *Index) FindNewFiles(path string) {
 
 			if err != nil {
 				if errors.Is(err, ErrFileNotFound) {
-					indexedFile, err := IndexFile(path, file)
+					indexedFile, err := indexMetadata(path, file)
 					if err != nil {
 						log.Println(err)
 						return
@@ -559,6 +1184,9 @@ func (i *Index) FindNewFiles(path string) {
 						return
 					}
 
+					i.queueHash(*indexedFile, c.root)
+					i.indexContent(*indexedFile)
+					i.indexArchive(*indexedFile)
 					return
 				}
 				log.Println(err)
@@ -576,11 +1204,35 @@ func (i *Index) FindNewFiles(path string) {
 				log.Println(err)
 				return
 			}
-			if currFile.ModTime == info.ModTime() && currFile.Size == info.Size() {
+			if currFile.ModTime.Equal(info.ModTime()) && currFile.Size == info.Size() {
+				// Indexed before the owner, inode and times were collected. File systems that don't
+				// count links never get one, they are only stored again when the stat has more.
+				if statHasLinks && currFile.Links == 0 {
+					stat := currFile
+					statMetadata(filePath, info, &stat)
+					if stat.Links != 0 {
+						currFile = stat
+						if err := i.StoreIndex(filePath, currFile); err != nil {
+							log.Println(err)
+						}
+					}
+				}
+
+				// Hashing was cut short or the hash policy changed
+				i.queueHash(currFile, c.root)
+
+				// The content index is not stored, it is filled in again after a restart
+				if i.content != nil && !i.content.Has(filePath) {
+					i.indexContent(currFile)
+				}
+				// Archives indexed before archive indexing was enabled
+				if i.opts.Archive.Enabled && !i.hasArchiveEntries(filePath) {
+					i.indexArchive(currFile)
+				}
 				return
 			}
 
-			indexedFile, err := IndexFile(path, file)
+			indexedFile, err := indexMetadata(path, file)
 			if err != nil {
 				log.Println(err)
 				return
@@ -592,38 +1244,55 @@ func (i *Index) FindNewFiles(path string) {
 				return
 			}
 
+			i.queueHash(*indexedFile, c.root)
+			i.indexContent(*indexedFile)
+			i.indexArchive(*indexedFile)
 		}(file)
 	}
 
 	wg.Wait()
 }
 
-// CheckForRemovedFiles checks if any files have been removed from the index
-func (i *Index) CheckForRemovedFiles() {
+// CheckForRemovedFiles checks if any files have been removed from the index until ctx is done
+func (i *Index) CheckForRemovedFiles(ctx context.Context) {
 	const workers = 4
-	pathsCh := make(chan string)
+	filesCh := make(chan File)
 	toDelete := make(chan string)
 
 	go func() {
 		i.FilesMap.Range(func(key, value interface{}) bool {
 			file := value.(File)
-			pathsCh <- file.FullPath
+			select {
+			case filesCh <- file:
+			case <-ctx.Done():
+				return false
+			}
 			return true
 		})
-		close(pathsCh)
+		close(filesCh)
 	}()
 
 	var wg sync.WaitGroup
-	for i := 0; i < workers; i++ {
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for path := range pathsCh {
-				if _, err := os.Stat(path); os.IsNotExist(err) {
-					toDelete <- path
+			for file := range filesCh {
+				path := file.FullPath
+
+				// Entries of an archive are there as long as the archive is
+				onDisk := path
+				if archivePath, _, ok := archive.Split(path); ok {
+					if !i.opts.Archive.Enabled {
+						toDelete <- path
+						continue
+					}
+					onDisk = archivePath
 				}
 
-				if isBlacklisted(path) {
+				if _, err := os.Stat(onDisk); os.IsNotExist(err) {
+					toDelete <- path
+				} else if excluded, _ := i.excludes.Excluded(path, file.IsDir); excluded {
 					toDelete <- path
 				}
 			}
@@ -640,6 +1309,10 @@ func (i *Index) CheckForRemovedFiles() {
 		toDeleteSlice = append(toDeleteSlice, path)
 	}
 
+	if ctx.Err() != nil {
+		return
+	}
+
 	for _, path := range toDeleteSlice {
 		i.RemoveIndex(path)
 	}
@@ -647,151 +1320,96 @@ func (i *Index) CheckForRemovedFiles() {
 
 // StoreIndex stores a File in the FilesMap
 func (i *Index) StoreIndex(fullPath string, file File) error {
-	i.FilesMap.Store(fullPath, file)
-
-	atomic.AddInt32(&i.newFilesSinceStore, 1)
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
 
-	return nil
-}
+	i.putFile(fullPath, file)
 
-// RemoveIndex removes a File from the FilesMap
-func (i *Index) RemoveIndex(key string) error {
-	i.FilesMap.Delete(key)
+	atomic.AddInt32(&i.newFilesSinceStore, 1)
 
-	return nil
+	return i.writeJournal(journalOp{Op: opPut, Key: fullPath, Value: &file})
 }
 
-// GetIndex returns a File from the FilesMap
-func (i *Index) GetIndex(key string) (File, error) {
-
-	val, ok := i.FilesMap.Load(key)
+// updateIndex stores what update makes of the File in the FilesMap, nothing else changes the
+// File in between. Nothing is stored when update returns an error.
+func (i *Index) updateIndex(fullPath string, update func(File) (File, error)) (File, error) {
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
 
-	if !ok {
-		return File{}, ErrFileNotFound
+	file, err := i.GetIndex(fullPath)
+	if err != nil {
+		return file, err
 	}
 
-	file, ok := val.(File)
-	if !ok {
-		return File{}, ErrFileNotFound
+	file, err = update(file)
+	if err != nil {
+		return file, err
 	}
 
-	return file, nil
-}
+	i.putFile(fullPath, file)
 
-// ExistIndex checks if a key exists in the FilesMap
-func (i *Index) ExistIndex(key string) bool {
-	_, ok := i.FilesMap.Load(key)
-	return ok
+	atomic.AddInt32(&i.newFilesSinceStore, 1)
+
+	return file, i.writeJournal(journalOp{Op: opPut, Key: fullPath, Value: &file})
 }
 
-// LoadFileIndex reads the FilesMap from disk in NDJSON format.
-//
-// TODO: Make this function more robust. Currently it can take a long time to load the index from disk.
-func (i *Index) LoadFileIndex() error {
-	startTime := time.Now()
-	path, err := getTechMDWDir()
+// putFile stores a File in the FilesMap and the trigram index
+func (i *Index) putFile(key string, file File) {
+	i.FilesMap.Store(key, file)
+	i.trigrams.Add(key)
+	i.hashes.Add(key, file.Hash)
+	i.trackArchiveEntry(key, true)
+}
 
-	if err != nil {
-		return err
+// deleteFile removes a File from the FilesMap and the trigram index, it returns false when
+// there was none
+func (i *Index) deleteFile(key string) bool {
+	if _, ok := i.FilesMap.LoadAndDelete(key); !ok {
+		return false
 	}
 
-	file, err := os.Open(path)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			atomic.StoreInt64(&i.lastFileIndexLoad, time.Now().Unix())
-		}
-		return err
+	i.trigrams.Remove(key)
+	i.hashes.Remove(key)
+	i.trackArchiveEntry(key, false)
+	if i.content != nil {
+		i.content.Remove(key)
 	}
-	defer file.Close()
-
-	lz4Reader := lz4.NewReader(file)
-	decoder := json.NewDecoder(lz4Reader)
-
-	for {
-		var entry struct {
-			Key   string
-			Value File
-		}
+	return true
+}
 
-		err := decoder.Decode(&entry)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
+// RemoveIndex removes a File from the FilesMap
+func (i *Index) RemoveIndex(key string) error {
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
 
-		i.FilesMap.Store(entry.Key, entry.Value)
+	if !i.deleteFile(key) {
+		return nil
 	}
 
-	atomic.StoreInt64(&i.lastFileIndexLoad, time.Now().Unix())
-
-	log.Printf("Loaded index from file in %s", time.Since(startTime))
-	return nil
-}
-
-// Update the last time the index was stored to disk
-func (i *Index) updateLastStore() {
-	atomic.StoreInt64(&i.lastStore, time.Now().Unix())
-	atomic.StoreInt32(&i.newFilesSinceStore, 0)
-}
+	atomic.AddInt32(&i.newFilesSinceStore, 1)
 
-// Get the last time the index was stored to disk
-func (i *Index) getLastStore() time.Time {
-	return time.Unix(atomic.LoadInt64(&i.lastStore), 0)
+	return i.writeJournal(journalOp{Op: opRemove, Key: key})
 }
 
-// Create a copy of the FilesMap and store it to disk
-func (i *Index) StoreFileIndex() error {
-	g := graceful.Shutdown()
-	g.AddTask()
-	defer g.DoneTask()
+// GetIndex returns a File from the FilesMap
+func (i *Index) GetIndex(key string) (File, error) {
 
-	path, err := getTechMDWDir()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	val, ok := i.FilesMap.Load(key)
 
-	dir := filepath.Dir(path)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err = os.MkdirAll(dir, 0755)
-		if err != nil {
-			log.Println(err)
-			return err
-		}
+	if !ok {
+		return File{}, ErrFileNotFound
 	}
 
-	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		log.Println(err)
-		return err
+	file, ok := val.(File)
+	if !ok {
+		return File{}, ErrFileNotFound
 	}
-	defer file.Close()
-
-	lz4Writer := lz4.NewWriter(file)
-	defer lz4Writer.Close()
-
-	encoder := json.NewEncoder(lz4Writer)
-
-	i.FilesMap.Range(func(key, value interface{}) bool {
-		entry := struct {
-			Key   string
-			Value File
-		}{
-			Key:   key.(string),
-			Value: value.(File),
-		}
 
-		if err := encoder.Encode(entry); err != nil {
-			log.Println(err)
-			return false
-		}
-
-		return true
-	})
-
-	i.updateLastStore()
+	return file, nil
+}
 
-	return nil
+// ExistIndex checks if a key exists in the FilesMap
+func (i *Index) ExistIndex(key string) bool {
+	_, ok := i.FilesMap.Load(key)
+	return ok
 }
@@ -0,0 +1,486 @@
+package indexing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/indexing"
+)
+
+// newTestIndex returns an index of an empty root that is stored in a temporary directory
+func newTestIndex(t *testing.T) *indexing.Index {
+	return newTestIndexWithOptions(t, nil)
+}
+
+// newTestIndexWithOptions returns a test index with the options that set changes, set can be nil
+func newTestIndexWithOptions(t *testing.T, set func(*indexing.Options)) *indexing.Index {
+	t.Helper()
+
+	opts := indexing.DefaultOptions()
+	opts.Roots = []indexing.Root{{Path: t.TempDir()}}
+	opts.StoragePath = filepath.Join(t.TempDir(), indexing.IndexFileName)
+	if set != nil {
+		set(&opts)
+	}
+
+	idx, err := indexing.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { idx.Close() })
+
+	return idx
+}
+
+// startTestIndex returns a started test index, it is stopped when the test ends
+func startTestIndex(t *testing.T, set func(*indexing.Options)) *indexing.Index {
+	t.Helper()
+
+	idx := newTestIndexWithOptions(t, set)
+	if err := idx.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { idx.Stop() })
+
+	return idx
+}
+
+func TestNewIndependentInstances(t *testing.T) {
+	a := newTestIndex(t)
+	b := newTestIndex(t)
+
+	a.StoreIndex("/a/file.txt", indexing.File{Name: "file.txt", FullPath: "/a/file.txt"})
+
+	if !a.ExistIndex("/a/file.txt") {
+		t.Error("Expected the file to be in the first index")
+	}
+
+	if b.ExistIndex("/a/file.txt") {
+		t.Error("Expected the file not to be in the second index")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storagePath := filepath.Join(t.TempDir(), indexing.IndexFileName)
+	set := func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.StoragePath = storagePath
+	}
+
+	idx := newTestIndexWithOptions(t, set)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := idx.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := idx.Start(ctx); err != indexing.ErrAlreadyStarted {
+		t.Errorf("Expected ErrAlreadyStarted, but got %v", err)
+	}
+
+	helloPath := filepath.ToSlash(filepath.Join(root, "hello.txt"))
+	waitFor(t, func() bool { return idx.ExistIndex(helloPath) })
+
+	// Stop flushes the index to disk
+	if err := idx.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := idx.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// And a new index loads it on start
+	reloaded := newTestIndexWithOptions(t, set)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.ExistIndex(helloPath) {
+		t.Error("Expected the stored file to be loaded")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchAppliesChanges(t *testing.T) {
+	root := t.TempDir()
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Watch.PollInterval = indexing.Duration(50 * time.Millisecond)
+	})
+
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(sub, "new.txt")
+	if err := os.WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.ToSlash(file)
+	waitFor(t, func() bool { return idx.ExistIndex(filePath) })
+
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return !idx.ExistIndex(filePath) && !idx.ExistIndex(filepath.ToSlash(sub))
+	})
+}
+
+func TestExcludeRules(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		".gitignore":        "*.log\n",
+		"keep.txt":          "",
+		"drop.log":          "",
+		"sub/.ignore":       "!important.log\n",
+		"sub/important.log": "",
+		"sub/other.log":     "",
+		"skip/inside.txt":   "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Exclude = []string{"skip/"}
+	})
+
+	path := func(name string) string { return filepath.ToSlash(filepath.Join(root, name)) }
+
+	waitFor(t, func() bool {
+		return idx.ExistIndex(path("keep.txt")) && idx.ExistIndex(path("sub/important.log"))
+	})
+	idx.Stop()
+
+	for _, name := range []string{"drop.log", "sub/other.log", "skip", "skip/inside.txt"} {
+		if idx.ExistIndex(path(name)) {
+			t.Errorf("Expected %s to be excluded", name)
+		}
+	}
+
+	excluded, rule := idx.WhyExcluded(path("sub/other.log"))
+	if !excluded || rule == nil || rule.Source != path(".gitignore") || rule.Line != 1 {
+		t.Errorf("Expected sub/other.log to be excluded by .gitignore line 1, got %v %v", excluded, rule)
+	}
+
+	excluded, rule = idx.WhyExcluded(path("skip/inside.txt"))
+	if !excluded || rule == nil || rule.Source != "config" {
+		t.Errorf("Expected skip/inside.txt to be excluded by the config, got %v %v", excluded, rule)
+	}
+
+	if excluded, _ := idx.WhyExcluded(path("sub/important.log")); excluded {
+		t.Error("Expected sub/important.log to be included again")
+	}
+}
+
+func TestRootMaxDepth(t *testing.T) {
+	root := t.TempDir()
+
+	for _, name := range []string{"top.txt", "a/middle.txt", "a/b/deep.txt", "a/b/c/deeper.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root, MaxDepth: 2}}
+	})
+
+	path := func(name string) string { return filepath.ToSlash(filepath.Join(root, name)) }
+
+	// The directory at the limit is indexed, but not what is in it
+	waitFor(t, func() bool {
+		return idx.ExistIndex(path("top.txt")) && idx.ExistIndex(path("a/middle.txt")) && idx.ExistIndex(path("a/b"))
+	})
+	idx.Stop()
+
+	for _, name := range []string{"a/b/deep.txt", "a/b/c", "a/b/c/deeper.txt"} {
+		if idx.ExistIndex(path(name)) {
+			t.Errorf("Expected %s below the depth limit not to be indexed", name)
+		}
+	}
+}
+
+func TestHashPolicy(t *testing.T) {
+	root := t.TempDir()
+
+	small := filepath.Join(root, "small.txt")
+	if err := os.WriteFile(small, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	big := filepath.Join(root, "big.txt")
+	if err := os.WriteFile(big, []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Hash.Algorithms = []string{"sha256"}
+		o.Hash.MaxSize = 10
+	})
+
+	smallPath, bigPath := filepath.ToSlash(small), filepath.ToSlash(big)
+	waitFor(t, func() bool {
+		f, err := idx.GetIndex(smallPath)
+		return err == nil && f.Hash.SHA2.SHA256 != ""
+	})
+
+	f, _ := idx.GetIndex(smallPath)
+	if f.Hash.SHA2.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" || f.Hash.MD5 != "" {
+		t.Errorf("Expected only the SHA256 of the policy, got %+v", f.Hash)
+	}
+
+	if f, _ := idx.GetIndex(bigPath); f.Hash != (hash.Hash{}) {
+		t.Errorf("Expected the file over the size limit not to be hashed, got %+v", f.Hash)
+	}
+
+	// On demand hashes are stored with the file, whatever its size
+	md5, err := idx.FileHash(context.Background(), bigPath, hash.MD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5 != "e4d7f1b4ed2e42d15898f4b27b019da4" {
+		t.Errorf("Unexpected MD5 %s", md5)
+	}
+	if f, _ := idx.GetIndex(bigPath); f.Hash.MD5 != md5 {
+		t.Errorf("Expected the MD5 to be stored, got %+v", f.Hash)
+	}
+
+	if _, err := idx.FileHash(context.Background(), filepath.ToSlash(filepath.Join(root, "sub")), hash.MD5); !errors.Is(err, indexing.ErrNotHashable) {
+		t.Errorf("Expected ErrNotHashable for a directory, got %v", err)
+	}
+	if _, err := idx.FileHash(context.Background(), bigPath, "md6"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
+
+func TestRootHash(t *testing.T) {
+	hashed, skipped := t.TempDir(), t.TempDir()
+	for _, dir := range []string{hashed, skipped} {
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	no := false
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: hashed}, {Path: skipped, Hash: &no}}
+		o.Hash.Enabled = true
+	})
+
+	hashedPath := filepath.ToSlash(filepath.Join(hashed, "file.txt"))
+	skippedPath := filepath.ToSlash(filepath.Join(skipped, "file.txt"))
+	waitFor(t, func() bool {
+		f, err := idx.GetIndex(hashedPath)
+		return err == nil && f.Hash != (hash.Hash{}) && idx.ExistIndex(skippedPath) && idx.PendingHashes() == 0
+	})
+	idx.Stop()
+
+	if f, _ := idx.GetIndex(skippedPath); f.Hash != (hash.Hash{}) {
+		t.Errorf("Expected the file of the root with hashing off not to be hashed, got %+v", f.Hash)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"a/one.txt":   "same content",
+		"b/two.txt":   "same content",
+		"b/three.log": "same content",
+		"c/other.txt": "same length!",
+		"c/small.txt": "tiny",
+		"c/tiny.txt":  "tiny",
+		"c/empty.txt": "",
+		"c/void.txt":  "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Hash.Enabled = false
+	})
+
+	base := filepath.ToSlash(root)
+	waitFor(t, func() bool {
+		for name := range files {
+			if !idx.ExistIndex(base + "/" + name) {
+				return false
+			}
+		}
+		return true
+	})
+
+	groups, err := idx.Duplicates(context.Background(), indexing.DuplicateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected two groups, got %+v", groups)
+	}
+
+	want := []string{base + "/a/one.txt", base + "/b/three.log", base + "/b/two.txt"}
+	if fmt.Sprint(groups[0].Paths) != fmt.Sprint(want) || groups[0].Reclaimable != 24 || groups[0].Algorithm != hash.SHA256 {
+		t.Errorf("Expected the biggest group first, got %+v", groups[0])
+	}
+	if groups[1].Size != 4 || len(groups[1].Paths) != 2 {
+		t.Errorf("Expected the tiny files, got %+v", groups[1])
+	}
+
+	// Digests are stored once they are computed
+	if f, _ := idx.GetIndex(base + "/a/one.txt"); f.Hash.SHA2.SHA256 != groups[0].Hash {
+		t.Errorf("Expected the hash to be stored, got %+v", f.Hash)
+	}
+
+	groups, err = idx.Duplicates(context.Background(), indexing.DuplicateOptions{
+		Roots:      []string{base + "/b", base + "/a/"},
+		Extensions: []string{"txt"},
+		MinSize:    5,
+		Algorithm:  hash.MD5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || fmt.Sprint(groups[0].Paths) != fmt.Sprint([]string{base + "/a/one.txt", base + "/b/two.txt"}) {
+		t.Errorf("Expected the filtered group, got %+v", groups)
+	}
+}
+
+func TestDuplicatesHardLinks(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("hard links are only told apart where the inode is collected")
+	}
+
+	root := t.TempDir()
+	for name, content := range map[string]string{"one.txt": "same content", "two.txt": "same content", "solo.txt": "only one copy"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{"one-link.txt": "one.txt", "solo-link.txt": "solo.txt"} {
+		if err := os.Link(filepath.Join(root, target), filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Hash.Enabled = false
+	})
+
+	base := filepath.ToSlash(root)
+	waitFor(t, func() bool {
+		for _, name := range []string{"one.txt", "two.txt", "solo.txt", "one-link.txt", "solo-link.txt"} {
+			if !idx.ExistIndex(base + "/" + name) {
+				return false
+			}
+		}
+		return true
+	})
+
+	groups, err := idx.Duplicates(context.Background(), indexing.DuplicateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The names of solo.txt are one file, one.txt and its link only free the space of two.txt
+	want := []string{base + "/one-link.txt", base + "/one.txt", base + "/two.txt"}
+	if len(groups) != 1 || fmt.Sprint(groups[0].Paths) != fmt.Sprint(want) || groups[0].Reclaimable != 12 {
+		t.Errorf("Expected one group that frees one copy, got %+v", groups)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the inode, links and ids are only collected on unix, the access time on linux")
+	}
+
+	root := t.TempDir()
+	file := filepath.Join(root, "file.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(file, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Watch.Enabled = false
+	})
+
+	path := filepath.ToSlash(file)
+	waitFor(t, func() bool {
+		_, err := idx.GetIndex(path)
+		return err == nil
+	})
+
+	f, _ := idx.GetIndex(path)
+	if f.Inode == 0 || f.Links != 2 || f.AccessedTime.IsZero() {
+		t.Errorf("Expected the inode, 2 links and the access time, got %+v", f)
+	}
+	if int(f.Permissions.UID) != os.Getuid() || int(f.Permissions.GID) != os.Getgid() || f.Permissions.Owner == "" || f.Permissions.Group == "" {
+		t.Errorf("Expected the owner of the file, got %+v", f.Permissions)
+	}
+
+	q := fmt.Sprintf("inode:%d links:>=2 uid:%d owner:%q", f.Inode, os.Getuid(), f.Permissions.Owner)
+	if got := searchNames(t, idx, q); fmt.Sprint(got) != "[file.txt link.txt]" {
+		t.Errorf("%s: got %v", q, got)
+	}
+}
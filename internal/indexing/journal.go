@@ -0,0 +1,340 @@
+package indexing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// The journal records every change to the FilesMap since the last snapshot. It starts with a
+// header and is followed by records that are appended as the index changes:
+//
+//	magic      [8]byte  "TMDWJNL\n"
+//	generation uint64   little endian
+//
+//	length     uint32   little endian, length of the payload
+//	checksum   uint32   little endian, CRC-32 (IEEE) of the payload
+//	payload    JSON encoded journalOp
+//
+// A snapshot stores the generation of the journal that was started right before it was taken,
+// loading replays every journal of that generation or newer on top of it. The journal of the
+// generation before is kept next to it for the previous snapshot.
+const (
+	journalMagic      = "TMDWJNL\n"
+	journalHeaderSize = len(journalMagic) + 8
+	recordHeaderSize  = 8
+
+	// Suffixes of the current journal and of the one before it
+	journalSuffix    = ".journal"
+	oldJournalSuffix = ".journal.old"
+
+	// Records can't be bigger than this, anything bigger is treated as corruption
+	maxRecordSize = 16 << 20
+)
+
+const (
+	opPut    = "put"
+	opRemove = "remove"
+)
+
+type journalOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value *File  `json:"value,omitempty"`
+}
+
+// journal appends records to a journal file, writes are buffered until sync
+type journal struct {
+	file       *os.File
+	w          *bufio.Writer
+	generation uint64
+	size       int64 // Bytes in the file including what is buffered
+	records    int   // Records since the snapshot
+}
+
+// createJournal creates an empty journal, replacing the file if it exists
+func createJournal(path string, generation uint64) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, journalHeaderSize)
+	copy(header, journalMagic)
+	binary.LittleEndian.PutUint64(header[len(journalMagic):], generation)
+
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &journal{
+		file:       file,
+		w:          bufio.NewWriter(file),
+		generation: generation,
+		size:       int64(journalHeaderSize),
+	}, nil
+}
+
+// openJournal continues a journal that was read with readJournal. Anything after the last
+// good record, like a record that was cut off by a crash, is dropped.
+func openJournal(path string, j journalFile) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Truncate(j.size); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(j.size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &journal{
+		file:       file,
+		w:          bufio.NewWriter(file),
+		generation: j.generation,
+		size:       j.size,
+		records:    len(j.ops),
+	}, nil
+}
+
+func (j *journal) append(op journalOp) error {
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint32(header, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+
+	if _, err := j.w.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := j.w.Write(payload); err != nil {
+		return err
+	}
+
+	j.size += int64(len(header) + len(payload))
+	j.records++
+
+	return nil
+}
+
+// sync writes the buffered records to disk
+func (j *journal) sync() error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+
+	return j.file.Sync()
+}
+
+func (j *journal) close() error {
+	err := j.sync()
+
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// journalFile is what readJournal found in a journal
+type journalFile struct {
+	generation uint64
+	ops        []journalOp
+	size       int64 // Up to the end of the last good record
+}
+
+// readJournal reads the records of a journal up to the first bad one. A bad record at the end is
+// expected after a crash and is not an error, the records before it are still good.
+func readJournal(path string) (journalFile, error) {
+	var j journalFile
+
+	file, err := os.Open(path)
+	if err != nil {
+		return j, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	header := make([]byte, journalHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return j, fmt.Errorf("%w: journal %s: %v", ErrCorruptIndex, path, err)
+	}
+
+	if string(header[:len(journalMagic)]) != journalMagic {
+		return j, fmt.Errorf("%w: journal %s: unknown format", ErrCorruptIndex, path)
+	}
+
+	j.generation = binary.LittleEndian.Uint64(header[len(journalMagic):])
+	j.size = int64(journalHeaderSize)
+
+	recordHeader := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, recordHeader); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return j, nil
+			}
+			return j, err
+		}
+
+		length := binary.LittleEndian.Uint32(recordHeader)
+		if length > maxRecordSize {
+			return j, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return j, nil
+			}
+			return j, err
+		}
+
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(recordHeader[4:]) {
+			return j, nil
+		}
+
+		var op journalOp
+		if err := json.Unmarshal(payload, &op); err != nil {
+			return j, nil
+		}
+
+		j.ops = append(j.ops, op)
+		j.size += int64(recordHeaderSize) + int64(length)
+	}
+}
+
+// replay applies the records to the FilesMap
+func (i *Index) replay(ops []journalOp) {
+	for _, op := range ops {
+		switch op.Op {
+		case opPut:
+			if op.Value != nil {
+				i.putFile(op.Key, *op.Value)
+			}
+		case opRemove:
+			i.deleteFile(op.Key)
+		}
+	}
+}
+
+// writeJournal records a change, it must be called with the journalLock held
+func (i *Index) writeJournal(op journalOp) error {
+	if i.journal == nil {
+		return nil
+	}
+
+	return i.journal.append(op)
+}
+
+// SyncJournal writes the changes since the last sync to disk
+func (i *Index) SyncJournal() error {
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
+
+	if i.journal == nil {
+		return nil
+	}
+
+	changes := atomic.LoadInt32(&i.newFilesSinceStore)
+
+	if err := i.journal.sync(); err != nil {
+		return err
+	}
+
+	i.updateLastStore(changes)
+
+	return nil
+}
+
+// closeJournal syncs and closes the journal, changes are not journaled until it is opened again
+func (i *Index) closeJournal() error {
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
+
+	if i.journal == nil {
+		return nil
+	}
+
+	changes := atomic.LoadInt32(&i.newFilesSinceStore)
+
+	err := i.journal.close()
+	i.journal = nil
+
+	if err == nil {
+		i.updateLastStore(changes)
+	}
+
+	return err
+}
+
+// rotateJournal starts the journal of the next generation for a new snapshot, the current one
+// is kept as the old journal. When the last snapshot never made it to disk the current journal
+// is newer than the snapshot and is kept as it is, rotating again would drop the old journal the
+// snapshot on disk still needs. It must be called with the journalLock held.
+func (i *Index) rotateJournal() (uint64, error) {
+	if i.journal != nil && i.generation > i.stored {
+		return i.generation, nil
+	}
+
+	path := i.opts.StoragePath + journalSuffix
+	generation := i.generation + 1
+
+	if i.journal != nil {
+		if err := i.journal.close(); err != nil {
+			return 0, err
+		}
+		i.journal = nil
+
+		if err := os.Rename(path, i.opts.StoragePath+oldJournalSuffix); err != nil {
+			return 0, err
+		}
+	}
+
+	j, err := createJournal(path, generation)
+	if err != nil {
+		return 0, err
+	}
+
+	i.journal = j
+	i.generation = generation
+
+	return generation, nil
+}
+
+// needsCompaction reports whether the journal has grown enough to be folded into a new snapshot
+func (i *Index) needsCompaction() bool {
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
+
+	if i.journal == nil {
+		return false
+	}
+
+	if i.journal.records >= i.opts.Persist.CompactChanges {
+		return true
+	}
+
+	return i.opts.Persist.CompactSize > 0 && i.journal.size >= int64(i.opts.Persist.CompactSize)
+}
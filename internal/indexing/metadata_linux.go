@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package indexing
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/utils"
+	"golang.org/x/sys/unix"
+)
+
+const statxMask = unix.STATX_BTIME | unix.STATX_ATIME | unix.STATX_INO | unix.STATX_NLINK | unix.STATX_UID | unix.STATX_GID
+
+// Whether statMetadata fills in the link count, files without it were indexed before it did
+const statHasLinks = true
+
+// statMetadata fills in what Linux knows about a file on top of info. statx has the birth
+// time, when the filesystem keeps it, the stat of info is used when statx isn't there.
+func statMetadata(path string, info fs.FileInfo, file *File) {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW|unix.AT_STATX_DONT_SYNC, statxMask, &stx)
+	if err != nil {
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return
+		}
+
+		file.AccessedTime = time.Unix(stat.Atim.Unix())
+		setStat(file, stat.Ino, stat.Dev, uint64(stat.Nlink), stat.Uid, stat.Gid)
+		return
+	}
+
+	if stx.Mask&unix.STATX_BTIME != 0 {
+		file.CreatedTime = time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	}
+	if stx.Mask&unix.STATX_ATIME != 0 {
+		file.AccessedTime = time.Unix(stx.Atime.Sec, int64(stx.Atime.Nsec))
+	}
+	setStat(file, stx.Ino, unix.Mkdev(stx.Dev_major, stx.Dev_minor), uint64(stx.Nlink), stx.Uid, stx.Gid)
+}
+
+func setStat(file *File, inode, device, links uint64, uid, gid uint32) {
+	file.Inode = inode
+	file.Device = device
+	file.Links = links
+	file.Permissions.UID = uid
+	file.Permissions.GID = gid
+	file.Permissions.Owner = utils.UserName(uid)
+	file.Permissions.Group = utils.GroupName(gid)
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+// +build !unix,!windows
+
+package indexing
+
+import "io/fs"
+
+// Whether statMetadata fills in the link count, files without it were indexed before it did
+const statHasLinks = false
+
+// statMetadata knows nothing more than info on this system
+func statMetadata(path string, info fs.FileInfo, file *File) {}
@@ -0,0 +1,31 @@
+//go:build unix && !linux
+// +build unix,!linux
+
+package indexing
+
+import (
+	"io/fs"
+	"syscall"
+
+	"github.com/TechMDW/indexing/internal/utils"
+)
+
+// Whether statMetadata fills in the link count, files without it were indexed before it did
+const statHasLinks = true
+
+// statMetadata fills in what the stat of info knows about a file. The fields of the times
+// are named differently on every system, only the ids are taken from it.
+func statMetadata(path string, info fs.FileInfo, file *File) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	file.Inode = uint64(stat.Ino)
+	file.Device = uint64(stat.Dev)
+	file.Links = uint64(stat.Nlink)
+	file.Permissions.UID = stat.Uid
+	file.Permissions.GID = stat.Gid
+	file.Permissions.Owner = utils.UserName(stat.Uid)
+	file.Permissions.Group = utils.GroupName(stat.Gid)
+}
@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package indexing
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Whether statMetadata fills in the link count, files without it were indexed before it did
+const statHasLinks = false
+
+// statMetadata fills in the creation and access times Windows has for every file. The file
+// index and owner would need the file to be opened, so they are left out.
+func statMetadata(path string, info fs.FileInfo, file *File) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return
+	}
+
+	file.CreatedTime = time.Unix(0, data.CreationTime.Nanoseconds())
+	file.AccessedTime = time.Unix(0, data.LastAccessTime.Nanoseconds())
+}
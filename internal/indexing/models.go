@@ -1,25 +1,117 @@
 package indexing
 
 import (
-	"fmt"
+	"context"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/TechMDW/indexing/internal/attributes"
+	"github.com/TechMDW/indexing/internal/content"
+	"github.com/TechMDW/indexing/internal/extract"
 	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/ignore"
+	"github.com/TechMDW/indexing/internal/trigram"
+	"github.com/TechMDW/indexing/internal/watcher"
 )
 
 type Index struct {
 	FilesMap           sync.Map     `json:"files"`
-	WindowsDrivesLock  sync.RWMutex `json:"-"`
-	WindowsDrives      *[]string    `json:"windowsDrivesArray"`
+	RootsLock          sync.RWMutex `json:"-"`
+	Roots              *[]Root      `json:"roots"`
 	FindNewFilesMap    sync.Map     `json:"-"`
-	lastFileIndexLoad  int64
+	skipMounts         sync.Map
+	discoverRoots      bool
+	opts               Options
+	excludes           *ignore.Matcher
+	ignoreFiles        map[string]struct{} // Names of the ignore files that are honored
+	lim                chan struct{}       // Limits the goroutines indexing files
+	lifecycleLock      sync.Mutex
+	cancel             context.CancelFunc // Set while started
+	tasks              sync.WaitGroup
+	watcher            watcher.Watcher // Set while started with watching enabled
+	rescan             chan struct{}   // Requests a crawl of every root and a check for removed files
 	newFilesSinceStore int32
 	lastStore          int64
+	storeLock          sync.Mutex     // Only one StoreFileIndex at a time
+	journalLock        sync.Mutex     // Keeps the journal in the same order as the FilesMap changes
+	journal            *journal       // Set once the index is loaded or stored
+	generation         uint64         // Of the current journal
+	stored             uint64         // Generation of the snapshot on disk
+	trigrams           *trigram.Index // Keys of the FilesMap by the trigrams in them, kept up to date with it
+	content            *content.Index // Words in text files, nil unless content indexing is enabled
+	contentExts        map[string]struct{}
+	extractors         *extract.Registry
+	hashAlgs           []hash.Algorithm // Of the hash policy
+	hashes             *hash.Index      // Keys of the FilesMap by their digests, kept up to date with it
+	hashQueue          *hashQueue       // Files waiting to be hashed
+	archiveLock        sync.Mutex
+	archiveEntries     map[string]map[string]struct{} // Keys indexed from each archive, by its path
+	profiles           map[string]*profile            // Scoring profiles by name
+}
+
+// Root is a directory that gets crawled
+type Root struct {
+	Path     string `json:"path"`
+	MaxDepth int    `json:"maxDepth"` // How many directories deep to crawl, 0 means no limit
+	Hash     *bool  `json:"hash"`     // Whether to hash the files found under this root, nil follows hash.enabled
+}
+
+// Options configures a new Index, it is also the format of the config file
+type Options struct {
+	// Roots to crawl. When empty the drives (Windows) or mount points (Linux) are
+	// discovered and kept up to date while running.
+	Roots []Root `json:"roots"`
+
+	// Paths that are not indexed in the .gitignore format, on top of the default excludes
+	Exclude []string `json:"exclude"`
+
+	// Names of the ignore files whose rules apply to the directory they are found in,
+	// an empty list honors none
+	IgnoreFiles []string `json:"ignoreFiles"`
+
+	Scan    ScanOptions    `json:"scan"`
+	Watch   WatchOptions   `json:"watch"`
+	Persist PersistOptions `json:"persist"`
+	Hash    HashOptions    `json:"hash"`
+	Content ContentOptions `json:"content"`
+	Archive ArchiveOptions `json:"archive"`
+	Linux   LinuxOptions   `json:"linux"`
+
+	// Maximum number of results returned by Search
+	MaxResults int `json:"maxResults"`
+
+	// Scoring profiles by name, a search picks one with SearchOptions.Profile
+	Profiles map[string]ScoringProfile `json:"profiles"`
+
+	// Scoring profile of the searches that don't pick one, none weighs every rule 1
+	Profile string `json:"profile"`
+
+	// Number of files indexed at the same time
+	Workers int `json:"workers"`
+
+	// Where the index is stored, defaults to the index file in the config directory
+	StoragePath string `json:"storagePath"`
+
+	// Extractors get the text out of documents for content indexing, defaults to
+	// extract.Default with the built in formats and whatever was registered there
+	Extractors *extract.Registry `json:"-"`
+
+	// ReadOnly reads the index file but never writes it, for tools that look at the index
+	// of an indexer that is running. Changes are only kept in memory.
+	ReadOnly bool `json:"-"`
+}
+
+// hashEnabled reports whether files under the root are hashed
+func (r Root) hashEnabled(policy HashOptions) bool {
+	if r.Hash != nil {
+		return *r.Hash
+	}
+	return policy.Enabled
 }
 
 type File struct {
@@ -36,15 +128,20 @@ type File struct {
 	ModTime               time.Time                    `json:"modTime"`
 	AccessedTime          time.Time                    `json:"accessed"`
 	Permissions           Permissions                  `json:"permissions"`
+	Inode                 uint64                       `json:"inode,omitempty"`
+	Device                uint64                       `json:"device,omitempty"`
+	Links                 uint64                       `json:"links,omitempty"` // Hard links, 0 when the system doesn't say and then the inode, device and ids aren't known either
 	Hash                  hash.Hash                    `json:"hash"`
 	Error                 string                       `json:"error,omitempty"`
 	WindowsAttributes     attributes.WindowsAttributes `json:"windowsAttributes,omitempty"`
-	Internal_metadata     internal_metadata
+	Internal_metadata     internal_metadata            // Only used while searching, not stored in the index file
 }
 
 type internal_metadata struct {
 	Score      int
 	Score_data interface{}
+	Positions  []int             // Characters of the name the query matched in runes, for highlighting
+	Snippets   []content.Snippet // Lines where content: filters matched
 }
 
 type Permissions struct {
@@ -52,6 +149,8 @@ type Permissions struct {
 	Group      string      `json:"group"`
 	Other      string      `json:"other"`
 	Permission os.FileMode `json:"permission"`
+	UID        uint32      `json:"uid"`
+	GID        uint32      `json:"gid"`
 }
 
 type PathInfo struct {
@@ -66,6 +165,7 @@ type PathInfo struct {
 	Separator    string `json:"separator"`
 }
 
+// getTechMDWDir returns the directory holding the config and the index file
 func getTechMDWDir() (string, error) {
 	path, err := os.UserConfigDir()
 
@@ -73,21 +173,106 @@ func getTechMDWDir() (string, error) {
 		return "", err
 	}
 
-	goDownHistoryPath := filepath.Join(path, "TechMDW", "indexing", IndexFileName)
+	return filepath.Join(path, "TechMDW", "indexing"), nil
+}
+
+func getIndexFilePath() (string, error) {
+	dir, err := getTechMDWDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, IndexFileName), nil
+}
+
+// joinPath joins a directory and a name without doubling the separator of roots like "/" or "C:/"
+func joinPath(dir, name string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + name
+}
+
+// compileExcludes parses the default excludes followed by the configured ones
+func compileExcludes(extra []string) ([]*ignore.Pattern, error) {
+	var patterns []*ignore.Pattern
+
+	for _, list := range []struct {
+		source   string
+		patterns []string
+	}{
+		{"default", defaultExcludes},
+		{"config", extra},
+	} {
+		for _, text := range list.patterns {
+			p, err := ignore.ParsePattern(text, "", list.source, 0)
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+
+	return patterns, nil
+}
+
+// isExcluded reports whether a rule excludes the path. The directories above it are not
+// checked, the crawler never gets to a path below an excluded directory.
+func (i *Index) isExcluded(path string, isDir bool) bool {
+	p := i.excludes.Match(filepath.ToSlash(path), isDir)
+	return p != nil && !p.Negate
+}
+
+// WhyExcluded reports whether path is excluded, including through a directory above it, and the
+// rule that decided it. The rule is nil when none matched, a rule starting with "!" means a
+// rule that came before it was overridden.
+func (i *Index) WhyExcluded(path string) (bool, *ignore.Pattern) {
+	path = filepath.ToSlash(path)
+
+	isDir := false
+	if info, err := os.Lstat(path); err == nil {
+		isDir = info.IsDir()
+	} else if f, err := i.GetIndex(path); err == nil {
+		isDir = f.IsDir
+	}
+
+	return i.excludes.Excluded(path, isDir)
+}
 
-	return goDownHistoryPath, nil
+// isIgnoreFile reports whether name is one of the ignore files that are honored
+func (i *Index) isIgnoreFile(name string) bool {
+	_, ok := i.ignoreFiles[name]
+	return ok
 }
 
-func isBlacklisted(path string) bool {
-	for _, b := range blacklist {
-		match, err := regexp.MatchString(b, filepath.Clean(path))
+// loadIgnoreFiles reads the rules of the ignore files in dir, entries is what dir contains
+func (i *Index) loadIgnoreFiles(dir string, entries []fs.DirEntry) {
+	if len(i.ignoreFiles) == 0 {
+		return
+	}
+
+	var patterns []*ignore.Pattern
+	for _, entry := range entries {
+		if entry.IsDir() || !i.isIgnoreFile(entry.Name()) {
+			continue
+		}
+
+		path := joinPath(dir, entry.Name())
+
+		f, err := os.Open(path)
 		if err != nil {
-			fmt.Printf("Invalid regex pattern: %v\n", err)
-			return false
+			log.Println(err)
+			continue
 		}
-		if match {
-			return true
+
+		p, err := ignore.Parse(f, dir, path)
+		f.Close()
+		if err != nil {
+			log.Println(err)
+			continue
 		}
+
+		patterns = append(patterns, p...)
 	}
-	return false
+
+	i.excludes.SetDir(dir, patterns)
 }
@@ -0,0 +1,191 @@
+package indexing
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Mount struct {
+	ID       int    `json:"id"`
+	ParentID int    `json:"parentId"`
+	Major    int    `json:"major"`
+	Minor    int    `json:"minor"`
+	Root     string `json:"root"`
+	Path     string `json:"path"`
+	FSType   string `json:"fsType"`
+	Source   string `json:"source"`
+}
+
+// ParseMountInfo parses the /proc/<pid>/mountinfo format described in proc(5)
+func ParseMountInfo(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		// The optional fields are terminated by a single "-"
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+
+		if len(fields) < 6 || sep == -1 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("invalid mountinfo line: %q", line)
+		}
+
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount id %q: %w", fields[0], err)
+		}
+
+		parentID, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent id %q: %w", fields[1], err)
+		}
+
+		majorStr, minorStr, ok := strings.Cut(fields[2], ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid device %q", fields[2])
+		}
+
+		major, err := strconv.Atoi(majorStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device %q: %w", fields[2], err)
+		}
+
+		minor, err := strconv.Atoi(minorStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device %q: %w", fields[2], err)
+		}
+
+		mounts = append(mounts, Mount{
+			ID:       id,
+			ParentID: parentID,
+			Major:    major,
+			Minor:    minor,
+			Root:     unescapeMountPath(fields[3]),
+			Path:     unescapeMountPath(fields[4]),
+			FSType:   fields[sep+1],
+			Source:   unescapeMountPath(fields[sep+2]),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space etc.) the kernel uses in mountinfo
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// isIgnoredFilesystem reports whether mounts of the given type should never be indexed
+func isIgnoredFilesystem(fsType string, opts LinuxOptions) bool {
+	for _, t := range opts.IgnoreFilesystems {
+		if t == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// mountRoots splits the mounts into the paths that should be crawled and the
+// mount points that the crawler must not descend into. When the crawler crosses devices
+// the mounts below another root are crawled from there and are not roots of their own,
+// unless they are below a skipped mount the crawl doesn't go into.
+func mountRoots(mounts []Mount, opts LinuxOptions) (roots []string, skip map[string]struct{}) {
+	skip = make(map[string]struct{})
+
+	seen := make(map[string]struct{})
+	for _, m := range mounts {
+		// The root filesystem is always indexed, in containers it is usually an overlay
+		if m.Path != "/" && isIgnoredFilesystem(m.FSType, opts) {
+			skip[m.Path] = struct{}{}
+			continue
+		}
+
+		// Bind mounts and remounts show up more than once
+		if _, ok := seen[m.Path]; ok {
+			continue
+		}
+		seen[m.Path] = struct{}{}
+
+		roots = append(roots, m.Path)
+	}
+
+	// A real filesystem mounted on top of an ignored one wins
+	for _, r := range roots {
+		delete(skip, r)
+	}
+
+	if !opts.CrossDevices {
+		return roots, skip
+	}
+
+	var top []string
+	for _, r := range roots {
+		if !crawledFrom(r, roots, skip) {
+			top = append(top, r)
+		}
+	}
+
+	return top, skip
+}
+
+// crawledFrom reports whether the crawl of one of the other roots reaches path, without a
+// skipped mount in between
+func crawledFrom(path string, roots []string, skip map[string]struct{}) bool {
+	for _, root := range roots {
+		if root == path || !isBelow(path, root) {
+			continue
+		}
+
+		reached := true
+		for s := range skip {
+			if isBelow(s, root) && (s == path || isBelow(path, s)) {
+				reached = false
+				break
+			}
+		}
+		if reached {
+			return true
+		}
+	}
+	return false
+}
+
+// isBelow reports whether path is inside dir
+func isBelow(path, dir string) bool {
+	return path != dir && (dir == "/" || strings.HasPrefix(path, dir+"/"))
+}
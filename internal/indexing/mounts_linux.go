@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package indexing
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// linuxMounts reads the mount table of the current process
+func linuxMounts() ([]Mount, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseMountInfo(f)
+}
+
+// deviceID returns the device the file lives on
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true
+}
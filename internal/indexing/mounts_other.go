@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package indexing
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func linuxMounts() ([]Mount, error) {
+	return nil, errors.New("mountinfo is only available on linux")
+}
+
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package indexing_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/indexing"
+)
+
+const mountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:22 / /proc rw,nosuid,nodev,noexec,relatime shared:5 - proc proc rw
+24 22 0:23 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw
+25 22 8:2 / /home rw,relatime shared:30 - ext4 /dev/sda2 rw
+26 22 8:3 / /mnt/my\040disk rw,relatime - xfs /dev/sdb1 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := indexing.ParseMountInfo(strings.NewReader(mountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mounts) != 5 {
+		t.Fatalf("Expected 5 mounts, but got %d", len(mounts))
+	}
+
+	root := mounts[0]
+	if root.Path != "/" || root.FSType != "ext4" || root.Source != "/dev/sda1" || root.Major != 8 || root.Minor != 1 {
+		t.Errorf("Unexpected root mount %+v", root)
+	}
+
+	if mounts[1].FSType != "proc" {
+		t.Errorf("Expected proc, but got %s", mounts[1].FSType)
+	}
+
+	if mounts[4].Path != "/mnt/my disk" {
+		t.Errorf("Expected escaped path to be decoded, but got %q", mounts[4].Path)
+	}
+}
+
+func TestParseMountInfoInvalid(t *testing.T) {
+	_, err := indexing.ParseMountInfo(strings.NewReader("22 1 8:1 / / rw\n"))
+	if err == nil {
+		t.Error("Expected an error for a line without separator")
+	}
+}
+
+const nestedMountInfo = `22 1 8:1 / / rw,relatime shared:1 - overlay overlay rw
+23 22 0:22 / /proc rw,nosuid,nodev,noexec,relatime shared:5 - proc proc rw
+24 22 0:23 / /run rw,nosuid,nodev shared:6 - tmpfs tmpfs rw
+25 24 8:2 / /run/media/usb rw,relatime shared:30 - vfat /dev/sdc1 rw
+26 22 8:3 / /home rw,relatime - ext4 /dev/sda2 rw
+27 26 8:4 / /home/user/data rw,relatime - xfs /dev/sdb1 rw
+28 22 8:3 / /home rw,relatime - ext4 /dev/sda2 rw
+29 22 0:24 / /var/lib/docker/overlay2/abc/merged rw - overlay overlay rw
+`
+
+func TestMountRoots(t *testing.T) {
+	mounts, err := indexing.ParseMountInfo(strings.NewReader(nestedMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := indexing.DefaultOptions().Linux
+
+	roots, skip := indexing.MountRoots(mounts, opts)
+	if want := "[/ /run/media/usb /home /home/user/data]"; fmt.Sprint(roots) != want {
+		t.Errorf("Expected roots %s, got %v", want, roots)
+	}
+	for _, path := range []string{"/proc", "/run", "/var/lib/docker/overlay2/abc/merged"} {
+		if _, ok := skip[path]; !ok {
+			t.Errorf("Expected %s to be skipped", path)
+		}
+	}
+	if len(skip) != 3 {
+		t.Errorf("Expected only the pseudo file systems to be skipped, got %v", skip)
+	}
+
+	// Overlays are indexed once they are not ignored
+	opts.IgnoreFilesystems = []string{"proc", "tmpfs"}
+	roots, _ = indexing.MountRoots(mounts, opts)
+	if len(roots) != 5 || roots[4] != "/var/lib/docker/overlay2/abc/merged" {
+		t.Errorf("Expected the overlay to be a root, got %v", roots)
+	}
+
+	// Crossing devices the crawl of / reaches every other mount
+	opts.IgnoreFilesystems = []string{"proc"}
+	opts.CrossDevices = true
+	roots, skip = indexing.MountRoots(mounts, opts)
+	if fmt.Sprint(roots) != "[/]" {
+		t.Errorf("Expected only /, got %v", roots)
+	}
+	if _, ok := skip["/proc"]; !ok {
+		t.Error("Expected /proc to be skipped when crossing devices")
+	}
+
+	// Except for the mounts below a skipped tmpfs, which the crawl of / stays out of
+	opts.IgnoreFilesystems = indexing.DefaultIgnoredFilesystems
+	roots, _ = indexing.MountRoots(mounts, opts)
+	if want := "[/ /run/media/usb]"; fmt.Sprint(roots) != want {
+		t.Errorf("Expected roots %s, got %v", want, roots)
+	}
+}
@@ -0,0 +1,82 @@
+package indexing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// Page is one page of search results
+type Page struct {
+	Files []File
+	Next  string // Cursor of the page after this one, empty on the last page
+}
+
+// cursor is the last result of a page, the next page starts after it. It has what the
+// results are sorted by and remembers the search it came from so it isn't used with another.
+type cursor struct {
+	Search   uint32 `json:"q"`
+	Score    int    `json:"s"`
+	Path     string `json:"p"`
+	Name     string `json:"n,omitempty"`
+	Ext      string `json:"e,omitempty"`
+	Size     int64  `json:"z,omitempty"`
+	Modified int64  `json:"m,omitempty"` // Unix nanoseconds
+}
+
+// file returns the last result of the page with what it was sorted by
+func (c *cursor) file() File {
+	f := File{FullPath: c.Path, Name: c.Name, Extension: c.Ext, Size: c.Size}
+	if c.Modified != 0 {
+		f.ModTime = time.Unix(0, c.Modified)
+	}
+	f.Internal_metadata.Score = c.Score
+	return f
+}
+
+// searchID tells searches apart, a cursor only works with the search it came from
+func searchID(q string, opts SearchOptions) uint32 {
+	h := fnv.New32a()
+	for _, n := range []int{int(opts.Mode), int(opts.Target), int(opts.Sort)} {
+		h.Write([]byte(strconv.Itoa(n) + "\x00"))
+	}
+	h.Write([]byte(strconv.FormatBool(opts.Reverse) + "\x00" + opts.Profile + "\x00" + q))
+	return h.Sum32()
+}
+
+func encodeCursor(last File, q string, opts SearchOptions) string {
+	c := cursor{
+		Search: searchID(q, opts),
+		Score:  last.Internal_metadata.Score,
+		Path:   last.FullPath,
+		Name:   last.Name,
+		Ext:    last.Extension,
+		Size:   last.Size,
+	}
+	if !last.ModTime.IsZero() {
+		c.Modified = last.ModTime.UnixNano()
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the cursor of a search, or nil for the first page
+func decodeCursor(s string, q string, opts SearchOptions) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Search != searchID(q, opts) {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,124 @@
+package indexing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TechMDW/indexing/internal/ignore"
+)
+
+// SearchMode is how the query of a search is read
+type SearchMode int
+
+const (
+	ModeQuery SearchMode = iota // Words, phrases and filters, see the query package
+	ModeGlob                    // A glob like *.tar.gz or **/testdata/*.json
+	ModeRegex                   // An RE2 regular expression
+)
+
+func (m SearchMode) String() string {
+	switch m {
+	case ModeGlob:
+		return "glob"
+	case ModeRegex:
+		return "regex"
+	}
+	return "query"
+}
+
+// SplitSearchMode picks the mode from a prefix like "glob:" or "regex:" in front of a query
+// typed by a user and returns the rest of it. Without a prefix it is ModeQuery.
+func SplitSearchMode(s string) (SearchMode, string) {
+	name, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return ModeQuery, s
+	}
+
+	switch strings.ToLower(name) {
+	case "glob":
+		return ModeGlob, rest
+	case "regex", "re":
+		return ModeRegex, rest
+	}
+	return ModeQuery, s
+}
+
+// SearchTarget is what a glob or regex is matched against
+type SearchTarget int
+
+const (
+	TargetAuto SearchTarget = iota // The full path if the pattern has a "/" in it, otherwise the name
+	TargetName
+	TargetPath
+)
+
+// SearchOptions changes how Search reads the query
+type SearchOptions struct {
+	Mode    SearchMode
+	Target  SearchTarget // Only used by ModeGlob and ModeRegex
+	Offset  int          // Results skipped before the page starts
+	Limit   int          // Results on a page, MaxResults of the options when 0
+	Cursor  string       // Next of the page before, the page starts after its last result
+	Sort    SortKey      // What the results are ordered by, a sort: filter in the query wins
+	Reverse bool         // Sort the other way around than the key does
+	Profile string       // Scoring profile from the options, the default one when empty
+}
+
+// PatternScore is the score data of a file matched by a glob or regex
+type PatternScore struct {
+	Length    int   // How much of the name or path the match covers
+	Positions []int // Matched characters of the name in runes, when matching the name
+	Path      bool  // Matched against the full path instead of the name
+}
+
+// compilePattern compiles the glob or regex of a search once and returns what matches
+// files with it
+func compilePattern(q string, opts SearchOptions) (func(File) (bool, int, []interface{}), error) {
+	var re *regexp.Regexp
+	var err error
+
+	switch opts.Mode {
+	case ModeGlob:
+		re, err = ignore.Glob(q)
+	case ModeRegex:
+		re, err = regexp.Compile(q)
+	default:
+		return nil, fmt.Errorf("%s is not a pattern mode", opts.Mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", opts.Mode, q, err)
+	}
+
+	onPath := opts.Target == TargetPath || (opts.Target == TargetAuto && strings.Contains(q, "/"))
+
+	return func(file File) (bool, int, []interface{}) {
+		target := file.Name
+		if onPath {
+			target = file.FullPath
+		}
+
+		loc := re.FindStringIndex(target)
+		if loc == nil {
+			return false, 0, nil
+		}
+
+		// Shorter names and paths that the match covers more of rank first
+		score := PatternScore{Path: onPath}
+		if len(target) > 0 {
+			score.Length = (loc[1] - loc[0]) * 10 / len(target)
+		}
+
+		if !onPath {
+			start := utf8.RuneCountInString(target[:loc[0]])
+			n := utf8.RuneCountInString(target[loc[0]:loc[1]])
+			score.Positions = make([]int, n)
+			for j := range score.Positions {
+				score.Positions[j] = start + j
+			}
+		}
+
+		return true, 1 + score.Length, []interface{}{score}
+	}, nil
+}
@@ -0,0 +1,194 @@
+package indexing
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScoringProfile weighs the rules of a search differently and boosts or demotes results by
+// their path. Rules and fields without a weight weigh 1, see Rule for the rules.
+type ScoringProfile struct {
+	Rules  map[string]float64 `json:"rules"`  // Weight of the points of a rule, like "fuzzy": 0.5
+	Fields map[string]float64 `json:"fields"` // Weight of the points of the rules that look at name, path, hash or permissions
+	Paths  []PathBoost        `json:"paths"`  // Points for results with a path, every one that matches adds up
+}
+
+// PathBoost adds points to the results with a path, negative points demote them
+type PathBoost struct {
+	Path   string `json:"path"` // Absolute like "~/projects" for everything below it, otherwise part of the path like "node_modules"
+	Points int    `json:"points"`
+}
+
+// The rules and fields a profile can weigh
+var (
+	ruleNames  = []string{"contains", "exact", "start", "word", "length", "extension", "hash", "fuzzy", "pattern"}
+	fieldNames = []string{"name", "path", "hash", "permissions"}
+)
+
+// profile is a ScoringProfile ready to score with, the paths of its boosts are expanded
+type profile struct {
+	name   string
+	rules  map[string]float64
+	fields map[string]float64
+	paths  []PathBoost
+}
+
+// validate checks the rules, fields and paths of a profile, errors start with the key
+func (sp ScoringProfile) validate(key string) error {
+	names := func(m map[string]float64) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	for _, name := range names(sp.Rules) {
+		if !contains(ruleNames, name) {
+			return fmt.Errorf("%s.rules.%s: unknown rule, one of %s", key, name, strings.Join(ruleNames, ", "))
+		}
+		if w := sp.Rules[name]; w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("%s.rules.%s: must not be negative", key, name)
+		}
+	}
+
+	for _, name := range names(sp.Fields) {
+		if !contains(fieldNames, name) {
+			return fmt.Errorf("%s.fields.%s: unknown field, one of %s", key, name, strings.Join(fieldNames, ", "))
+		}
+		if w := sp.Fields[name]; w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("%s.fields.%s: must not be negative", key, name)
+		}
+	}
+
+	for n, b := range sp.Paths {
+		if strings.TrimSpace(b.Path) == "" {
+			return fmt.Errorf("%s.paths[%d].path: must not be empty", key, n)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// compileProfiles gets the profiles of the options ready to score with
+func compileProfiles(profiles map[string]ScoringProfile) (map[string]*profile, error) {
+	compiled := make(map[string]*profile, len(profiles))
+	for name, sp := range profiles {
+		p := &profile{name: name, rules: sp.Rules, fields: sp.Fields}
+
+		for _, b := range sp.Paths {
+			path := filepath.ToSlash(strings.TrimSpace(b.Path))
+			if path == "~" || strings.HasPrefix(path, "~/") {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return nil, fmt.Errorf("profiles.%s: %w", name, err)
+				}
+				path = filepath.ToSlash(home) + path[1:]
+			}
+			if len(path) > 1 {
+				path = strings.TrimSuffix(path, "/")
+			}
+
+			p.paths = append(p.paths, PathBoost{Path: path, Points: b.Points})
+		}
+
+		compiled[name] = p
+	}
+
+	return compiled, nil
+}
+
+// profile returns the scoring profile a search picks, or the default one of the options.
+// It is nil when there is none, then every rule weighs 1.
+func (i *Index) profile(name string) (*profile, error) {
+	if name == "" {
+		name = i.opts.Profile
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	p, ok := i.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scoring profile %q", name)
+	}
+	return p, nil
+}
+
+// weigh sets the weight of the rules and weighs their points
+func (p *profile) weigh(rules []Rule) {
+	for n := range rules {
+		w := 1.0
+		if p != nil {
+			if v, ok := p.rules[rules[n].Name]; ok {
+				w *= v
+			}
+			if v, ok := p.fields[rules[n].Field]; ok {
+				w *= v
+			}
+		}
+
+		rules[n].Weight = w
+		rules[n].Points = int(math.Round(float64(rules[n].Points) * w))
+	}
+}
+
+// boosts returns the boosts of the profile for a path
+func (p *profile) boosts(path string) []PathBoost {
+	if p == nil {
+		return nil
+	}
+
+	var boosts []PathBoost
+	for _, b := range p.paths {
+		if matchPath(path, b.Path) {
+			boosts = append(boosts, b)
+		}
+	}
+	return boosts
+}
+
+// ranked turns what a word, filter or pattern matched into the score it is ranked by: the
+// points of the rules weighed by the profile, at least 1 so a query of only filters still
+// ranks what it matched, and then the boosts of the path. Demoted results can go below 1.
+func ranked(match func(File) (bool, int, []interface{}), p *profile) func(File) (bool, int, []interface{}) {
+	return func(file File) (bool, int, []interface{}) {
+		matched, score, data := match(file)
+		if !matched {
+			return false, 0, nil
+		}
+
+		if p != nil && (len(p.rules) > 0 || len(p.fields) > 0) {
+			score = 0
+			for _, d := range data {
+				rules := scoreRules(d)
+				p.weigh(rules)
+				score += sumRules(rules)
+			}
+		}
+
+		if score < 1 {
+			score = 1
+		}
+
+		for _, b := range p.boosts(file.FullPath) {
+			score += b.Points
+		}
+
+		return true, score, data
+	}
+}
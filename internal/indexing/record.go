@@ -0,0 +1,590 @@
+package indexing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/attributes"
+	"github.com/TechMDW/indexing/internal/hash"
+)
+
+// Since version 4 the entries of the index file are binary records instead of NDJSON. Every
+// record starts with a uvarint of flags saying which of the optional fields follow:
+//
+//	flags       uvarint
+//	path        string ref
+//	name        string
+//	key         string      if recordKey, otherwise the full path
+//	fullPath    string      if recordFullPath, otherwise path joined with name
+//	extension   string ref  if recordExtension, otherwise the extension of name
+//	pathInfo    string refs if recordPathInfo, otherwise derived from the full path
+//	size        varint
+//	created     varint      if recordCreated, unix nanoseconds
+//	modified    varint      if recordModified, unix nanoseconds
+//	accessed    varint      if recordAccessed, unix nanoseconds
+//	permission  uvarint
+//	owner       string ref  if recordOwner, the group and other follow
+//	stat        uvarints    if recordStat, the inode, device, links, uid and gid
+//	attributes  uvarint     Windows attributes, one bit each
+//	hashes      ...         if recordHash, see recordWriter.hash
+//	error       string ref  if recordError
+//
+// A string is a uvarint length followed by the bytes. A string ref is a uvarint into the
+// string table that is built up while reading, 0 means a new string follows that gets the
+// next number. Directories and other values that repeat a lot are only written once.
+const (
+	recordKey = 1 << iota
+	recordFullPath
+	recordExtension
+	recordPathInfo
+	recordHidden
+	recordDir
+	recordOneDrive
+	recordCreated
+	recordModified
+	recordAccessed
+	recordOwner
+	recordHash
+	recordError
+	recordStat
+)
+
+// Strings longer than this are treated as corruption
+const maxStringSize = 1 << 20
+
+var errStringTooLong = errors.New("string too long")
+
+// recordWriter writes the binary records of the index file
+type recordWriter struct {
+	w       *bufio.Writer
+	strings map[string]uint64
+	buf     [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func newRecordWriter(w io.Writer) *recordWriter {
+	return &recordWriter{
+		w:       bufio.NewWriter(w),
+		strings: make(map[string]uint64),
+	}
+}
+
+func (rw *recordWriter) write(key string, f File) error {
+	var flags uint64
+
+	if key != f.FullPath {
+		flags |= recordKey
+	}
+	if f.FullPath != joinPath(f.Path, f.Name) {
+		flags |= recordFullPath
+	}
+	if f.Extension != filepath.Ext(f.Name) {
+		flags |= recordExtension
+	}
+	if f.PathInfo != derivePathInfo(f.FullPath) {
+		flags |= recordPathInfo
+	}
+	if f.IsHidden {
+		flags |= recordHidden
+	}
+	if f.IsDir {
+		flags |= recordDir
+	}
+	if f.IsOneDrivePlaceholder {
+		flags |= recordOneDrive
+	}
+	if !f.CreatedTime.IsZero() {
+		flags |= recordCreated
+	}
+	if !f.ModTime.IsZero() {
+		flags |= recordModified
+	}
+	if !f.AccessedTime.IsZero() {
+		flags |= recordAccessed
+	}
+	if f.Permissions.Owner != "" || f.Permissions.Group != "" || f.Permissions.Other != "" {
+		flags |= recordOwner
+	}
+	if f.Hash != (hash.Hash{}) {
+		flags |= recordHash
+	}
+	if f.Error != "" {
+		flags |= recordError
+	}
+	if f.Links != 0 {
+		flags |= recordStat
+	}
+
+	rw.uvarint(flags)
+	rw.ref(f.Path)
+	rw.string(f.Name)
+
+	if flags&recordKey != 0 {
+		rw.string(key)
+	}
+	if flags&recordFullPath != 0 {
+		rw.string(f.FullPath)
+	}
+	if flags&recordExtension != 0 {
+		rw.ref(f.Extension)
+	}
+	if flags&recordPathInfo != 0 {
+		p := f.PathInfo
+		for _, s := range []string{p.Abs, p.Base, p.Clean, p.Dir, p.Ext, p.EvalSymlinks, p.VolumeName, p.Separator} {
+			rw.ref(s)
+		}
+		rw.bool(p.IsAbs)
+	}
+
+	rw.varint(f.Size)
+
+	if flags&recordCreated != 0 {
+		rw.varint(f.CreatedTime.UnixNano())
+	}
+	if flags&recordModified != 0 {
+		rw.varint(f.ModTime.UnixNano())
+	}
+	if flags&recordAccessed != 0 {
+		rw.varint(f.AccessedTime.UnixNano())
+	}
+
+	rw.uvarint(uint64(f.Permissions.Permission))
+
+	if flags&recordOwner != 0 {
+		rw.ref(f.Permissions.Owner)
+		rw.ref(f.Permissions.Group)
+		rw.ref(f.Permissions.Other)
+	}
+	if flags&recordStat != 0 {
+		for _, v := range []uint64{f.Inode, f.Device, f.Links, uint64(f.Permissions.UID), uint64(f.Permissions.GID)} {
+			rw.uvarint(v)
+		}
+	}
+
+	rw.uvarint(attributeBits(f.WindowsAttributes))
+
+	if flags&recordHash != 0 {
+		rw.hash(f.Hash)
+	}
+	if flags&recordError != 0 {
+		rw.ref(f.Error)
+	}
+
+	return rw.err
+}
+
+func (rw *recordWriter) flush() error {
+	if rw.err != nil {
+		return rw.err
+	}
+	return rw.w.Flush()
+}
+
+func (rw *recordWriter) uvarint(v uint64) {
+	if rw.err != nil {
+		return
+	}
+	n := binary.PutUvarint(rw.buf[:], v)
+	_, rw.err = rw.w.Write(rw.buf[:n])
+}
+
+func (rw *recordWriter) varint(v int64) {
+	if rw.err != nil {
+		return
+	}
+	n := binary.PutVarint(rw.buf[:], v)
+	_, rw.err = rw.w.Write(rw.buf[:n])
+}
+
+func (rw *recordWriter) bool(b bool) {
+	if b {
+		rw.uvarint(1)
+	} else {
+		rw.uvarint(0)
+	}
+}
+
+func (rw *recordWriter) bytes(b []byte) {
+	rw.uvarint(uint64(len(b)))
+	if rw.err != nil {
+		return
+	}
+	_, rw.err = rw.w.Write(b)
+}
+
+func (rw *recordWriter) string(s string) {
+	rw.uvarint(uint64(len(s)))
+	if rw.err != nil {
+		return
+	}
+	_, rw.err = rw.w.WriteString(s)
+}
+
+// ref writes a reference into the string table, adding s if it is not in there yet
+func (rw *recordWriter) ref(s string) {
+	if id, ok := rw.strings[s]; ok {
+		rw.uvarint(id)
+		return
+	}
+
+	rw.strings[s] = uint64(len(rw.strings) + 1)
+	rw.uvarint(0)
+	rw.string(s)
+}
+
+// hash writes which hashes are set, which of them are not plain hex, and then the hashes.
+// Hex is written as the bytes it encodes.
+func (rw *recordWriter) hash(h hash.Hash) {
+	fields := hashFields(&h)
+
+	var set, raw uint64
+	for n, field := range fields {
+		if *field == "" {
+			continue
+		}
+		set |= 1 << n
+		if !isHex(*field) {
+			raw |= 1 << n
+		}
+	}
+
+	rw.uvarint(set)
+	rw.uvarint(raw)
+
+	for n, field := range fields {
+		switch {
+		case set&(1<<n) == 0:
+		case raw&(1<<n) != 0:
+			rw.string(*field)
+		default:
+			b, _ := hex.DecodeString(*field)
+			rw.bytes(b)
+		}
+	}
+}
+
+// recordReader reads the records written by recordWriter
+type recordReader struct {
+	r       *bufio.Reader
+	strings []string
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{
+		r:       bufio.NewReader(r),
+		strings: []string{""},
+	}
+}
+
+// read returns the next record, io.EOF once there are none left
+func (rr *recordReader) read() (key string, f File, err error) {
+	flags, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return "", f, err
+	}
+
+	// Only the start of a record may end the file
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if f.Path, err = rr.ref(); err != nil {
+		return "", f, err
+	}
+	if f.Name, err = rr.string(); err != nil {
+		return "", f, err
+	}
+
+	f.FullPath = joinPath(f.Path, f.Name)
+	f.Extension = filepath.Ext(f.Name)
+
+	if flags&recordKey != 0 {
+		if key, err = rr.string(); err != nil {
+			return "", f, err
+		}
+	}
+	if flags&recordFullPath != 0 {
+		if f.FullPath, err = rr.string(); err != nil {
+			return "", f, err
+		}
+	}
+	if flags&recordKey == 0 {
+		key = f.FullPath
+	}
+	if flags&recordExtension != 0 {
+		if f.Extension, err = rr.ref(); err != nil {
+			return "", f, err
+		}
+	}
+
+	if flags&recordPathInfo != 0 {
+		p := &f.PathInfo
+		for _, s := range []*string{&p.Abs, &p.Base, &p.Clean, &p.Dir, &p.Ext, &p.EvalSymlinks, &p.VolumeName, &p.Separator} {
+			if *s, err = rr.ref(); err != nil {
+				return "", f, err
+			}
+		}
+		if p.IsAbs, err = rr.bool(); err != nil {
+			return "", f, err
+		}
+	} else {
+		f.PathInfo = derivePathInfo(f.FullPath)
+	}
+
+	f.IsHidden = flags&recordHidden != 0
+	f.IsDir = flags&recordDir != 0
+	f.IsOneDrivePlaceholder = flags&recordOneDrive != 0
+
+	if f.Size, err = binary.ReadVarint(rr.r); err != nil {
+		return "", f, err
+	}
+
+	times := []struct {
+		flag uint64
+		t    *time.Time
+	}{
+		{recordCreated, &f.CreatedTime},
+		{recordModified, &f.ModTime},
+		{recordAccessed, &f.AccessedTime},
+	}
+	for _, t := range times {
+		if flags&t.flag == 0 {
+			continue
+		}
+		ns, err := binary.ReadVarint(rr.r)
+		if err != nil {
+			return "", f, err
+		}
+		*t.t = time.Unix(0, ns)
+	}
+
+	perm, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return "", f, err
+	}
+	f.Permissions.Permission = fs.FileMode(perm)
+
+	if flags&recordOwner != 0 {
+		for _, s := range []*string{&f.Permissions.Owner, &f.Permissions.Group, &f.Permissions.Other} {
+			if *s, err = rr.ref(); err != nil {
+				return "", f, err
+			}
+		}
+	}
+
+	if flags&recordStat != 0 {
+		var stat [5]uint64
+		for n := range stat {
+			if stat[n], err = binary.ReadUvarint(rr.r); err != nil {
+				return "", f, err
+			}
+		}
+		f.Inode, f.Device, f.Links = stat[0], stat[1], stat[2]
+		f.Permissions.UID, f.Permissions.GID = uint32(stat[3]), uint32(stat[4])
+	}
+
+	bits, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return "", f, err
+	}
+	f.WindowsAttributes = attributesFromBits(bits)
+
+	if flags&recordHash != 0 {
+		if f.Hash, err = rr.hash(); err != nil {
+			return "", f, err
+		}
+	}
+
+	if flags&recordError != 0 {
+		if f.Error, err = rr.ref(); err != nil {
+			return "", f, err
+		}
+	}
+
+	return key, f, nil
+}
+
+func (rr *recordReader) bool() (bool, error) {
+	v, err := binary.ReadUvarint(rr.r)
+	return v != 0, err
+}
+
+func (rr *recordReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxStringSize {
+		return nil, errStringTooLong
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (rr *recordReader) string() (string, error) {
+	b, err := rr.bytes()
+	return string(b), err
+}
+
+func (rr *recordReader) ref() (string, error) {
+	id, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return "", err
+	}
+
+	if id == 0 {
+		s, err := rr.string()
+		if err != nil {
+			return "", err
+		}
+		rr.strings = append(rr.strings, s)
+		return s, nil
+	}
+
+	if id >= uint64(len(rr.strings)) {
+		return "", errors.New("unknown string reference")
+	}
+
+	return rr.strings[id], nil
+}
+
+func (rr *recordReader) hash() (hash.Hash, error) {
+	var h hash.Hash
+
+	set, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return h, err
+	}
+
+	raw, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return h, err
+	}
+
+	for n, field := range hashFields(&h) {
+		if set&(1<<n) == 0 {
+			continue
+		}
+
+		b, err := rr.bytes()
+		if err != nil {
+			return h, err
+		}
+
+		if raw&(1<<n) != 0 {
+			*field = string(b)
+		} else {
+			*field = hex.EncodeToString(b)
+		}
+	}
+
+	return h, nil
+}
+
+// hashFields returns every hash in a fixed order, new ones must be added at the end
+func hashFields(h *hash.Hash) []*string {
+	return []*string{
+		&h.MD5,
+		&h.SHA1,
+		&h.SHA2.SHA224,
+		&h.SHA2.SHA256,
+		&h.SHA2.SHA384,
+		&h.SHA2.SHA512,
+		&h.SHA2.SHA512_224,
+		&h.SHA2.SHA512_256,
+		&h.SHA3.SHA256,
+		&h.SHA3.SHA512,
+		&h.CRC.CRC32,
+		&h.CRC.CRC64,
+		&h.Blake.Blake2b.Blake256,
+		&h.Blake.Blake2b.Blake384,
+		&h.Blake.Blake2b.Blake512,
+		&h.Blake.Blake2s.Blake256,
+	}
+}
+
+// isHex reports whether s is lower case hex that survives a round trip through bytes
+func isHex(s string) bool {
+	if len(s)%2 != 0 {
+		return false
+	}
+	for n := 0; n < len(s); n++ {
+		c := s[n]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeFields returns every Windows attribute in a fixed order, new ones must be added at the end
+func attributeFields(a *attributes.WindowsAttributes) []*bool {
+	return []*bool{
+		&a.ReadOnly,
+		&a.Hidden,
+		&a.System,
+		&a.Directory,
+		&a.Archive,
+		&a.Normal,
+		&a.Temporary,
+		&a.Offline,
+		&a.NotContentIndexed,
+		&a.Encrypted,
+		&a.OneDrive,
+	}
+}
+
+func attributeBits(a attributes.WindowsAttributes) uint64 {
+	var bits uint64
+	for n, field := range attributeFields(&a) {
+		if *field {
+			bits |= 1 << n
+		}
+	}
+	return bits
+}
+
+func attributesFromBits(bits uint64) attributes.WindowsAttributes {
+	var a attributes.WindowsAttributes
+	for n, field := range attributeFields(&a) {
+		*field = bits&(1<<n) != 0
+	}
+	return a
+}
+
+// derivePathInfo is the PathInfo a file at path usually has. It only looks at the path, so
+// it is cheap enough to run for every file that is loaded.
+func derivePathInfo(path string) PathInfo {
+	clean := filepath.Clean(path)
+
+	abs := clean
+	if !filepath.IsAbs(path) {
+		abs, _ = filepath.Abs(path)
+	}
+
+	return PathInfo{
+		Abs:          abs,
+		Base:         filepath.Base(path),
+		Clean:        clean,
+		Dir:          filepath.Dir(path),
+		Ext:          filepath.Ext(path),
+		EvalSymlinks: clean,
+		IsAbs:        filepath.IsAbs(path),
+		VolumeName:   filepath.VolumeName(path),
+		Separator:    string(os.PathSeparator),
+	}
+}
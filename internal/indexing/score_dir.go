@@ -5,15 +5,33 @@ import (
 	"strings"
 )
 
+// DirScore is the points a word of a query got for a directory, by the field and the rule that
+// gave them
 type DirScore struct {
-	Contains    int
-	Exact       int
-	Start       int
-	Word        int
-	Length      int
-	Permissions int
+	Path        FieldScore // The path of the directory it is in
+	Name        FieldScore // Only Contains, when the word isn't in the path
+	Permissions FieldScore // Only for words that look like permissions
+	Fuzzy       int        // The word is in the name fuzzily, only when it isn't in the path or name
+	Positions   []int      // Matched characters of the name in runes, to highlight them
 }
 
+// FieldScore is the points a word got in one field of a directory
+type FieldScore struct {
+	Contains int // The word is in the field
+	Exact    int // With the same case
+	Start    int // At the start of the field
+	Word     int // In a directory of the path, or as a word of the permissions
+	Length   int // How many directories of the path the word matches, for words starting with "/"
+}
+
+// Total adds up the points of the rules
+func (s FieldScore) Total() int {
+	return s.Contains + s.Exact + s.Start + s.Word + s.Length
+}
+
+// A directory in a path
+var pathWord = regexp.MustCompile(`[\/\\](\w+)([\/\\]|$)`)
+
 func ScoreDir(file File, query string) (int, DirScore) {
 	var score DirScore
 
@@ -22,24 +40,23 @@ func ScoreDir(file File, query string) (int, DirScore) {
 	lPermissions := strings.ToLower(file.Permissions.Permission.String())
 
 	if strings.Contains(lPath, lQuery) {
-		score.Contains += 2
+		score.Path.Contains += 2
 
 		// If the query matches the path (case sensitive)
 		if strings.Contains(file.Path, query) {
-			score.Exact += 2
+			score.Path.Exact += 2
 		}
 
 		// If the query is at the start of the file name
 		if strings.Index(file.Path, query) == 0 {
-			score.Start += 2
+			score.Path.Start += 2
 		}
 
-		re := regexp.MustCompile(`[\/\\](\w+)([\/\\]|$)`)
-		matches := re.FindAllStringSubmatch(file.Path, -1)
+		matches := pathWord.FindAllStringSubmatch(file.Path, -1)
 
 		for _, match := range matches {
 			if strings.Contains(match[1], query) {
-				score.Word += 3
+				score.Path.Word += 3
 			}
 		}
 
@@ -55,36 +72,48 @@ func ScoreDir(file File, query string) (int, DirScore) {
 				}
 
 				if path == queryPaths[i] {
-					score.Length += 2
+					score.Path.Length += 2
 				}
 
 				if strings.Contains(path, queryPaths[i]) {
-					score.Length += 1
+					score.Path.Length += 1
 				}
 			}
 		}
 	}
 
-	if strings.Contains(lPermissions, lQuery) {
-		score.Contains += 2
+	// The name of the directory itself, Path is the directory it is in
+	lName := strings.ToLower(file.Name)
+	if strings.Contains(lName, lQuery) {
+		if score.Path.Contains == 0 {
+			score.Name.Contains += 2
+		}
+		score.Positions = containsPositions(lName, lQuery)
+	} else if score.Path.Contains == 0 {
+		score.Fuzzy, score.Positions = ScoreFuzzy(file.Name, query)
+	}
+
+	// Like the candidates of a search, only words that look like permissions
+	if isPermissionText(query) && strings.Contains(lPermissions, lQuery) {
+		score.Permissions.Contains += 2
 
 		// If the query matches the permissions exactly (case sensitive)
 		if strings.Contains(file.Permissions.Permission.String(), query) {
-			score.Exact += 2
+			score.Permissions.Exact += 2
 		}
 
 		// If the query is at the start of the file name
 		if strings.Index(file.Permissions.Permission.String(), query) == 0 {
-			score.Start += 2
+			score.Permissions.Start += 2
 		}
 
 		// If the query is a word in the file name
 		for _, word := range strings.Split(file.Permissions.Permission.String(), " ") {
 			if word == query {
-				score.Word += 3
+				score.Permissions.Word += 3
 			}
 		}
 	}
 
-	return score.Contains + score.Exact + score.Start + score.Word + score.Length, score
+	return score.Path.Total() + score.Name.Total() + score.Permissions.Total() + score.Fuzzy, score
 }
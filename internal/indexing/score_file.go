@@ -5,15 +5,19 @@ import (
 	"strings"
 )
 
+// FileScore is the points a word of a query got for a file, by the rule that gave them. It
+// is in Internal_metadata.Score_data of the results, Explain lists it as Rules.
 type FileScore struct {
-	Contains   int
-	Exact      int
-	Start      int
-	Word       int
-	Length     int
-	Extension  int
-	Hash       int
-	Permission int
+	Contains   int   // The word is in the name
+	Exact      int   // With the same case
+	Start      int   // At the start of the name
+	Word       int   // As a word of the name
+	Length     int   // How much of the name the word covers
+	Extension  int   // The word is the extension
+	Hash       int   // The word is part of a digest
+	Permission int   // The word is part of the permissions, not part of the score
+	Fuzzy      int   // The word is in the name fuzzily, only when it isn't in it as it is
+	Positions  []int // Matched characters of the name in runes, to highlight them
 }
 
 func ScoreFile(file File, query string) (int, FileScore) {
@@ -25,6 +29,7 @@ func ScoreFile(file File, query string) (int, FileScore) {
 
 	if strings.Contains(lName, lQuery) {
 		score.Contains += 2
+		score.Positions = containsPositions(lName, lQuery)
 
 		// If the query matches the permissions exactly (case sensitive)
 		if strings.Contains(file.Name, query) {
@@ -65,6 +70,8 @@ func ScoreFile(file File, query string) (int, FileScore) {
 				score.Extension += 2
 			}
 		}
+	} else {
+		score.Fuzzy, score.Positions = ScoreFuzzy(file.Name, query)
 	}
 
 	if strings.Contains(lPermissions, lQuery) {
@@ -89,7 +96,8 @@ func ScoreFile(file File, query string) (int, FileScore) {
 	}
 
 	hashScore := 2
-	if !file.IsDir && (strings.Contains(file.Hash.MD5, query) ||
+	// Like the candidates of a search, short hex is too common in digests to count
+	if !file.IsDir && isHashText(query) && (strings.Contains(file.Hash.MD5, query) ||
 		strings.Contains(file.Hash.SHA1, query) ||
 		strings.Contains(file.Hash.SHA2.SHA256, query) ||
 		strings.Contains(file.Hash.SHA2.SHA512, query) ||
@@ -100,5 +108,5 @@ func ScoreFile(file File, query string) (int, FileScore) {
 		score.Hash += hashScore
 	}
 
-	return score.Contains + score.Exact + score.Start + score.Word + score.Length + score.Extension + score.Hash, score
+	return score.Contains + score.Exact + score.Start + score.Word + score.Length + score.Extension + score.Hash + score.Fuzzy, score
 }
@@ -0,0 +1,47 @@
+package indexing
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TechMDW/indexing/internal/fuzzy"
+)
+
+// ScoreFuzzy scores a name that doesn't contain the query as it is, either because the
+// characters of the query are spread out in it or because of a typo. It returns 0 when the
+// name doesn't match at all, and the positions of the matched characters in runes.
+func ScoreFuzzy(name, query string) (int, []int) {
+	best := 0
+	var positions []int
+
+	// The characters in order, 1 to 3 for how close together and near the start of words they are
+	if s, pos, ok := fuzzy.Match(query, name); ok {
+		best = 1 + 2*s/fuzzy.MaxScore(utf8.RuneCountInString(query))
+		positions = pos
+	}
+
+	// A word that is a typo or two away, 2 for one typo and 1 for two
+	if d, pos, ok := fuzzy.MatchWord(query, name); ok && 3-d > best {
+		best = 3 - d
+		positions = pos
+	}
+
+	return best, positions
+}
+
+// containsPositions returns the positions in runes of where lName contains lQuery
+func containsPositions(lName, lQuery string) []int {
+	i := strings.Index(lName, lQuery)
+	if i < 0 {
+		return nil
+	}
+
+	start := utf8.RuneCountInString(lName[:i])
+	n := utf8.RuneCountInString(lQuery)
+
+	positions := make([]int, n)
+	for j := range positions {
+		positions[j] = start + j
+	}
+	return positions
+}
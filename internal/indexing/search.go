@@ -0,0 +1,289 @@
+package indexing
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/query"
+	"github.com/TechMDW/indexing/internal/trigram"
+)
+
+// matchQuery reports whether a file matches a parsed query and how well. Terms add up the
+// score of what they matched, filters only decide whether the file matches at all.
+func matchQuery(node query.Node, file File, im indexMatches) (bool, int, []interface{}) {
+	switch n := node.(type) {
+	case *query.And:
+		total := 0
+		var data []interface{}
+		for _, child := range n.Nodes {
+			ok, score, d := matchQuery(child, file, im)
+			if !ok {
+				return false, 0, nil
+			}
+			total += score
+			data = append(data, d...)
+		}
+		return true, total, data
+
+	case *query.Or:
+		matched := false
+		total := 0
+		var data []interface{}
+		for _, child := range n.Nodes {
+			ok, score, d := matchQuery(child, file, im)
+			if ok {
+				matched = true
+				total += score
+				data = append(data, d...)
+			}
+		}
+		return matched, total, data
+
+	case *query.Not:
+		ok, _, _ := matchQuery(n.Node, file, im)
+		return !ok, 0, nil
+
+	case *query.Term:
+		var score int
+		var data interface{}
+		if file.IsDir {
+			score, data = ScoreDir(file, n.Text)
+		} else {
+			score, data = ScoreFile(file, n.Text)
+		}
+		if score <= 0 {
+			return false, 0, nil
+		}
+		return true, score, []interface{}{data}
+
+	case *query.Field:
+		return matchField(n, file, im), 0, nil
+	}
+
+	return false, 0, nil
+}
+
+// candidateQuery returns the trigram query for the files that can match a parsed query. It
+// leaves out fuzzy matches, which the caller has to look for some other way.
+func candidateQuery(node query.Node, im indexMatches) *trigram.Query {
+	switch n := node.(type) {
+	case *query.And:
+		qs := make([]*trigram.Query, 0, len(n.Nodes))
+		for _, child := range n.Nodes {
+			qs = append(qs, candidateQuery(child, im))
+		}
+		return trigram.AndQuery(qs...)
+
+	case *query.Or:
+		qs := make([]*trigram.Query, 0, len(n.Nodes))
+		for _, child := range n.Nodes {
+			qs = append(qs, candidateQuery(child, im))
+		}
+		return trigram.OrQuery(qs...)
+
+	case *query.Term:
+		// Terms are also looked for in the permissions and hashes, which are not in the path
+		if isHashText(n.Text) || isPermissionText(n.Text) {
+			return trigram.AllQuery()
+		}
+		return trigram.TextQuery(n.Text)
+
+	case *query.Field:
+		switch n.Name {
+		case "name", "path":
+			return trigram.TextQuery(n.Value)
+		case "ext":
+			return trigram.TextQuery("." + strings.TrimPrefix(n.Value, "."))
+		case "content", "hash":
+			keys := make([]string, 0, len(im[n]))
+			for key := range im[n] {
+				keys = append(keys, key)
+			}
+			return trigram.KeysQuery(keys)
+		}
+	}
+
+	// Like NOT, which can match files without any of its text
+	return trigram.AllQuery()
+}
+
+// hasTerms reports whether a query has words that can match fuzzily
+func hasTerms(node query.Node) bool {
+	switch n := node.(type) {
+	case *query.And:
+		for _, child := range n.Nodes {
+			if hasTerms(child) {
+				return true
+			}
+		}
+	case *query.Or:
+		for _, child := range n.Nodes {
+			if hasTerms(child) {
+				return true
+			}
+		}
+	case *query.Term:
+		return true
+	}
+	return false
+}
+
+// isHashText reports whether text looks like the start of a hash. Shorter hex like "cafe" or
+// "2023" is more likely a word or a number and is only looked for in the path.
+func isHashText(text string) bool {
+	if len(text) < hash.MinPrefix {
+		return false
+	}
+	for n := 0; n < len(text); n++ {
+		c := text[n]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Permissions like drwxr-xr-x or rw-, the letters of the type and then up to three sets of read,
+// write and execute. Words made of the letters of the type, like "data", are not.
+var permissionText = regexp.MustCompile(`(?i)^[dalt\?psugc]*([-r][-w][-xst]){1,3}$`)
+
+// isPermissionText reports whether text looks like permissions
+func isPermissionText(text string) bool {
+	return permissionText.MatchString(text)
+}
+
+// matchField reports whether a file passes a field filter
+func matchField(f *query.Field, file File, im indexMatches) bool {
+	switch f.Name {
+	case "content", "hash":
+		_, ok := im[f][file.FullPath]
+		return ok
+	case "sort":
+		// Orders the results, every file passes it
+		return true
+	case "ext":
+		return strings.EqualFold(strings.TrimPrefix(file.Extension, "."), strings.TrimPrefix(f.Value, "."))
+	case "name":
+		return strings.Contains(strings.ToLower(file.Name), strings.ToLower(f.Value))
+	case "path":
+		return matchPath(file.FullPath, f.Value)
+	case "owner":
+		return strings.EqualFold(file.Permissions.Owner, f.Value)
+	case "group":
+		return strings.EqualFold(file.Permissions.Group, f.Value)
+	case "type":
+		return file.IsDir == f.Bool
+	case "hidden":
+		return file.IsHidden == f.Bool
+	case "size":
+		return f.Op.Compare(compareInt(file.Size, f.Size))
+	case "modified":
+		return matchTime(f, file.ModTime)
+	case "created":
+		return matchTime(f, file.CreatedTime)
+	case "accessed":
+		return matchTime(f, file.AccessedTime)
+	case "inode", "device", "links", "uid", "gid":
+		// Files the system said nothing about have none of them, not zero
+		if file.Links == 0 {
+			return false
+		}
+		return f.Op.Compare(compareUint(statNumber(file, f.Name), f.Number))
+	}
+
+	return false
+}
+
+// matchPath matches an absolute path like /srv or C:/Users against the directory and
+// everything below it, anything else against any part of the path
+func matchPath(path, value string) bool {
+	path = strings.ToLower(path)
+	value = strings.ToLower(value)
+
+	if !strings.HasPrefix(value, "/") && !(len(value) >= 3 && value[1] == ':' && value[2] == '/') {
+		return strings.Contains(path, value)
+	}
+
+	value = strings.TrimSuffix(value, "/")
+	return path == value || strings.HasPrefix(path, value+"/") || value == ""
+}
+
+func matchTime(f *query.Field, t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+
+	return f.Op.Compare(t.Compare(f.Time))
+}
+
+// statNumber returns the number of a file that a number field filters on
+func statNumber(file File, name string) uint64 {
+	switch name {
+	case "inode":
+		return file.Inode
+	case "device":
+		return file.Device
+	case "links":
+		return file.Links
+	case "uid":
+		return uint64(file.Permissions.UID)
+	case "gid":
+		return uint64(file.Permissions.GID)
+	}
+	return 0
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// matchedPositions merges the positions in the name that the terms of a query matched
+func matchedPositions(data []interface{}) []int {
+	seen := make(map[int]struct{})
+	for _, d := range data {
+		var positions []int
+		switch s := d.(type) {
+		case FileScore:
+			positions = s.Positions
+		case DirScore:
+			positions = s.Positions
+		case PatternScore:
+			positions = s.Positions
+		}
+
+		for _, p := range positions {
+			seen[p] = struct{}{}
+		}
+	}
+
+	if len(seen) == 0 {
+		return nil
+	}
+
+	positions := make([]int, 0, len(seen))
+	for p := range seen {
+		positions = append(positions, p)
+	}
+	sort.Ints(positions)
+
+	return positions
+}
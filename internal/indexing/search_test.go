@@ -0,0 +1,825 @@
+package indexing_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/extract"
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/indexing"
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+func newSearchIndex(t *testing.T) *indexing.Index {
+	t.Helper()
+
+	idx := newTestIndex(t)
+
+	now := time.Now()
+	files := []indexing.File{
+		{Name: "main.go", Extension: ".go", Path: "/srv/app", FullPath: "/srv/app/main.go", Size: 2048, ModTime: now.Add(-time.Hour)},
+		{Name: "old.go", Extension: ".go", Path: "/srv/app", FullPath: "/srv/app/old.go", Size: 100, ModTime: now.Add(-30 * 24 * time.Hour)},
+		{Name: "video.mp4", Extension: ".mp4", Path: "/home/alice", FullPath: "/home/alice/video.mp4", Size: 50 << 20, ModTime: now},
+		{Name: "annual report.pdf", Extension: ".pdf", Path: "/home/alice", FullPath: "/home/alice/annual report.pdf", Size: 4096},
+		{Name: ".bashrc", Extension: ".bashrc", Path: "/home/alice", FullPath: "/home/alice/.bashrc", IsHidden: true},
+		{Name: "app", Path: "/srv/app", FullPath: "/srv/app", IsDir: true},
+	}
+	for _, f := range files {
+		idx.StoreIndex(f.FullPath, f)
+	}
+
+	return idx
+}
+
+func searchNames(t *testing.T, idx *indexing.Index, q string) []string {
+	t.Helper()
+
+	files, err := idx.Search(context.Background(), q)
+	if err != nil {
+		t.Fatalf("%q: %v", q, err)
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestSearchQuery(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"ext:go", []string{"main.go", "old.go"}},
+		{"ext:go modified:<7d", []string{"main.go"}},
+		{"size:>10MB", []string{"video.mp4"}},
+		{"path:/srv type:file", []string{"main.go", "old.go"}},
+		{"path:/srv type:dir", []string{"app"}},
+		{"hidden:true", []string{".bashrc"}},
+		{"main OR video", []string{"main.go", "video.mp4"}},
+		{"ext:go -old", []string{"main.go"}},
+		{"ext:go NOT (main OR old)", nil},
+		{`"annual report"`, []string{"annual report.pdf"}},
+		{"path:alice (ext:pdf OR ext:mp4)", []string{"annual report.pdf", "video.mp4"}},
+	}
+
+	for _, tt := range tests {
+		got := searchNames(t, idx, tt.q)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.q, tt.want, got)
+			continue
+		}
+		for n := range got {
+			if got[n] != tt.want[n] {
+				t.Errorf("%q: expected %v, got %v", tt.q, tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchQueryError(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	_, err := idx.Search(context.Background(), "size:>lots")
+
+	var queryErr *query.Error
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected a *query.Error, got %v", err)
+	}
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	tests := []struct {
+		q         string
+		want      string
+		positions []int
+	}{
+		{"reprot", "annual report.pdf", []int{7, 8, 9, 10, 11, 12}},
+		{"anrep", "annual report.pdf", []int{0, 1, 7, 8, 9}},
+		{"report", "annual report.pdf", []int{7, 8, 9, 10, 11, 12}},
+	}
+
+	for _, tt := range tests {
+		files, err := idx.Search(context.Background(), tt.q)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.q, err)
+		}
+
+		if len(files) == 0 || files[0].Name != tt.want {
+			t.Errorf("%q: expected %s first, got %v", tt.q, tt.want, files)
+			continue
+		}
+
+		got := files[0].Internal_metadata.Positions
+		if fmt.Sprint(got) != fmt.Sprint(tt.positions) {
+			t.Errorf("%q: expected positions %v, got %v", tt.q, tt.positions, got)
+		}
+	}
+
+	// Fuzzy matches are only looked for when nothing has the word as it is
+	typo := indexing.File{Name: "reprot.txt", Extension: ".txt", Path: "/tmp", FullPath: "/tmp/reprot.txt"}
+	idx.StoreIndex(typo.FullPath, typo)
+	if names := searchNames(t, idx, "reprot"); fmt.Sprint(names) != "[reprot.txt]" {
+		t.Errorf("Expected only the literal match, got %v", names)
+	}
+}
+
+func TestSearchPattern(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	tests := []struct {
+		q    string
+		opts indexing.SearchOptions
+		want []string
+	}{
+		{"*.go", indexing.SearchOptions{Mode: indexing.ModeGlob}, []string{"main.go", "old.go"}},
+		{"*.GO", indexing.SearchOptions{Mode: indexing.ModeGlob}, []string{"main.go", "old.go"}},
+		{"**/app/*.go", indexing.SearchOptions{Mode: indexing.ModeGlob}, []string{"main.go", "old.go"}},
+		{"/home/*/*.pdf", indexing.SearchOptions{Mode: indexing.ModeGlob}, []string{"annual report.pdf"}},
+		{"/alice/*", indexing.SearchOptions{Mode: indexing.ModeGlob}, nil},
+		{"alice/*", indexing.SearchOptions{Mode: indexing.ModeGlob}, []string{".bashrc", "annual report.pdf", "video.mp4"}},
+		{`^[a-z]+\.go$`, indexing.SearchOptions{Mode: indexing.ModeRegex}, []string{"main.go", "old.go"}},
+		{"alice", indexing.SearchOptions{Mode: indexing.ModeRegex}, nil},
+		{"alice", indexing.SearchOptions{Mode: indexing.ModeRegex, Target: indexing.TargetPath}, []string{".bashrc", "annual report.pdf", "video.mp4"}},
+	}
+
+	for _, tt := range tests {
+		files, err := idx.SearchWith(context.Background(), tt.q, tt.opts)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.q, err)
+		}
+
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Name)
+		}
+		sort.Strings(got)
+
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q (%v): expected %v, got %v", tt.q, tt.opts.Mode, tt.want, got)
+		}
+	}
+
+	files, err := idx.SearchWith(context.Background(), "rep", indexing.SearchOptions{Mode: indexing.ModeRegex})
+	if err != nil || len(files) != 1 || fmt.Sprint(files[0].Internal_metadata.Positions) != "[7 8 9]" {
+		t.Errorf("Expected the positions of the match, got %v %v", files, err)
+	}
+
+	if _, err := idx.SearchWith(context.Background(), "(", indexing.SearchOptions{Mode: indexing.ModeRegex}); err == nil {
+		t.Error("Expected an invalid regex to fail")
+	}
+}
+
+func TestSplitSearchMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		mode indexing.SearchMode
+		q    string
+	}{
+		{"report", indexing.ModeQuery, "report"},
+		{"ext:go", indexing.ModeQuery, "ext:go"},
+		{"glob:*.tar.gz", indexing.ModeGlob, "*.tar.gz"},
+		{"re:^a.*z$", indexing.ModeRegex, "^a.*z$"},
+		{"REGEX:a:b", indexing.ModeRegex, "a:b"},
+	}
+
+	for _, tt := range tests {
+		mode, q := indexing.SplitSearchMode(tt.s)
+		if mode != tt.mode || q != tt.q {
+			t.Errorf("%q: expected %v %q, got %v %q", tt.s, tt.mode, tt.q, mode, q)
+		}
+	}
+}
+
+func TestSearchFollowsChanges(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	idx.RemoveIndex("/home/alice/video.mp4")
+	if got := searchNames(t, idx, "video"); len(got) != 0 {
+		t.Errorf("Expected the removed file to be gone, got %v", got)
+	}
+
+	f := indexing.File{Name: "holiday video.mkv", Extension: ".mkv", Path: "/home/bob", FullPath: "/home/bob/holiday video.mkv"}
+	idx.StoreIndex(f.FullPath, f)
+	if got := searchNames(t, idx, "video"); fmt.Sprint(got) != "[holiday video.mkv]" {
+		t.Errorf("Expected the new file, got %v", got)
+	}
+
+	// Hashes are searched too, without them being in the path
+	f = indexing.File{Name: "data.bin", Path: "/srv", FullPath: "/srv/data.bin", Hash: hash.Hash{MD5: "0123456789abcdef0123456789abcdef"}}
+	idx.StoreIndex(f.FullPath, f)
+	if got := searchNames(t, idx, "89abcdef01"); fmt.Sprint(got) != "[data.bin]" {
+		t.Errorf("Expected the file with the hash, got %v", got)
+	}
+}
+
+func TestSearchHash(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	files := []indexing.File{
+		{Name: "a.bin", Path: "/srv", FullPath: "/srv/a.bin", Hash: hash.Hash{MD5: "0123456789abcdef0123456789abcdef"}},
+		{Name: "b.bin", Path: "/srv", FullPath: "/srv/b.bin", Hash: hash.Hash{SHA2: hash.SHA2{SHA256: "0123456789abcdefaaaaaaaaaaaaaaaa"}}},
+	}
+	for _, f := range files {
+		idx.StoreIndex(f.FullPath, f)
+	}
+
+	tests := map[string]string{
+		"hash:0123456789abcdef":               "[a.bin b.bin]",
+		"hash:md5:0123456789abcdef":           "[a.bin]",
+		"hash:SHA-256:0123456789ABCDEF":       "[b.bin]",
+		"hash:0123456789abcdef -name:a.bin":   "[b.bin]",
+		"hash:0123456789abcdef OR name:video": "[a.bin b.bin video.mp4]",
+		"hash:ffffffffffffffff":               "[]",
+	}
+	for q, want := range tests {
+		if got := searchNames(t, idx, q); fmt.Sprint(got) != want && !(want == "[]" && len(got) == 0) {
+			t.Errorf("%q: got %v, want %s", q, got, want)
+		}
+	}
+
+	for _, q := range []string{"hash:0123", "hash:whirlpool:0123456789abcdef"} {
+		if _, err := idx.Search(context.Background(), q); err == nil {
+			t.Errorf("%q: expected an error", q)
+		}
+	}
+
+	paths, err := idx.LookupHash(hash.MD5, "0123456789abcdef0123456789abcdef")
+	if err != nil || fmt.Sprint(paths) != "[/srv/a.bin]" {
+		t.Errorf("LookupHash = %v, %v", paths, err)
+	}
+
+	// The index follows files that change and go away
+	idx.StoreIndex("/srv/a.bin", indexing.File{Name: "a.bin", Path: "/srv", FullPath: "/srv/a.bin"})
+	idx.RemoveIndex("/srv/b.bin")
+	if got := searchNames(t, idx, "hash:0123456789abcdef"); len(got) != 0 {
+		t.Errorf("Expected no files after the hashes changed, got %v", got)
+	}
+}
+
+func TestCandidateQuery(t *testing.T) {
+	tests := map[string]string{
+		"cafe":             `"cafe"`,
+		"2023":             `"2023"`,
+		"data":             `"data"`,
+		"glass":            `"glass"`,
+		"deadbeef":         "all",
+		"rwx":              "all",
+		"drwxr-xr-x":       "all",
+		"report NOT draft": `"report"`,
+		"report ext:pdf":   `(and "report" ".pdf")`,
+	}
+
+	for q, want := range tests {
+		got, err := indexing.CandidateQuery(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%q: expected %s, got %s", q, want, got)
+		}
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	idx := newTestIndexWithOptions(t, func(o *indexing.Options) { o.MaxResults = 100 })
+
+	for n := 0; n < 25; n++ {
+		f := testFile("/data/dir"+strconv.Itoa(n%3), "report"+strconv.Itoa(n)+".txt", n)
+		idx.StoreIndex(f.FullPath, f)
+	}
+
+	ctx := context.Background()
+	all, err := idx.SearchPage(ctx, "report", indexing.SearchOptions{})
+	if err != nil || len(all.Files) != 25 || all.Next != "" {
+		t.Fatalf("Expected all 25 files on one page, got %d and %q, %v", len(all.Files), all.Next, err)
+	}
+
+	var paged []string
+	var page indexing.Page
+	for n := 0; ; n++ {
+		page, err = idx.SearchPage(ctx, "report", indexing.SearchOptions{Limit: 10, Cursor: page.Next})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range page.Files {
+			paged = append(paged, f.FullPath)
+		}
+		if page.Next == "" {
+			break
+		}
+		if n == 0 {
+			// A file that ranks before the cursor doesn't shift the pages after it
+			f := testFile("/data", "report.txt", 99)
+			idx.StoreIndex(f.FullPath, f)
+		}
+	}
+
+	var want []string
+	for _, f := range all.Files {
+		want = append(want, f.FullPath)
+	}
+	if fmt.Sprint(paged) != fmt.Sprint(want) {
+		t.Errorf("Expected the pages to add up to the results\ngot  %v\nwant %v", paged, want)
+	}
+
+	page, err = idx.SearchPage(ctx, "report", indexing.SearchOptions{Offset: 20, Limit: 10})
+	if err != nil || len(page.Files) != 6 || page.Next != "" {
+		t.Errorf("Expected the last 6 of 26 files, got %d and %q, %v", len(page.Files), page.Next, err)
+	}
+
+	// Offsets past the results don't make room for them
+	page, err = idx.SearchPage(ctx, "report", indexing.SearchOptions{Offset: 1 << 30, Limit: 10})
+	if err != nil || len(page.Files) != 0 || page.Next != "" {
+		t.Errorf("Expected an empty last page, got %d and %q, %v", len(page.Files), page.Next, err)
+	}
+	for _, opts := range []indexing.SearchOptions{{Offset: math.MaxInt}, {Offset: 1, Limit: math.MaxInt}, {Offset: -1}} {
+		if _, err := idx.SearchPage(ctx, "report", opts); err == nil {
+			t.Errorf("Offset %d and limit %d: expected an error", opts.Offset, opts.Limit)
+		}
+	}
+
+	first, _ := idx.SearchPage(ctx, "report", indexing.SearchOptions{Limit: 1})
+	for _, c := range []string{"not a cursor", first.Next} {
+		if _, err := idx.SearchPage(ctx, "other", indexing.SearchOptions{Cursor: c}); !errors.Is(err, indexing.ErrInvalidCursor) {
+			t.Errorf("%q: expected ErrInvalidCursor, got %v", c, err)
+		}
+	}
+}
+
+func TestSearchStream(t *testing.T) {
+	idx := newSearchIndex(t)
+
+	files, err := idx.SearchStream(context.Background(), "ext:go OR ext:pdf", indexing.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for f := range files {
+		if f.Internal_metadata.Score < 1 {
+			t.Errorf("Expected %s to be scored", f.Name)
+		}
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if fmt.Sprint(names) != "[annual report.pdf main.go old.go]" {
+		t.Errorf("Unexpected results %v", names)
+	}
+
+	files, err = idx.SearchStream(context.Background(), "ext:go OR ext:pdf", indexing.SearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range files {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("Expected the limit of 2 results, got %d", n)
+	}
+
+	if _, err := idx.SearchStream(context.Background(), "size:big", indexing.SearchOptions{}); err == nil {
+		t.Error("Expected the query error")
+	}
+}
+
+func TestSearchSort(t *testing.T) {
+	idx := newSearchIndex(t)
+	ctx := context.Background()
+
+	ordered := func(q string, opts indexing.SearchOptions) string {
+		t.Helper()
+
+		files, err := idx.SearchWith(ctx, q, opts)
+		if err != nil {
+			t.Fatalf("%q: %v", q, err)
+		}
+
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return fmt.Sprint(names)
+	}
+
+	tests := []struct {
+		q    string
+		opts indexing.SearchOptions
+		want string
+	}{
+		{"path:/home/alice sort:size", indexing.SearchOptions{}, "[video.mp4 annual report.pdf .bashrc]"},
+		{"path:/home/alice sort:size:asc", indexing.SearchOptions{}, "[.bashrc annual report.pdf video.mp4]"},
+		{"ext:go sort:modified", indexing.SearchOptions{}, "[main.go old.go]"},
+		{"ext:go", indexing.SearchOptions{Sort: indexing.SortModified, Reverse: true}, "[old.go main.go]"},
+		{"path:/home/alice", indexing.SearchOptions{Sort: indexing.SortName}, "[.bashrc annual report.pdf video.mp4]"},
+		{"path:/home/alice", indexing.SearchOptions{Sort: indexing.SortExtension, Reverse: true}, "[annual report.pdf video.mp4 .bashrc]"},
+		{"path:/home/alice sort:name:desc", indexing.SearchOptions{Sort: indexing.SortSize}, "[video.mp4 annual report.pdf .bashrc]"},
+	}
+	for _, tt := range tests {
+		if got := ordered(tt.q, tt.opts); got != tt.want {
+			t.Errorf("%q %+v: got %v, want %s", tt.q, tt.opts, got, tt.want)
+		}
+	}
+
+	// Pages follow the sort
+	var paged []string
+	var page indexing.Page
+	for {
+		var err error
+		page, err = idx.SearchPage(ctx, "sort:size", indexing.SearchOptions{Limit: 2, Cursor: page.Next})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range page.Files {
+			paged = append(paged, f.Name)
+		}
+		if page.Next == "" {
+			break
+		}
+	}
+	if got, want := fmt.Sprint(paged), ordered("sort:size", indexing.SearchOptions{}); got != want {
+		t.Errorf("Expected the pages in the order of the sort\ngot  %s\nwant %s", got, want)
+	}
+
+	for _, q := range []string{"video OR sort:size", "-sort:size", "sort:size sort:name", "sort:colour", "sort:size:up"} {
+		if _, err := idx.Search(ctx, q); err == nil {
+			t.Errorf("%q: expected an error", q)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	idx := newSearchIndex(t)
+	ctx := context.Background()
+
+	files, err := idx.Search(ctx, "main ext:go -old")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected main.go, got %v, %v", files, err)
+	}
+
+	e, err := idx.Explain(ctx, "main ext:go -old", "/srv/app/main.go", indexing.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Matched || e.Score != files[0].Internal_metadata.Score || len(e.Parts) != 3 {
+		t.Fatalf("Expected a match with the score of the search and 3 parts, got %+v", e)
+	}
+
+	word, filter, not := e.Parts[0], e.Parts[1], e.Parts[2]
+	if word.Text != "main" || !word.Matched || word.Score != e.Score {
+		t.Errorf("Unexpected part for the word %+v", word)
+	}
+	total := 0
+	rules := make(map[string]int)
+	for _, r := range word.Rules {
+		total += r.Points
+		rules[r.Field+" "+r.Name] = r.Points
+	}
+	if total != word.Score || rules["name contains"] == 0 || rules["name start"] == 0 {
+		t.Errorf("Expected the rules to add up to the score, got %+v", word.Rules)
+	}
+	if filter.Text != "ext:go" || !filter.Matched || filter.Rules != nil {
+		t.Errorf("Unexpected part for the filter %+v", filter)
+	}
+	if not.Text != "old" || !not.Negated || not.Matched {
+		t.Errorf("Unexpected part for the negated word %+v", not)
+	}
+	if s := e.String(); !strings.Contains(s, "name contains") || !strings.Contains(s, "negated") {
+		t.Errorf("Expected the rules and parts in the text, got\n%s", s)
+	}
+
+	e, err = idx.Explain(ctx, "main ext:go -old", "/srv/app/old.go", indexing.SearchOptions{})
+	if err != nil || e.Matched || e.Score != 0 {
+		t.Errorf("Expected old.go not to match, got %+v, %v", e, err)
+	}
+
+	e, err = idx.Explain(ctx, "*.mp4", "/home/alice/video.mp4", indexing.SearchOptions{Mode: indexing.ModeGlob})
+	if err != nil || !e.Matched || len(e.Parts) != 1 || e.Parts[0].Score != e.Score {
+		t.Errorf("Expected the pattern to be explained, got %+v, %v", e, err)
+	}
+
+	// The name and permissions of a directory are fields of their own
+	dir := indexing.File{Name: "photos", Path: "/home/alice", FullPath: "/home/alice/photos", IsDir: true,
+		Permissions: indexing.Permissions{Permission: fs.ModeDir | 0755}}
+	idx.StoreIndex(dir.FullPath, dir)
+	for q, want := range map[string]string{"photos": "name", "alice": "path", "rwxr-xr-x": "permissions"} {
+		e, err := idx.Explain(ctx, q, dir.FullPath, indexing.SearchOptions{})
+		if err != nil || !e.Matched || len(e.Parts) != 1 || len(e.Parts[0].Rules) == 0 {
+			t.Fatalf("%q: expected the directory to match, got %+v, %v", q, e, err)
+		}
+		for _, r := range e.Parts[0].Rules {
+			if r.Field != want {
+				t.Errorf("%q: expected the rules of the %s, got %+v", q, want, r)
+			}
+		}
+	}
+
+	if _, err := idx.Explain(ctx, "main", "/not/indexed", indexing.SearchOptions{}); !errors.Is(err, indexing.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestScoringProfiles(t *testing.T) {
+	idx := newTestIndexWithOptions(t, func(o *indexing.Options) {
+		o.Profiles = map[string]indexing.ScoringProfile{
+			"code": {Paths: []indexing.PathBoost{
+				{Path: "/home/alice/projects", Points: 10},
+				{Path: "node_modules", Points: -20},
+			}},
+			"names": {Fields: map[string]float64{"name": 2}, Rules: map[string]float64{"length": 0}},
+		}
+	})
+
+	for _, dir := range []string{"/home/alice/node_modules/lib", "/srv", "/home/alice/projects/app"} {
+		f := indexing.File{Name: "report.txt", Extension: ".txt", Path: dir, FullPath: dir + "/report.txt"}
+		idx.StoreIndex(f.FullPath, f)
+	}
+
+	ctx := context.Background()
+	search := func(q, profile string) []indexing.File {
+		t.Helper()
+
+		files, err := idx.SearchWith(ctx, q, indexing.SearchOptions{Profile: profile})
+		if err != nil {
+			t.Fatalf("%q with %q: %v", q, profile, err)
+		}
+		return files
+	}
+
+	for _, q := range []string{"report", "ext:txt"} {
+		var paths []string
+		for _, f := range search(q, "code") {
+			paths = append(paths, f.FullPath)
+		}
+		want := "[/home/alice/projects/app/report.txt /srv/report.txt /home/alice/node_modules/lib/report.txt]"
+		if fmt.Sprint(paths) != want {
+			t.Errorf("%q: expected the boosted path first and the demoted one last, got %v", q, paths)
+		}
+	}
+
+	plain := search("report", "")[0].Internal_metadata.Score
+	weighed := search("report", "names")[0]
+	e, err := idx.Explain(ctx, "report", weighed.FullPath, indexing.SearchOptions{Profile: "names"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Score != weighed.Internal_metadata.Score || e.Profile != "names" || e.Score <= plain {
+		t.Errorf("Expected the weighed score %d above %d in the explanation, got %+v", weighed.Internal_metadata.Score, plain, e)
+	}
+	for _, r := range e.Parts[0].Rules {
+		want := 2.0
+		if r.Name == "length" {
+			want = 0
+		}
+		if r.Weight != want {
+			t.Errorf("Expected a weight of %g for %+v", want, r)
+		}
+	}
+
+	e, err = idx.Explain(ctx, "report", "/home/alice/node_modules/lib/report.txt", indexing.SearchOptions{Profile: "code"})
+	if err != nil || len(e.Boosts) != 1 || e.Boosts[0].Points != -20 || e.Score != e.Parts[0].Score-20 {
+		t.Errorf("Expected the penalty in the explanation, got %+v, %v", e, err)
+	}
+
+	if _, err := idx.Search(ctx, "report"); err != nil {
+		t.Error(err)
+	}
+	if _, err := idx.SearchWith(ctx, "report", indexing.SearchOptions{Profile: "media"}); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	idx := benchmarkIndex(b, filepath.Join(b.TempDir(), indexing.IndexFileName))
+	for n := 0; n < benchmarkFiles; n++ {
+		f := testFile("/data/dir"+strconv.Itoa(n%1000), "file"+strconv.Itoa(n)+".txt", n)
+		idx.StoreIndex(f.FullPath, f)
+	}
+
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, q := range []string{"file4242", "path:dir42/ ext:txt", "fiel4242", "glob:**/dir7/file*7.txt"} {
+		mode, text := indexing.SplitSearchMode(q)
+		b.Run(q, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := idx.SearchWith(context.Background(), text, indexing.SearchOptions{Mode: mode}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchContent(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"report.md":  "# Plans\n\nThe annual budget is due.\nNothing else.\n",
+		"notes.txt":  "budget: annual\n",
+		"binary.txt": "annual budget\x00\x01",
+		"big.log":    "annual budget " + strings.Repeat("x", 2048),
+		"image.png":  "annual budget",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Content.Enabled = true
+		o.Content.MaxSize = 1024
+	})
+
+	waitFor(t, func() bool {
+		return len(searchNames(t, idx, "content:budget")) == 2
+	})
+
+	if got := searchNames(t, idx, "content:budget"); fmt.Sprint(got) != "[notes.txt report.md]" {
+		t.Errorf("Expected the text files, got %v", got)
+	}
+
+	files2, err := idx.Search(context.Background(), `content:"Annual Budget"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files2) != 1 || files2[0].Name != "report.md" {
+		t.Fatalf("Expected only the file with the phrase, got %v", files2)
+	}
+
+	snippets := files2[0].Internal_metadata.Snippets
+	if len(snippets) != 1 || snippets[0].Line != 3 || snippets[0].Text != "The annual budget is due." {
+		t.Errorf("Unexpected snippets %+v", snippets)
+	}
+
+	if got := searchNames(t, idx, "content:budget -content:plans"); fmt.Sprint(got) != "[notes.txt]" {
+		t.Errorf("Expected the file without plans, got %v", got)
+	}
+
+	// Without content indexing
+	_, err = newSearchIndex(t).Search(context.Background(), "content:budget")
+	if !errors.Is(err, indexing.ErrContentDisabled) {
+		t.Errorf("Expected ErrContentDisabled, got %v", err)
+	}
+}
+
+type reverseExtractor struct{}
+
+func (reverseExtractor) Name() string         { return "reverse" }
+func (reverseExtractor) Extensions() []string { return []string{".rev"} }
+func (reverseExtractor) MIMETypes() []string  { return nil }
+
+func (reverseExtractor) Extract(r io.ReaderAt, size int64) (*extract.Document, error) {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	for a, b := 0, len(data)-1; a < b; a, b = a+1, b-1 {
+		data[a], data[b] = data[b], data[a]
+	}
+	return &extract.Document{Text: string(data), Metadata: map[string]string{"title": "Mirror"}}, err
+}
+
+func TestSearchContentExtractors(t *testing.T) {
+	root := t.TempDir()
+
+	var docx bytes.Buffer
+	z := zip.NewWriter(&docx)
+	w, _ := z.Create("word/document.xml")
+	w.Write([]byte(`<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>Quarterly budget</w:t></w:r></w:p></w:body></w:document>`))
+	z.Close()
+
+	files := map[string][]byte{
+		"plan.docx":  docx.Bytes(),
+		"secret.rev": []byte("tegdub"),
+		"README":     []byte("no budget here\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := extract.NewRegistry()
+	registry.Register(extract.Default.ForExtension(".docx"))
+	registry.Register(reverseExtractor{})
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Content.Enabled = true
+		o.Extractors = registry
+	})
+
+	waitFor(t, func() bool {
+		return len(searchNames(t, idx, "content:budget")) == 3
+	})
+
+	if got := searchNames(t, idx, "content:budget"); fmt.Sprint(got) != "[README plan.docx secret.rev]" {
+		t.Errorf("Expected the extracted files, got %v", got)
+	}
+
+	// Metadata is searched too
+	if got := searchNames(t, idx, "content:mirror"); fmt.Sprint(got) != "[secret.rev]" {
+		t.Errorf("Expected the file with the title, got %v", got)
+	}
+
+	files2, err := idx.Search(context.Background(), `content:"quarterly budget"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files2) != 1 || len(files2[0].Internal_metadata.Snippets) != 1 || files2[0].Internal_metadata.Snippets[0].Text != "Quarterly budget" {
+		t.Errorf("Expected a snippet of the extracted text, got %v", files2)
+	}
+}
+
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSearchArchives(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "docs.zip")
+
+	inner := zipArchive(t, map[string][]byte{"deep.txt": []byte("deep")})
+	data := zipArchive(t, map[string][]byte{
+		"report/summary.txt": []byte("summary"),
+		"inner.jar":          inner,
+	})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := startTestIndex(t, func(o *indexing.Options) {
+		o.Roots = []indexing.Root{{Path: root}}
+		o.Watch.PollInterval = indexing.Duration(50 * time.Millisecond)
+		o.Archive.Enabled = true
+		o.Archive.MaxDepth = 1
+	})
+
+	key := filepath.ToSlash(path) + "!/report/summary.txt"
+	waitFor(t, func() bool { return idx.ExistIndex(key) })
+
+	files, err := idx.Search(context.Background(), "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].FullPath != key || files[0].Size != 7 || files[0].ModTime.Year() != 2020 {
+		t.Fatalf("Expected the entry with the size and time from the archive, got %+v", files)
+	}
+	if files[0].Path != filepath.ToSlash(path)+"!/report" {
+		t.Errorf("Expected the directory in the archive as the path, got %s", files[0].Path)
+	}
+
+	if got := searchNames(t, idx, "path:inner.jar deep"); fmt.Sprint(got) != "[deep.txt]" {
+		t.Errorf("Expected the entry of the nested archive, got %v", got)
+	}
+	if got := searchNames(t, idx, "report type:dir"); fmt.Sprint(got) != "[report]" {
+		t.Errorf("Expected the implied directory, got %v", got)
+	}
+
+	// Entries that are gone from the archive are removed with it
+	data = zipArchive(t, map[string][]byte{"other.txt": []byte("other")})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !idx.ExistIndex(key) && idx.ExistIndex(filepath.ToSlash(path)+"!/other.txt") })
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !idx.ExistIndex(filepath.ToSlash(path) + "!/other.txt") })
+}
@@ -0,0 +1,178 @@
+package indexing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+// SortKey is what search results are ordered by
+type SortKey int
+
+const (
+	SortRelevance SortKey = iota // Best score first
+	SortModified                 // Most recently modified first
+	SortSize                     // Biggest first
+	SortName                     // Name from A to Z
+	SortPath                     // Full path from A to Z
+	SortExtension                // Extension from A to Z
+	SortRecent                   // Best score first, and the most recently modified of the same score
+)
+
+func (k SortKey) String() string {
+	switch k {
+	case SortModified:
+		return "modified"
+	case SortSize:
+		return "size"
+	case SortName:
+		return "name"
+	case SortPath:
+		return "path"
+	case SortExtension:
+		return "ext"
+	case SortRecent:
+		return "recent"
+	}
+	return "relevance"
+}
+
+// ParseSortKey returns the sort key for a name like "size" or "modified"
+func ParseSortKey(name string) (SortKey, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "relevance", "score":
+		return SortRelevance, nil
+	case "modified", "mtime", "time":
+		return SortModified, nil
+	case "size":
+		return SortSize, nil
+	case "name":
+		return SortName, nil
+	case "path":
+		return SortPath, nil
+	case "ext", "extension":
+		return SortExtension, nil
+	case "recent":
+		return SortRecent, nil
+	}
+	return 0, fmt.Errorf("unknown sort %q", name)
+}
+
+// descending reports whether the key sorts from high to low unless reversed
+func (k SortKey) descending() bool {
+	switch k {
+	case SortName, SortPath, SortExtension:
+		return false
+	}
+	return true
+}
+
+// ranking is the order of the results of a search
+type ranking struct {
+	key     SortKey
+	reverse bool // The other way around than the key sorts
+}
+
+// before reports whether a comes before b. Files the key can't tell apart are ordered by
+// their score and then their full path, so every page sees the same order.
+func (r ranking) before(a, b File) bool {
+	if c := r.compare(a, b); c != 0 {
+		if r.key.descending() != r.reverse {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	if a.Internal_metadata.Score != b.Internal_metadata.Score {
+		return a.Internal_metadata.Score > b.Internal_metadata.Score
+	}
+	return a.FullPath < b.FullPath
+}
+
+// compare compares the values of the key of two files like strings.Compare
+func (r ranking) compare(a, b File) int {
+	switch r.key {
+	case SortRelevance:
+		return compareInt(int64(a.Internal_metadata.Score), int64(b.Internal_metadata.Score))
+	case SortModified:
+		return a.ModTime.Compare(b.ModTime)
+	case SortSize:
+		return compareInt(a.Size, b.Size)
+	case SortName:
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	case SortPath:
+		return strings.Compare(strings.ToLower(a.FullPath), strings.ToLower(b.FullPath))
+	case SortExtension:
+		return strings.Compare(strings.ToLower(a.Extension), strings.ToLower(b.Extension))
+	case SortRecent:
+		if c := compareInt(int64(a.Internal_metadata.Score), int64(b.Internal_metadata.Score)); c != 0 {
+			return c
+		}
+		return a.ModTime.Compare(b.ModTime)
+	}
+	return 0
+}
+
+// rankHeap keeps the best results seen so far, the worst of them is on top to be dropped
+type rankHeap struct {
+	files   []File
+	ranking ranking
+}
+
+func (h *rankHeap) Len() int           { return len(h.files) }
+func (h *rankHeap) Less(a, b int) bool { return h.ranking.before(h.files[b], h.files[a]) }
+func (h *rankHeap) Swap(a, b int)      { h.files[a], h.files[b] = h.files[b], h.files[a] }
+func (h *rankHeap) Push(x interface{}) { h.files = append(h.files, x.(File)) }
+
+func (h *rankHeap) Pop() interface{} {
+	last := len(h.files) - 1
+	file := h.files[last]
+	h.files[last] = File{}
+	h.files = h.files[:last]
+	return file
+}
+
+// querySort returns the ranking a sort: filter of a query asks for, like sort:size or
+// sort:name:desc. ok is false when the query has none. It only works at the top of a
+// query, next to the filters, not inside OR or NOT.
+func querySort(node query.Node) (r ranking, ok bool, err error) {
+	top := []query.Node{node}
+	if and, isAnd := node.(*query.And); isAnd {
+		top = and.Nodes
+	}
+
+	var fields []*query.Field
+	for _, n := range top {
+		if f, isField := n.(*query.Field); isField && f.Name == "sort" {
+			fields = append(fields, f)
+		}
+	}
+
+	switch {
+	case len(fieldsNamed(node, "sort")) > len(fields):
+		return r, false, errors.New("sort: only works at the top of a query, not inside OR or NOT")
+	case len(fields) > 1:
+		return r, false, errors.New("sort: can only be given once")
+	case len(fields) == 0:
+		return r, false, nil
+	}
+
+	name, order, _ := strings.Cut(fields[0].Value, ":")
+	if r.key, err = ParseSortKey(name); err != nil {
+		return r, false, fmt.Errorf("sort: %w", err)
+	}
+
+	switch strings.ToLower(order) {
+	case "":
+	case "asc":
+		r.reverse = r.key.descending()
+	case "desc":
+		r.reverse = !r.key.descending()
+	default:
+		return r, false, fmt.Errorf("sort: expected asc or desc but found %q", order)
+	}
+
+	return r, true, nil
+}
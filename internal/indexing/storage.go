@@ -0,0 +1,483 @@
+package indexing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// The index file starts with a header and ends with a CRC-32 of everything before it:
+//
+//	magic    [8]byte  "TMDWIDX\n"
+//	version  uint32   little endian
+//	count    uint64   little endian, number of entries
+//	journal  uint64   little endian, generation of the journal to replay on top (since version 3)
+//	body     lz4 compressed entries, binary records since version 4 and NDJSON before
+//	checksum uint32   little endian, CRC-32 (IEEE)
+//
+// Files written before the header existed are only the lz4 compressed NDJSON body.
+// Version 5 only adds recordStat to the records, version 4 files are read the same way.
+const (
+	indexFileMagic   = "TMDWIDX\n"
+	indexFileVersion = 5
+
+	indexHeaderSize  = len(indexFileMagic) + 4 + 8 + 8
+	indexTrailerSize = 4
+
+	// Size of the header up to and including the version
+	indexVersionSize = len(indexFileMagic) + 4
+
+	// Suffixes of the file being written and of the previous good generation
+	tmpSuffix  = ".tmp"
+	prevSuffix = ".prev"
+)
+
+// The first bytes of an lz4 frame, used to recognize files without a header
+var lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+
+type indexEntry struct {
+	Key   string
+	Value File
+}
+
+// storedEntry is an entry of the FilesMap as it is being stored
+type storedEntry struct {
+	key, value interface{}
+}
+
+// snapshot is the content of an index file
+type snapshot struct {
+	entries    []indexEntry
+	generation uint64 // Journals of this generation and newer are replayed on top
+}
+
+// StoragePath returns where the index is stored
+func (i *Index) StoragePath() string {
+	return i.opts.StoragePath
+}
+
+// LoadFileIndex reads the FilesMap from disk and replays the journal on top of it. If the
+// index file is corrupt, for example after a crash, the previous generation is loaded
+// instead. Changes are journaled from here on.
+func (i *Index) LoadFileIndex() error {
+	startTime := time.Now()
+
+	i.journalLock.Lock()
+	defer i.journalLock.Unlock()
+
+	if i.journal != nil {
+		if err := i.journal.close(); err != nil {
+			log.Println(err)
+		}
+		i.journal = nil
+	}
+
+	var snap snapshot
+	var loadErr error
+	for _, path := range []string{i.opts.StoragePath, i.opts.StoragePath + prevSuffix} {
+		s, err := readIndexFile(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Could not load index file %s: %v", path, err)
+			}
+			if loadErr == nil || errors.Is(loadErr, os.ErrNotExist) {
+				loadErr = err
+			}
+			continue
+		}
+
+		for _, entry := range s.entries {
+			i.putFile(entry.Key, entry.Value)
+		}
+
+		log.Printf("Loaded %d files from %s in %s", len(s.entries), path, time.Since(startTime))
+
+		snap, loadErr = s, nil
+		break
+	}
+
+	i.stored = snap.generation
+
+	// Without a snapshot whatever the journals have is still better than nothing
+	replayed, err := i.replayJournals(snap.generation)
+	if err != nil {
+		return err
+	}
+
+	if replayed && errors.Is(loadErr, os.ErrNotExist) {
+		return nil
+	}
+
+	return loadErr
+}
+
+// replayJournals replays the journals of the generation and newer and opens the current one
+// to append to, it reports whether there were any. It must be called with the journalLock held.
+func (i *Index) replayJournals(generation uint64) (bool, error) {
+	path := i.opts.StoragePath + journalSuffix
+
+	if !i.opts.ReadOnly {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return false, err
+		}
+	}
+
+	replayed := false
+
+	var current *journalFile
+	for _, p := range []string{i.opts.StoragePath + oldJournalSuffix, path} {
+		j, err := readJournal(p)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Println(err)
+			}
+			continue
+		}
+
+		if j.generation < generation {
+			continue
+		}
+
+		i.replay(j.ops)
+		replayed = true
+
+		if len(j.ops) > 0 {
+			log.Printf("Replayed %d changes from %s", len(j.ops), p)
+		}
+
+		if p == path {
+			current = &j
+		}
+	}
+
+	if i.opts.ReadOnly {
+		return replayed, nil
+	}
+
+	var err error
+	if current != nil {
+		i.journal, err = openJournal(path, *current)
+	} else {
+		i.journal, err = createJournal(path, generation)
+	}
+	if err != nil {
+		return replayed, err
+	}
+
+	i.generation = i.journal.generation
+
+	return replayed, nil
+}
+
+// readIndexFile reads and verifies every entry of an index file
+func readIndexFile(path string) (snapshot, error) {
+	var snap snapshot
+
+	file, err := os.Open(path)
+	if err != nil {
+		return snap, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return snap, err
+	}
+
+	reader := bufio.NewReader(file)
+
+	start, err := reader.Peek(len(indexFileMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return snap, err
+	}
+
+	if !bytes.Equal(start, []byte(indexFileMagic)) {
+		if bytes.HasPrefix(start, lz4Magic) {
+			return readLegacyIndex(reader)
+		}
+		return snap, fmt.Errorf("%w: unknown format", ErrCorruptIndex)
+	}
+
+	if info.Size() < int64(indexVersionSize+indexTrailerSize) {
+		return snap, fmt.Errorf("%w: too short", ErrCorruptIndex)
+	}
+
+	// Everything but the trailer goes through the checksum
+	crc := crc32.NewIEEE()
+	body := io.TeeReader(io.LimitReader(reader, info.Size()-indexTrailerSize), crc)
+
+	header := make([]byte, indexHeaderSize)
+	if _, err := io.ReadFull(body, header[:indexVersionSize]); err != nil {
+		return snap, fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+	}
+
+	// Version 2 has no journal generation
+	version := binary.LittleEndian.Uint32(header[len(indexFileMagic):])
+	switch version {
+	case 2:
+		header = header[:indexHeaderSize-8]
+	case 3, 4, indexFileVersion:
+	default:
+		return snap, fmt.Errorf("unsupported index file version %d", version)
+	}
+
+	if _, err := io.ReadFull(body, header[indexVersionSize:]); err != nil {
+		return snap, fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+	}
+
+	count := binary.LittleEndian.Uint64(header[indexVersionSize:])
+	if version >= 3 {
+		snap.generation = binary.LittleEndian.Uint64(header[indexVersionSize+8:])
+	}
+
+	if version >= 4 {
+		snap.entries, err = decodeRecords(lz4.NewReader(body), count)
+	} else {
+		snap.entries, err = decodeEntries(lz4.NewReader(body), count)
+	}
+	if err != nil {
+		return snapshot{}, fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+	}
+
+	// Anything the decoder did not need still counts
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return snapshot{}, err
+	}
+
+	trailer := make([]byte, indexTrailerSize)
+	if _, err := io.ReadFull(reader, trailer); err != nil {
+		return snapshot{}, fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+	}
+
+	if binary.LittleEndian.Uint32(trailer) != crc.Sum32() {
+		return snapshot{}, fmt.Errorf("%w: checksum mismatch", ErrCorruptIndex)
+	}
+
+	if uint64(len(snap.entries)) != count {
+		return snapshot{}, fmt.Errorf("%w: expected %d entries but found %d", ErrCorruptIndex, count, len(snap.entries))
+	}
+
+	return snap, nil
+}
+
+// readLegacyIndex reads an index file from before the header, there is nothing to verify it with
+func readLegacyIndex(r io.Reader) (snapshot, error) {
+	entries, err := decodeEntries(lz4.NewReader(r), 0)
+	if err != nil {
+		return snapshot{}, fmt.Errorf("%w: %v", ErrCorruptIndex, err)
+	}
+
+	return snapshot{entries: entries}, nil
+}
+
+// decodeRecords decodes the binary records of version 4 and newer
+func decodeRecords(r io.Reader, sizeHint uint64) ([]indexEntry, error) {
+	entries := make([]indexEntry, 0, capHint(sizeHint))
+	reader := newRecordReader(r)
+
+	for {
+		key, file, err := reader.read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		entries = append(entries, indexEntry{Key: key, Value: file})
+	}
+
+	return entries, nil
+}
+
+// decodeEntries decodes the NDJSON entries of the versions before 4
+func decodeEntries(r io.Reader, sizeHint uint64) ([]indexEntry, error) {
+	entries := make([]indexEntry, 0, capHint(sizeHint))
+	decoder := json.NewDecoder(r)
+
+	for {
+		var entry indexEntry
+
+		err := decoder.Decode(&entry)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// capHint limits a size hint, a huge allocation shouldn't be trusted before the checksum is known to be good
+func capHint(sizeHint uint64) uint64 {
+	if sizeHint > 1<<20 {
+		return 1 << 20
+	}
+	return sizeHint
+}
+
+// Update the last time the index was stored to disk, stored is the number of changes it contains
+func (i *Index) updateLastStore(stored int32) {
+	atomic.StoreInt64(&i.lastStore, time.Now().Unix())
+	atomic.AddInt32(&i.newFilesSinceStore, -stored)
+}
+
+// Get the last time the index was stored to disk
+func (i *Index) getLastStore() time.Time {
+	return time.Unix(atomic.LoadInt64(&i.lastStore), 0)
+}
+
+// StoreFileIndex stores a copy of the FilesMap to disk and starts a new journal, this is
+// what compacts the journal.
+//
+// The index is written to a temporary file that replaces the index file once it is synced to
+// disk, the file it replaces is kept as the previous generation.
+func (i *Index) StoreFileIndex() error {
+	if i.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	i.storeLock.Lock()
+	defer i.storeLock.Unlock()
+
+	path := i.opts.StoragePath
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Changes from here on go to the journal of the new snapshot. Replaying a change that the
+	// snapshot already has is harmless, so the map doesn't have to be locked while it is copied.
+	i.journalLock.Lock()
+	changes := atomic.LoadInt32(&i.newFilesSinceStore)
+	generation, err := i.rotateJournal()
+	i.journalLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Only the references are copied, the files are copied one at a time while writing
+	var entries []storedEntry
+	i.FilesMap.Range(func(key, value interface{}) bool {
+		entries = append(entries, storedEntry{key, value})
+		return true
+	})
+
+	tmpPath := path + tmpSuffix
+	if err := writeIndexFile(tmpPath, entries, generation); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Keep the current file as the previous generation. A hard link means there is no moment
+	// without an index file, not every file system has them though.
+	prevPath := path + prevSuffix
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(prevPath)
+		if err := os.Link(path, prevPath); err != nil {
+			if err := os.Rename(path, prevPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+
+	// The old journal can go with the next rotation now
+	i.journalLock.Lock()
+	i.stored = generation
+	i.journalLock.Unlock()
+
+	i.updateLastStore(changes)
+
+	return nil
+}
+
+// writeIndexFile writes the entries to a new file and syncs it to disk
+func writeIndexFile(path string, entries []storedEntry, generation uint64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	crc := crc32.NewIEEE()
+	buffered := bufio.NewWriter(file)
+	w := io.MultiWriter(buffered, crc)
+
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexFileMagic)
+	binary.LittleEndian.PutUint32(header[len(indexFileMagic):], indexFileVersion)
+	binary.LittleEndian.PutUint64(header[indexVersionSize:], uint64(len(entries)))
+	binary.LittleEndian.PutUint64(header[indexVersionSize+8:], generation)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	lz4Writer := lz4.NewWriter(w)
+	records := newRecordWriter(lz4Writer)
+
+	for _, entry := range entries {
+		if err := records.write(entry.key.(string), entry.value.(File)); err != nil {
+			return err
+		}
+	}
+
+	if err := records.flush(); err != nil {
+		return err
+	}
+
+	if err := lz4Writer.Close(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, indexTrailerSize)
+	binary.LittleEndian.PutUint32(trailer, crc.Sum32())
+
+	if _, err := buffered.Write(trailer); err != nil {
+		return err
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// syncDir makes a rename in dir durable, not every platform supports syncing a directory
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	d.Sync()
+}
@@ -0,0 +1,491 @@
+package indexing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/hash"
+	"github.com/TechMDW/indexing/internal/indexing"
+	"github.com/pierrec/lz4/v4"
+)
+
+func newStorageIndex(t *testing.T, storagePath string) *indexing.Index {
+	t.Helper()
+
+	return newTestIndexWithOptions(t, func(o *indexing.Options) { o.StoragePath = storagePath })
+}
+
+func TestStoreFileIndexRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	idx := newStorageIndex(t, path)
+	idx.StoreIndex("/a/one.txt", indexing.File{Name: "one.txt", FullPath: "/a/one.txt"})
+	idx.StoreIndex("/a/two.txt", indexing.File{Name: "two.txt", FullPath: "/a/two.txt"})
+
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A smaller index must not leave anything of the bigger one behind
+	idx.RemoveIndex("/a/two.txt")
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be gone, got %v", err)
+	}
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.ExistIndex("/a/one.txt") {
+		t.Error("Expected one.txt to be loaded")
+	}
+
+	if reloaded.ExistIndex("/a/two.txt") {
+		t.Error("Expected two.txt not to be loaded")
+	}
+}
+
+func TestLoadFileIndexFallsBackToPrevious(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	idx := newStorageIndex(t, path)
+	idx.StoreIndex("/a/one.txt", indexing.File{Name: "one.txt", FullPath: "/a/one.txt"})
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx.StoreIndex("/a/two.txt", indexing.File{Name: "two.txt", FullPath: "/a/two.txt"})
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte in the middle of the newest generation
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.ExistIndex("/a/one.txt") {
+		t.Error("Expected one.txt from the previous generation")
+	}
+
+	// The journal of the previous generation has what went into the corrupt one
+	if !reloaded.ExistIndex("/a/two.txt") {
+		t.Error("Expected two.txt to be replayed from the journal")
+	}
+}
+
+func TestLoadFileIndexCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	idx := newStorageIndex(t, path)
+	idx.StoreIndex("/a/one.txt", indexing.File{Name: "one.txt", FullPath: "/a/one.txt"})
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err == nil {
+		t.Error("Expected an error for a truncated index without a previous generation")
+	}
+}
+
+func TestLoadFileIndexLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	var buf bytes.Buffer
+	w := lz4.NewWriter(&buf)
+	entry := struct {
+		Key   string
+		Value indexing.File
+	}{"/a/old.txt", indexing.File{Name: "old.txt", FullPath: "/a/old.txt"}}
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := newStorageIndex(t, path)
+	if err := idx.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !idx.ExistIndex("/a/old.txt") {
+		t.Error("Expected the file from the legacy index")
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	idx := newStorageIndex(t, path)
+	if err := idx.LoadFileIndex(); !os.IsNotExist(err) {
+		t.Fatalf("Expected no index file yet, got %v", err)
+	}
+
+	idx.StoreIndex("/a/one.txt", indexing.File{Name: "one.txt", FullPath: "/a/one.txt"})
+	idx.StoreIndex("/a/two.txt", indexing.File{Name: "two.txt", FullPath: "/a/two.txt"})
+	idx.RemoveIndex("/a/two.txt")
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A record cut off by a crash
+	f, err := os.OpenFile(path+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0x20, 0, 0, 0, 1, 2})
+	f.Close()
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.ExistIndex("/a/one.txt") || reloaded.ExistIndex("/a/two.txt") {
+		t.Fatal("Expected the journal to be replayed")
+	}
+
+	// The journal continues after the last good record
+	reloaded.StoreIndex("/a/three.txt", indexing.File{Name: "three.txt", FullPath: "/a/three.txt"})
+	if err := reloaded.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	again := newStorageIndex(t, path)
+	if err := again.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !again.ExistIndex("/a/one.txt") || !again.ExistIndex("/a/three.txt") {
+		t.Error("Expected the changes of both runs to be replayed")
+	}
+}
+
+func TestStoreFileIndexFailedKeepsJournals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	idx := newStorageIndex(t, path)
+	idx.LoadFileIndex()
+	idx.StoreIndex("/a/one.txt", indexing.File{Name: "one.txt", FullPath: "/a/one.txt"})
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory that can't be removed where the new snapshot is written
+	if err := os.MkdirAll(filepath.Join(path+".tmp", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	idx.StoreIndex("/a/two.txt", indexing.File{Name: "two.txt", FullPath: "/a/two.txt"})
+	if err := idx.StoreFileIndex(); err == nil {
+		t.Fatal("Expected the snapshot to fail")
+	}
+	idx.StoreIndex("/a/three.txt", indexing.File{Name: "three.txt", FullPath: "/a/three.txt"})
+	if err := idx.StoreFileIndex(); err == nil {
+		t.Fatal("Expected the snapshot to fail again")
+	}
+	idx.RemoveIndex("/a/one.txt")
+	if err := idx.SyncJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.ExistIndex("/a/one.txt") || !reloaded.ExistIndex("/a/two.txt") || !reloaded.ExistIndex("/a/three.txt") {
+		t.Fatal("Expected every change since the last snapshot to be replayed")
+	}
+
+	// Once a snapshot makes it the journals start over from it
+	reloaded.Close()
+	if err := os.RemoveAll(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+	idx.StoreIndex("/a/four.txt", indexing.File{Name: "four.txt", FullPath: "/a/four.txt"})
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	again := newStorageIndex(t, path)
+	if err := again.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ExistIndex("/a/one.txt") || !again.ExistIndex("/a/three.txt") || !again.ExistIndex("/a/four.txt") {
+		t.Error("Expected the snapshot and the journal after it")
+	}
+}
+
+func testFile(dir, name string, n int) indexing.File {
+	f := indexing.File{
+		Name:      name,
+		Extension: filepath.Ext(name),
+		Path:      dir,
+		FullPath:  dir + "/" + name,
+		Size:      int64(n * 1024),
+		ModTime:   time.Unix(1700000000+int64(n), 123),
+		Permissions: indexing.Permissions{
+			Permission: 0644,
+		},
+		Hash: hash.Hash{
+			MD5:  fmt.Sprintf("%032x", n),
+			SHA1: fmt.Sprintf("%040x", n),
+		},
+	}
+	f.PathInfo = indexing.PathInfo{
+		Abs:          f.FullPath,
+		Base:         name,
+		Clean:        f.FullPath,
+		Dir:          dir,
+		Ext:          f.Extension,
+		EvalSymlinks: f.FullPath,
+		IsAbs:        true,
+		Separator:    string(os.PathSeparator),
+	}
+	return f
+}
+
+func TestStoreFileIndexKeepsEveryField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+
+	plain := testFile("/home/user/docs", "report.pdf", 1)
+
+	odd := testFile("/home/user", ".hidden", 2)
+	odd.IsHidden = true
+	odd.IsDir = true
+	odd.IsOneDrivePlaceholder = true
+	odd.CreatedTime = time.Unix(1600000000, 0)
+	odd.AccessedTime = time.Unix(1650000000, 5)
+	odd.Permissions = indexing.Permissions{Owner: "rwx", Group: "r-x", Other: "---", Permission: os.ModeDir | 0755, UID: 1000, GID: 100}
+	odd.Inode, odd.Device, odd.Links = 1<<40, 2049, 3
+	odd.Hash.CRC.CRC32 = "ABC" // Not hex that survives a round trip
+	odd.Error = "permission denied"
+	odd.WindowsAttributes.Hidden = true
+	odd.WindowsAttributes.OneDrive = true
+	odd.PathInfo = indexing.PathInfo{Dir: "/home/user"} // As stored by older versions
+
+	files := map[string]indexing.File{
+		plain.FullPath:   plain,
+		"/custom/key":    odd,
+		"/only/fullpath": {FullPath: "/only/fullpath", Path: "/only/fullpath"},
+	}
+
+	idx := newStorageIndex(t, path)
+	for key, f := range files {
+		idx.StoreIndex(key, f)
+	}
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Don't replay the journal, everything has to come from the snapshot
+	os.Remove(path + ".journal")
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range files {
+		got, err := reloaded.GetIndex(key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", key, got, want)
+		}
+	}
+}
+
+func TestLoadFileIndexMigratesLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), indexing.IndexFileName)
+	writeLegacyIndex(t, path, 10)
+
+	idx := newStorageIndex(t, path)
+	if err := idx.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.StoreFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("TMDWIDX\n")) {
+		t.Fatal("Expected the index file to be written in the current format")
+	}
+
+	reloaded := newStorageIndex(t, path)
+	if err := reloaded.LoadFileIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 0; n < 10; n++ {
+		want := testFile("/data/dir"+strconv.Itoa(n%3), "file"+strconv.Itoa(n)+".txt", n)
+		got, err := reloaded.GetIndex(want.FullPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got  %+v\nwant %+v", got, want)
+		}
+	}
+}
+
+// writeLegacyIndex writes n files in the headerless NDJSON format and returns its size
+func writeLegacyIndex(tb testing.TB, path string, n int) int {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	w := lz4.NewWriter(&buf)
+	encoder := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		f := testFile("/data/dir"+strconv.Itoa(i%3), "file"+strconv.Itoa(i)+".txt", i)
+		if err := encoder.Encode(struct {
+			Key   string
+			Value indexing.File
+		}{f.FullPath, f}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		tb.Fatal(err)
+	}
+
+	return buf.Len()
+}
+
+const benchmarkFiles = 100000
+
+func benchmarkIndex(b *testing.B, path string) *indexing.Index {
+	b.Helper()
+
+	opts := indexing.DefaultOptions()
+	opts.Roots = []indexing.Root{{Path: b.TempDir()}}
+	opts.StoragePath = path
+
+	idx, err := indexing.New(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { idx.Close() })
+
+	return idx
+}
+
+// Run with go test -run x -bench Index -benchmem ./internal/indexing
+func BenchmarkStoreFileIndex(b *testing.B) {
+	path := filepath.Join(b.TempDir(), indexing.IndexFileName)
+
+	idx := benchmarkIndex(b, path)
+	for n := 0; n < benchmarkFiles; n++ {
+		f := testFile("/data/dir"+strconv.Itoa(n%1000), "file"+strconv.Itoa(n)+".txt", n)
+		idx.StoreIndex(f.FullPath, f)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := idx.StoreFileIndex(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(info.Size())/benchmarkFiles, "bytes/file")
+}
+
+func BenchmarkLoadFileIndex(b *testing.B) {
+	b.Run("binary", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), indexing.IndexFileName)
+
+		idx := benchmarkIndex(b, path)
+		for n := 0; n < benchmarkFiles; n++ {
+			f := testFile("/data/dir"+strconv.Itoa(n%1000), "file"+strconv.Itoa(n)+".txt", n)
+			idx.StoreIndex(f.FullPath, f)
+		}
+		if err := idx.StoreFileIndex(); err != nil {
+			b.Fatal(err)
+		}
+		os.Remove(path + ".journal")
+
+		info, err := os.Stat(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			if err := benchmarkIndex(b, path).LoadFileIndex(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(info.Size())/benchmarkFiles, "bytes/file")
+	})
+
+	b.Run("ndjson", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), indexing.IndexFileName)
+		size := writeLegacyIndex(b, path, benchmarkFiles)
+
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			os.Remove(path + ".journal")
+			b.StartTimer()
+
+			if err := benchmarkIndex(b, path).LoadFileIndex(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(size)/benchmarkFiles, "bytes/file")
+	})
+}
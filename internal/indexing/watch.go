@@ -0,0 +1,230 @@
+package indexing
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/trigram"
+	"github.com/TechMDW/indexing/internal/watcher"
+)
+
+// How long events for the same path are merged before they are applied
+const eventDelay = 1 * time.Second
+
+// requestRescan asks the scanner to crawl every root again, requests are merged while one is pending
+func (i *Index) requestRescan() {
+	select {
+	case i.rescan <- struct{}{}:
+	default:
+	}
+}
+
+// watchDir starts watching a crawled directory
+func (i *Index) watchDir(path string) {
+	if i.watcher == nil {
+		return
+	}
+
+	if err := i.watcher.Add(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println(err)
+	}
+}
+
+// applyEvents applies the watcher events to the FilesMap until ctx is done. Events for the
+// same path are merged for a moment so a file that is being written is only indexed once.
+func (i *Index) applyEvents(ctx context.Context, w watcher.Watcher) {
+	pending := make(map[string]struct{})
+
+	ticker := time.NewTicker(eventDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.Events():
+			if !ok {
+				return
+			}
+
+			if ev.Op&watcher.Overflow != 0 {
+				log.Println("File system events were lost, rescanning")
+				pending = make(map[string]struct{})
+				i.requestRescan()
+				continue
+			}
+
+			pending[ev.Path] = struct{}{}
+		case err, ok := <-w.Errors():
+			if ok {
+				log.Println(err)
+			}
+		case <-ticker.C:
+			for path := range pending {
+				if ctx.Err() != nil {
+					return
+				}
+				i.applyEvent(ctx, path)
+			}
+			pending = make(map[string]struct{})
+		}
+	}
+}
+
+// applyEvent brings the index up to date for a path that changed. What happened to the path
+// doesn't matter, if it still exists it is indexed and otherwise it is removed.
+func (i *Index) applyEvent(ctx context.Context, path string) {
+	dir := path[:strings.LastIndex(path, "/")]
+	if dir == "" || strings.HasSuffix(dir, ":") {
+		dir += "/"
+	}
+
+	// New rules can exclude what is indexed or include what was left out
+	if i.isIgnoreFile(path[strings.LastIndex(path, "/")+1:]) {
+		i.reloadIgnoreFiles(dir)
+		i.requestRescan()
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			i.removeTree(path)
+		}
+		return
+	}
+
+	root, depth, ok := i.rootFor(path)
+	if !ok || depth > root.MaxDepth && root.MaxDepth > 0 {
+		return
+	}
+
+	if strings.Contains(path, "OneDrive") {
+		return
+	}
+
+	if excluded, _ := i.excludes.Excluded(path, info.IsDir()); excluded {
+		return
+	}
+
+	entry := fs.FileInfoToDirEntry(info)
+
+	var dev uint64
+	if rootInfo, err := os.Stat(root.Path); err == nil {
+		dev, _ = deviceID(rootInfo)
+	}
+
+	if info.IsDir() && i.isSkippedMount(path, entry, dev) {
+		return
+	}
+
+	existed := i.ExistIndex(path)
+
+	f, err := indexMetadata(dir, entry)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := i.StoreIndex(path, *f); err != nil {
+		log.Println(err)
+		return
+	}
+
+	i.queueHash(*f, root)
+	i.indexContent(*f)
+	i.indexArchive(*f)
+
+	// A new or moved in directory has to be crawled, this also watches it
+	if info.IsDir() && !existed && (root.MaxDepth == 0 || depth < root.MaxDepth) {
+		c := &crawl{
+			ctx:  ctx,
+			root: root,
+			dev:  dev,
+		}
+
+		c.wg.Add(1)
+		i.findNewFiles(c, path, depth)
+		c.wg.Wait()
+	}
+}
+
+// reloadIgnoreFiles reads the rules of the ignore files in dir again after one of them changed
+func (i *Index) reloadIgnoreFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	i.loadIgnoreFiles(dir, entries)
+}
+
+// removeTree removes a path and everything below it from the index
+func (i *Index) removeTree(path string) {
+	file, err := i.GetIndex(path)
+	i.RemoveIndex(path)
+
+	if err == nil && file.IsDir {
+		for _, key := range i.below(path) {
+			i.RemoveIndex(key)
+		}
+	}
+
+	i.removeArchiveEntries(path, nil)
+}
+
+// below returns the keys of the files below a directory, found through the trigram index
+func (i *Index) below(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	var keys []string
+	candidates, narrowed := i.trigrams.Lookup(trigram.TextQuery(prefix))
+	if !narrowed {
+		i.FilesMap.Range(func(key, value interface{}) bool {
+			candidates = append(candidates, key.(string))
+			return true
+		})
+	}
+
+	for _, key := range candidates {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// rootFor returns the deepest root containing path and how many directories below it path is
+func (i *Index) rootFor(path string) (Root, int, bool) {
+	var best Root
+	found := false
+
+	for _, root := range i.getRoots() {
+		prefix := strings.TrimSuffix(root.Path, "/") + "/"
+		if path != root.Path && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		if !found || len(root.Path) > len(best.Path) {
+			best = root
+			found = true
+		}
+	}
+
+	if !found {
+		return Root{}, 0, false
+	}
+
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, strings.TrimSuffix(best.Path, "/")), "/")
+	if rel == "" {
+		return best, 0, true
+	}
+
+	return best, strings.Count(rel, "/") + 1, true
+}
@@ -0,0 +1,450 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is a query that can't be parsed, Pos is the byte offset of the problem
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query: position %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokPhrase
+	tokField
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind  tokenKind
+	pos   int
+	text  string // The word, phrase or field value
+	field string // Name of a field
+}
+
+// Parse parses a query. Terms next to each other must all match, AND, OR and NOT (or a "-"
+// in front of a term) combine them and parentheses group them. A blank query gives a nil Node.
+func Parse(q string) (Node, error) {
+	return ParseAt(q, time.Now())
+}
+
+// ParseAt parses a query with ages like modified:<7d counted back from now
+func ParseAt(q string, now time.Time) (Node, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, now: now}
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		if t.kind == tokRParen {
+			return nil, &Error{t.pos, `unexpected ")"`}
+		}
+		return nil, &Error{t.pos, "unexpected " + describe(t)}
+	}
+
+	return node, nil
+}
+
+func lex(q string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(q); {
+		c := q[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, pos: i})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, pos: i})
+			i++
+
+		case c == '"':
+			text, n, err := lexQuoted(q, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokPhrase, pos: i, text: text})
+			i += n
+
+		// A "-" in front of a term negates it, on its own it is a word
+		case c == '-' && i+1 < len(q) && !isSpace(q[i+1]) && q[i+1] != ')':
+			tokens = append(tokens, token{kind: tokNot, pos: i})
+			i++
+
+		default:
+			start := i
+			for i < len(q) && !isSpace(q[i]) && q[i] != '(' && q[i] != ')' && q[i] != '"' {
+				i++
+			}
+			word := q[start:i]
+
+			switch word {
+			case "AND":
+				tokens = append(tokens, token{kind: tokAnd, pos: start})
+				continue
+			case "OR":
+				tokens = append(tokens, token{kind: tokOr, pos: start})
+				continue
+			case "NOT":
+				tokens = append(tokens, token{kind: tokNot, pos: start})
+				continue
+			}
+
+			name, value, ok := strings.Cut(word, ":")
+			if !ok || name == "" || !isFieldName(name) {
+				tokens = append(tokens, token{kind: tokWord, pos: start, text: word})
+				continue
+			}
+
+			// The value of a field can be quoted, like path:"/my files"
+			if value == "" && i < len(q) && q[i] == '"' {
+				text, n, err := lexQuoted(q, i)
+				if err != nil {
+					return nil, err
+				}
+				value = text
+				i += n
+			}
+
+			tokens = append(tokens, token{kind: tokField, pos: start, field: strings.ToLower(name), text: value})
+		}
+	}
+
+	return tokens, nil
+}
+
+// lexQuoted reads the quoted text starting at q[start] and returns how many bytes it used
+func lexQuoted(q string, start int) (string, int, error) {
+	var b strings.Builder
+
+	for i := start + 1; i < len(q); i++ {
+		switch q[i] {
+		case '\\':
+			if i+1 < len(q) {
+				i++
+				b.WriteByte(q[i])
+			}
+		case '"':
+			return b.String(), i + 1 - start, nil
+		default:
+			b.WriteByte(q[i])
+		}
+	}
+
+	return "", 0, &Error{start, "missing closing quote"}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isFieldName reports whether name is a field, so words like "C:/Users", "http://x" and "todo:"
+// are still words
+func isFieldName(name string) bool {
+	_, ok := Fields[strings.ToLower(name)]
+	return ok
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokField:
+		return fmt.Sprintf("%q", t.field+":"+t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		end := 0
+		if len(p.tokens) > 0 {
+			end = p.tokens[len(p.tokens)-1].pos + 1
+		}
+		return token{kind: tokEOF, pos: end}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// or := and ("OR" and)*
+func (p *parser) or() (Node, error) {
+	node, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{node}
+	for p.peek().kind == tokOr {
+		p.next()
+
+		node, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &Or{Nodes: nodes}, nil
+}
+
+// and := unary (["AND"] unary)*
+func (p *parser) and() (Node, error) {
+	node, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{node}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokWord, tokPhrase, tokField, tokLParen, tokNot:
+		default:
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return &And{Nodes: nodes}, nil
+		}
+
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// unary := ("NOT" | "-") unary | primary
+func (p *parser) unary() (Node, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Node: node}, nil
+	}
+
+	return p.primary()
+}
+
+// primary := "(" or ")" | word | phrase | field
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokLParen:
+		if p.peek().kind == tokRParen {
+			return nil, &Error{t.pos, "empty parentheses"}
+		}
+
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if end := p.next(); end.kind != tokRParen {
+			return nil, &Error{t.pos, `missing ")"`}
+		}
+		return node, nil
+
+	case tokWord:
+		return &Term{Text: t.text}, nil
+
+	case tokPhrase:
+		return &Term{Text: t.text, Phrase: true}, nil
+
+	case tokField:
+		return p.field(t)
+	}
+
+	return nil, &Error{t.pos, "expected a term but found " + describe(t)}
+}
+
+// field parses the value of a field filter
+func (p *parser) field(t token) (Node, error) {
+	kind, ok := Fields[t.field]
+	if !ok {
+		return nil, &Error{t.pos, fmt.Sprintf("unknown field %q", t.field)}
+	}
+
+	f := &Field{Name: t.field}
+
+	value := t.text
+	for _, op := range []struct {
+		prefix string
+		op     Op
+	}{
+		{"<=", Le},
+		{">=", Ge},
+		{"<", Lt},
+		{">", Gt},
+		{"=", Eq},
+	} {
+		if strings.HasPrefix(value, op.prefix) {
+			f.Op = op.op
+			value = value[len(op.prefix):]
+			break
+		}
+	}
+	f.Value = value
+
+	fail := func(format string, args ...interface{}) (Node, error) {
+		return nil, &Error{t.pos, fmt.Sprintf("%s: ", t.field) + fmt.Sprintf(format, args...)}
+	}
+
+	if value == "" {
+		return fail("missing value")
+	}
+
+	if f.Op != Eq && (kind == Text || kind == Bool || kind == Type) {
+		return fail("%s can't be compared with %s", t.field, f.Op)
+	}
+
+	switch kind {
+	case Size:
+		size, err := ParseSize(value)
+		if err != nil {
+			return fail("%v", err)
+		}
+		f.Size = size
+
+	case Number:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fail("invalid number %q", value)
+		}
+		f.Number = n
+
+	case Bool:
+		switch strings.ToLower(value) {
+		case "true", "yes", "1":
+			f.Bool = true
+		case "false", "no", "0":
+		default:
+			return fail("expected true or false but found %q", value)
+		}
+
+	case Type:
+		switch strings.ToLower(value) {
+		case "dir", "directory", "folder", "d":
+			f.Bool = true
+		case "file", "f":
+		default:
+			return fail("expected file or dir but found %q", value)
+		}
+
+	case Time:
+		return p.timeField(f, fail)
+	}
+
+	return f, nil
+}
+
+// timeField parses an age or a date. An age compares the other way around than the time it
+// points at, modified:<7d means modified after 7 days ago. A date on its own matches the whole day.
+func (p *parser) timeField(f *Field, fail func(string, ...interface{}) (Node, error)) (Node, error) {
+	if age, err := ParseAge(f.Value); err == nil {
+		f.Time = p.now.Add(-age)
+
+		switch f.Op {
+		case Lt:
+			f.Op = Gt
+		case Le:
+			f.Op = Ge
+		case Gt:
+			f.Op = Lt
+		case Ge:
+			f.Op = Le
+		default:
+			// Within the age
+			f.Op = Ge
+		}
+
+		return f, nil
+	}
+
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, f.Value, p.now.Location())
+		if err != nil {
+			continue
+		}
+
+		f.Time = t
+
+		if f.Op == Eq {
+			if layout != "2006-01-02" {
+				return f, nil
+			}
+
+			// The whole day
+			end := *f
+			end.Op = Lt
+			end.Time = t.AddDate(0, 0, 1)
+			f.Op = Ge
+			return &And{Nodes: []Node{f, &end}}, nil
+		}
+
+		return f, nil
+	}
+
+	return fail("expected an age like 7d or a date like 2006-01-02 but found %q", f.Value)
+}
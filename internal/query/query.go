@@ -0,0 +1,217 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node is a parsed query, one of *And, *Or, *Not, *Term or *Field
+type Node interface {
+	String() string
+}
+
+// And matches when all of its nodes match
+type And struct {
+	Nodes []Node
+}
+
+// Or matches when any of its nodes match
+type Or struct {
+	Nodes []Node
+}
+
+// Not matches when its node doesn't
+type Not struct {
+	Node Node
+}
+
+// Term is free text that is looked for in the name, path, permissions and hashes
+type Term struct {
+	Text   string
+	Phrase bool // Written in quotes, spaces are part of the text
+}
+
+// Field filters on a property of a file, like ext:go or size:>10MB
+type Field struct {
+	Name  string
+	Op    Op
+	Value string // As written
+
+	Size   int64     // For size fields
+	Number uint64    // For number fields
+	Time   time.Time // For time fields, an age like 7d is the time it points at
+	Bool   bool      // For bool fields, and for type where true is a directory
+}
+
+func (n *And) String() string { return list("and", n.Nodes) }
+func (n *Or) String() string  { return list("or", n.Nodes) }
+func (n *Not) String() string { return "(not " + n.Node.String() + ")" }
+
+func (n *Term) String() string {
+	if n.Phrase {
+		return strconv.Quote(n.Text)
+	}
+	return n.Text
+}
+
+func (n *Field) String() string {
+	if !n.Time.IsZero() {
+		return n.Name + ":" + n.Op.String() + n.Time.Format(time.RFC3339)
+	}
+	return n.Name + ":" + n.Op.String() + n.Value
+}
+
+func list(name string, nodes []Node) string {
+	parts := make([]string, 0, len(nodes)+1)
+	parts = append(parts, name)
+	for _, n := range nodes {
+		parts = append(parts, n.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// Op compares a field to its value
+type Op int
+
+const (
+	Eq Op = iota
+	Lt
+	Le
+	Gt
+	Ge
+)
+
+func (op Op) String() string {
+	switch op {
+	case Lt:
+		return "<"
+	case Le:
+		return "<="
+	case Gt:
+		return ">"
+	case Ge:
+		return ">="
+	}
+	return ""
+}
+
+// Compare reports whether a op b, where cmp is -1, 0 or 1 as a is less, equal or greater than b
+func (op Op) Compare(cmp int) bool {
+	switch op {
+	case Lt:
+		return cmp < 0
+	case Le:
+		return cmp <= 0
+	case Gt:
+		return cmp > 0
+	case Ge:
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// Kind is the type of value a field takes
+type Kind int
+
+const (
+	Text   Kind = iota // Compared without regard to case, only ":" is allowed
+	Size               // A size like 100, 10KB or 1.5GB
+	Time               // An age like 7d or 12h, or a date like 2023-01-31
+	Bool               // true or false
+	Type               // file or dir
+	Number             // A whole number like 1000
+)
+
+// Fields are the fields that can be filtered on
+var Fields = map[string]Kind{
+	"ext":      Text,
+	"name":     Text,
+	"path":     Text,
+	"owner":    Text,
+	"group":    Text,
+	"type":     Type,
+	"hidden":   Bool,
+	"size":     Size,
+	"modified": Time,
+	"created":  Time,
+	"accessed": Time,
+	"content":  Text,
+	"hash":     Text,
+	"inode":    Number,
+	"device":   Number,
+	"links":    Number,
+	"uid":      Number,
+	"gid":      Number,
+	"sort":     Text,
+}
+
+// ParseSize parses a size like "512", "10KB", "1.5MB" or "2GiB" into bytes
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "IB")
+	str = strings.TrimSuffix(str, "B")
+
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"K", 1 << 10},
+		{"M", 1 << 20},
+		{"G", 1 << 30},
+		{"T", 1 << 40},
+	}
+
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSuffix(str, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(v * float64(mul)), nil
+}
+
+// ParseAge parses an age like "90s", "30m", "12h", "7d", "2w" or "1y"
+func ParseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	unit, ok := units[strings.ToLower(s[len(s)-1:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	v, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	return time.Duration(v * float64(unit)), nil
+}
+
+// Layouts of the dates that time fields take
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
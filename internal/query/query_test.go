@@ -0,0 +1,120 @@
+package query_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/query"
+)
+
+var now = time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"report", "report"},
+		{"annual report", "(and annual report)"},
+		{`"annual report"`, `"annual report"`},
+		{"a OR b c", "(or a (and b c))"},
+		{"a AND (b OR c)", "(and a (or b c))"},
+		{"NOT a", "(not a)"},
+		{"-a b", "(and (not a) b)"},
+		{"-(a OR b)", "(not (or a b))"},
+		{"a - b", "(and a - b)"},
+		{"or and not", "(and or and not)"},
+		{"C:/Users", "C:/Users"},
+		{"http://x", "http://x"},
+		{"todo: fix", "(and todo: fix)"},
+		{"note:x color:red", "(and note:x color:red)"},
+		{"ext:go", "ext:go"},
+		{"EXT:go", "ext:go"},
+		{`path:"/my files"`, "path:/my files"},
+		{"size:>10MB", "size:>10MB"},
+		{"links:>=2 uid:0", "(and links:>=2 uid:0)"},
+		{"type:dir hidden:true", "(and type:dir hidden:true)"},
+		{"modified:<7d", "modified:>2023-06-08T12:00:00Z"},
+		{"modified:2023-01-31", "(and modified:>=2023-01-31T00:00:00Z modified:<2023-02-01T00:00:00Z)"},
+		{"   ", "<nil>"},
+	}
+
+	for _, tt := range tests {
+		node, err := query.ParseAt(tt.q, now)
+		if err != nil {
+			t.Errorf("%q: %v", tt.q, err)
+			continue
+		}
+
+		got := "<nil>"
+		if node != nil {
+			got = node.String()
+		}
+
+		if got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.q, tt.want, got)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	node, err := query.ParseAt("size:>=1.5KB modified:<7d type:dir", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := node.(*query.And).Nodes
+
+	size := nodes[0].(*query.Field)
+	if size.Op != query.Ge || size.Size != 1536 {
+		t.Errorf("Unexpected size field %+v", size)
+	}
+
+	modified := nodes[1].(*query.Field)
+	if modified.Op != query.Gt || !modified.Time.Equal(now.Add(-7*24*time.Hour)) {
+		t.Errorf("Unexpected modified field %+v", modified)
+	}
+
+	if typ := nodes[2].(*query.Field); !typ.Bool {
+		t.Errorf("Expected type:dir to be true")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		q   string
+		msg string
+	}{
+		{`"unclosed`, "missing closing quote"},
+		{"(a OR b", `missing ")"`},
+		{"a)", `unexpected ")"`},
+		{"()", "empty parentheses"},
+		{"a OR", "expected a term"},
+		{"AND a", "expected a term"},
+		{"NOT", "expected a term"},
+		{"size:big", "invalid size"},
+		{"inode:-1", "invalid number"},
+		{"size:", "missing value"},
+		{"ext:>go", "can't be compared"},
+		{"hidden:maybe", "expected true or false"},
+		{"type:link", "expected file or dir"},
+		{"modified:soon", "expected an age"},
+	}
+
+	for _, tt := range tests {
+		_, err := query.ParseAt(tt.q, now)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.q)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%q: expected an error containing %q, got %q", tt.q, tt.msg, err)
+		}
+
+		if _, ok := err.(*query.Error); !ok {
+			t.Errorf("%q: expected a *query.Error, got %T", tt.q, err)
+		}
+	}
+}
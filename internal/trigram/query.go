@@ -0,0 +1,214 @@
+package trigram
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
+
+// Op is the kind of a Query
+type Op int
+
+const (
+	All  Op = iota // Every key, the query can't narrow anything down
+	None           // No key
+	Text           // Keys that have all trigrams of the text
+	And            // Keys that match every sub query
+	Or             // Keys that match any sub query
+	Keys           // The keys in the list, found some other way
+)
+
+// Query describes which keys a search can match. It may match more keys than the search,
+// never fewer.
+type Query struct {
+	Op   Op
+	Text string
+	Sub  []*Query
+	Keys []string
+}
+
+func (q *Query) String() string {
+	switch q.Op {
+	case None:
+		return "none"
+	case Keys:
+		return fmt.Sprintf("(keys %d)", len(q.Keys))
+	case Text:
+		return `"` + strings.ToLower(q.Text) + `"`
+	case And, Or:
+		name := "and"
+		if q.Op == Or {
+			name = "or"
+		}
+		parts := []string{name}
+		for _, sub := range q.Sub {
+			parts = append(parts, sub.String())
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	}
+	return "all"
+}
+
+// AllQuery matches every key
+func AllQuery() *Query {
+	return &Query{Op: All}
+}
+
+// TextQuery matches the keys that contain text, without regard to case. Text shorter than a
+// trigram matches every key.
+func TextQuery(text string) *Query {
+	if len(text) < 3 {
+		return AllQuery()
+	}
+	return &Query{Op: Text, Text: text}
+}
+
+// KeysQuery matches the keys in the list
+func KeysQuery(keys []string) *Query {
+	return &Query{Op: Keys, Keys: keys}
+}
+
+// AndQuery matches the keys that match every query
+func AndQuery(qs ...*Query) *Query {
+	var sub []*Query
+	for _, q := range qs {
+		switch q.Op {
+		case All:
+			continue
+		case None:
+			return q
+		case And:
+			sub = append(sub, q.Sub...)
+		default:
+			sub = append(sub, q)
+		}
+	}
+
+	switch len(sub) {
+	case 0:
+		return AllQuery()
+	case 1:
+		return sub[0]
+	}
+	return &Query{Op: And, Sub: sub}
+}
+
+// OrQuery matches the keys that match any query
+func OrQuery(qs ...*Query) *Query {
+	var sub []*Query
+	for _, q := range qs {
+		switch q.Op {
+		case All:
+			return q
+		case None:
+			continue
+		case Or:
+			sub = append(sub, q.Sub...)
+		default:
+			sub = append(sub, q)
+		}
+	}
+
+	switch len(sub) {
+	case 0:
+		return &Query{Op: None}
+	case 1:
+		return sub[0]
+	}
+	return &Query{Op: Or, Sub: sub}
+}
+
+// GlobQuery returns the query for a glob, the runs of plain characters must all be there
+func GlobQuery(glob string) *Query {
+	var qs []*Query
+	var run strings.Builder
+
+	flush := func() {
+		qs = append(qs, TextQuery(run.String()))
+		run.Reset()
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*', '?':
+			flush()
+		case '[':
+			// A class is one of several characters, skip to the end of it
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				run.WriteByte(c)
+				continue
+			}
+			if end == 0 {
+				// "[]...]" has "]" in the class
+				if next := strings.IndexByte(glob[i+2:], ']'); next >= 0 {
+					end = next + 1
+				}
+			}
+			flush()
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				run.WriteByte(glob[i])
+			}
+		default:
+			run.WriteByte(c)
+		}
+	}
+	flush()
+
+	return AndQuery(qs...)
+}
+
+// RegexpQuery returns the query for an RE2 regular expression from the literal text that
+// every match has to contain
+func RegexpQuery(expr string) *Query {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return AllQuery()
+	}
+	return regexpQuery(re.Simplify())
+}
+
+func regexpQuery(re *syntax.Regexp) *Query {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return &Query{Op: None}
+
+	case syntax.OpLiteral:
+		return TextQuery(string(re.Rune))
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return regexpQuery(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return regexpQuery(re.Sub[0])
+		}
+
+	case syntax.OpConcat:
+		// Literals next to each other are one run of text
+		var qs []*Query
+		var run []rune
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				run = append(run, sub.Rune...)
+				continue
+			}
+			qs = append(qs, TextQuery(string(run)), regexpQuery(sub))
+			run = nil
+		}
+		qs = append(qs, TextQuery(string(run)))
+		return AndQuery(qs...)
+
+	case syntax.OpAlternate:
+		qs := make([]*Query, 0, len(re.Sub))
+		for _, sub := range re.Sub {
+			qs = append(qs, regexpQuery(sub))
+		}
+		return OrQuery(qs...)
+	}
+
+	return AllQuery()
+}
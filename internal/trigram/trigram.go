@@ -0,0 +1,262 @@
+package trigram
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Index finds keys by the trigrams, the runs of three bytes, in their lower case text. Every
+// key gets an id and each trigram has a posting list of the ids of the keys that have it, in
+// increasing order so lists can be intersected and merged in one pass.
+type Index struct {
+	lock     sync.RWMutex
+	ids      map[string]uint32
+	keys     []string // By id, empty once removed
+	removed  int
+	postings map[uint32][]uint32
+}
+
+// New returns an empty index
+func New() *Index {
+	return &Index{
+		ids:      make(map[string]uint32),
+		postings: make(map[uint32][]uint32),
+	}
+}
+
+// Len returns the number of keys in the index
+func (x *Index) Len() int {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	return len(x.ids)
+}
+
+// Add adds a key, adding one that is already there does nothing
+func (x *Index) Add(key string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if _, ok := x.ids[key]; ok {
+		return
+	}
+
+	// New ids are always the highest so appending keeps the lists in order
+	id := uint32(len(x.keys))
+	x.ids[key] = id
+	x.keys = append(x.keys, key)
+
+	for _, t := range trigrams(key) {
+		x.postings[t] = append(x.postings[t], id)
+	}
+}
+
+// Remove removes a key
+func (x *Index) Remove(key string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	id, ok := x.ids[key]
+	if !ok {
+		return
+	}
+
+	delete(x.ids, key)
+	x.keys[id] = ""
+	x.removed++
+
+	for _, t := range trigrams(key) {
+		list := x.postings[t]
+		n := sort.Search(len(list), func(i int) bool { return list[i] >= id })
+		if n < len(list) && list[n] == id {
+			list = append(list[:n], list[n+1:]...)
+		}
+
+		if len(list) == 0 {
+			delete(x.postings, t)
+		} else {
+			x.postings[t] = list
+		}
+	}
+
+	// Ids are not reused, once most of them are gone they are handed out again from 0
+	if x.removed > 1024 && x.removed > len(x.keys)/2 {
+		x.compact()
+	}
+}
+
+// compact gives the keys that are left new ids in the same order, so the lists stay sorted
+func (x *Index) compact() {
+	remap := make([]uint32, len(x.keys))
+	keys := make([]string, 0, len(x.ids))
+
+	for id, key := range x.keys {
+		if key == "" {
+			continue
+		}
+		remap[id] = uint32(len(keys))
+		x.ids[key] = uint32(len(keys))
+		keys = append(keys, key)
+	}
+
+	for t, list := range x.postings {
+		for n, id := range list {
+			list[n] = remap[id]
+		}
+		x.postings[t] = list
+	}
+
+	x.keys = keys
+	x.removed = 0
+}
+
+// Lookup returns the keys that can match the query. It returns false when the query can't
+// narrow anything down and every key has to be checked.
+func (x *Index) Lookup(q *Query) ([]string, bool) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	ids, ok := x.eval(q)
+	if !ok {
+		return nil, false
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, x.keys[id])
+	}
+
+	return keys, true
+}
+
+// eval returns the ids that can match the query, or false when it matches everything
+func (x *Index) eval(q *Query) ([]uint32, bool) {
+	switch q.Op {
+	case None:
+		return nil, true
+
+	case Keys:
+		ids := make([]uint32, 0, len(q.Keys))
+		for _, key := range q.Keys {
+			if id, ok := x.ids[key]; ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+		return ids, true
+
+	case Text:
+		var ids []uint32
+		ok := false
+		for _, t := range trigrams(q.Text) {
+			list := x.postings[t]
+			if !ok {
+				ids, ok = list, true
+			} else {
+				ids = intersect(ids, list)
+			}
+			if len(ids) == 0 {
+				break
+			}
+		}
+		return ids, ok
+
+	case And:
+		var ids []uint32
+		ok := false
+		for _, sub := range q.Sub {
+			list, subOk := x.eval(sub)
+			if !subOk {
+				continue
+			}
+			if !ok {
+				ids, ok = list, true
+			} else {
+				ids = intersect(ids, list)
+			}
+		}
+		return ids, ok
+
+	case Or:
+		var ids []uint32
+		for _, sub := range q.Sub {
+			list, ok := x.eval(sub)
+			if !ok {
+				return nil, false
+			}
+			ids = union(ids, list)
+		}
+		return ids, true
+	}
+
+	return nil, false
+}
+
+// trigrams returns the distinct trigrams of the lower case text
+func trigrams(text string) []uint32 {
+	text = strings.ToLower(text)
+	if len(text) < 3 {
+		return nil
+	}
+
+	seen := make(map[uint32]struct{}, len(text)-2)
+	list := make([]uint32, 0, len(text)-2)
+	for i := 0; i+3 <= len(text); i++ {
+		t := uint32(text[i])<<16 | uint32(text[i+1])<<8 | uint32(text[i+2])
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		list = append(list, t)
+	}
+
+	return list
+}
+
+// intersect returns the ids in both sorted lists, in a new list
+func intersect(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// union returns the ids in either sorted list, in a new list
+func union(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,113 @@
+package trigram_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/TechMDW/indexing/internal/trigram"
+)
+
+func lookup(t *testing.T, x *trigram.Index, q *trigram.Query) string {
+	t.Helper()
+
+	keys, ok := x.Lookup(q)
+	if !ok {
+		return "all"
+	}
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+func TestLookup(t *testing.T) {
+	x := trigram.New()
+	for _, key := range []string{"/home/alice/Report.pdf", "/home/alice/video.mp4", "/srv/app/main.go", "/srv/app/old.go"} {
+		x.Add(key)
+	}
+	x.Add("/srv/app/main.go")
+
+	if x.Len() != 4 {
+		t.Fatalf("Expected 4 keys, got %d", x.Len())
+	}
+
+	tests := []struct {
+		q    *trigram.Query
+		want string
+	}{
+		{trigram.TextQuery("report"), "[/home/alice/Report.pdf]"},
+		{trigram.TextQuery(".go"), "[/srv/app/main.go /srv/app/old.go]"},
+		{trigram.TextQuery("go"), "all"},
+		{trigram.TextQuery("nothing"), "[]"},
+		{trigram.AndQuery(trigram.TextQuery("alice"), trigram.TextQuery(".mp4")), "[/home/alice/video.mp4]"},
+		{trigram.AndQuery(trigram.TextQuery("alice"), trigram.AllQuery()), "[/home/alice/Report.pdf /home/alice/video.mp4]"},
+		{trigram.OrQuery(trigram.TextQuery("main"), trigram.TextQuery("video")), "[/home/alice/video.mp4 /srv/app/main.go]"},
+		{trigram.OrQuery(trigram.TextQuery("main"), trigram.AllQuery()), "all"},
+	}
+
+	for _, tt := range tests {
+		if got := lookup(t, x, tt.q); got != tt.want {
+			t.Errorf("%v: expected %s, got %s", tt.q, tt.want, got)
+		}
+	}
+
+	x.Remove("/srv/app/main.go")
+	if got := lookup(t, x, trigram.TextQuery(".go")); got != "[/srv/app/old.go]" {
+		t.Errorf("Expected the removed key to be gone, got %s", got)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	x := trigram.New()
+	for n := 0; n < 5000; n++ {
+		x.Add(fmt.Sprintf("/data/file%04d.txt", n))
+	}
+
+	// Enough to hand out the ids again
+	for n := 0; n < 5000; n += 2 {
+		x.Remove(fmt.Sprintf("/data/file%04d.txt", n))
+	}
+	for n := 0; n < 5000; n += 4 {
+		x.Add(fmt.Sprintf("/data/file%04d.txt", n))
+	}
+
+	if got := lookup(t, x, trigram.TextQuery("file0002")); got != "[]" {
+		t.Errorf("Expected no file0002, got %s", got)
+	}
+	if got := lookup(t, x, trigram.TextQuery("file0004")); got != "[/data/file0004.txt]" {
+		t.Errorf("Expected file0004, got %s", got)
+	}
+	if got := lookup(t, x, trigram.TextQuery("file4999")); got != "[/data/file4999.txt]" {
+		t.Errorf("Expected file4999, got %s", got)
+	}
+
+	keys, _ := x.Lookup(trigram.TextQuery(".txt"))
+	if len(keys) != x.Len() || x.Len() != 3750 {
+		t.Errorf("Expected 3750 keys, got %d of %d", len(keys), x.Len())
+	}
+}
+
+func TestPatternQuery(t *testing.T) {
+	tests := []struct {
+		q    *trigram.Query
+		want string
+	}{
+		{trigram.GlobQuery("*.tar.gz"), `".tar.gz"`},
+		{trigram.GlobQuery("**/testdata/*.json"), `(and "/testdata/" ".json")`},
+		{trigram.GlobQuery("a*b"), "all"},
+		{trigram.GlobQuery("rep[aeiou]rt*"), `"rep"`},
+		{trigram.GlobQuery("report[0-9].pdf"), `(and "report" ".pdf")`},
+		{trigram.GlobQuery(`file\*name`), `"file*name"`},
+		{trigram.RegexpQuery(`^report.*\.pdf$`), `(and "report" ".pdf")`},
+		{trigram.RegexpQuery(`(main|video)\.mp4`), `(and (or "main" "video") ".mp4")`},
+		{trigram.RegexpQuery(`(main|go)`), "all"},
+		{trigram.RegexpQuery(`(?i)README`), `"readme"`},
+		{trigram.RegexpQuery(`x+abc`), `"abc"`},
+		{trigram.RegexpQuery(`(`), "all"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.q.String(); got != tt.want {
+			t.Errorf("Expected %s, got %s", tt.want, got)
+		}
+	}
+}
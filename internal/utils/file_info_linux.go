@@ -6,34 +6,22 @@ package utils
 import (
 	"fmt"
 	"os"
-	"os/user"
-
-	"golang.org/x/sys/unix"
+	"syscall"
 )
 
+// GetOwnerAndGroup returns the names of the user and group owning an open file, or their
+// ids when they have no name
 func GetOwnerAndGroup(file *os.File) (string, string, error) {
 	fi, err := file.Stat()
 	if err != nil {
 		return "", "", err
 	}
 
-	stat, ok := fi.Sys().(*unix.Stat_t)
+	// os returns the Stat_t of syscall, not the one of x/sys/unix
+	stat, ok := fi.Sys().(*syscall.Stat_t)
 	if !ok {
-		return "", "", fmt.Errorf("Not a unix.Stat_t")
-	}
-
-	uid := fmt.Sprintf("%d", stat.Uid)
-	gid := fmt.Sprintf("%d", stat.Gid)
-
-	u, err := user.LookupId(uid)
-	if err != nil {
-		return "", "", err
-	}
-
-	g, err := user.LookupGroupId(gid)
-	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("Not a syscall.Stat_t")
 	}
 
-	return u.Username, g.Name, nil
+	return UserName(stat.Uid), GroupName(stat.Gid), nil
 }
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// Names of users and groups by their id. Looking one up can go to the network with LDAP
+// or NIS, and the same few ids own most files, so each id is only looked up once.
+var userNames, groupNames sync.Map
+
+// UserName returns the name of the user with the uid, or the uid when it has no name
+func UserName(uid uint32) string {
+	return lookupName(&userNames, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+// GroupName returns the name of the group with the gid, or the gid when it has no name
+func GroupName(gid uint32) string {
+	return lookupName(&groupNames, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+func lookupName(cache *sync.Map, id uint32, lookup func(string) (string, error)) string {
+	if name, ok := cache.Load(id); ok {
+		return name.(string)
+	}
+
+	s := strconv.FormatUint(uint64(id), 10)
+	name, err := lookup(s)
+	if err != nil || name == "" {
+		// Ids without a name are cached too, so they aren't looked up for every file
+		name = s
+	}
+
+	cache.Store(id, name)
+	return name
+}
@@ -0,0 +1,273 @@
+//go:build linux
+// +build linux
+
+package watcher
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW
+
+// inotify watches directories with inotify(7). Directories that can't be watched, usually
+// because max_user_watches is reached, are handed to a poller instead, and so is every
+// directory once reading the events keeps failing.
+type inotify struct {
+	fd       int
+	file     *os.File
+	lock     sync.Mutex
+	watches  map[string]int
+	paths    map[int]string
+	fallback *Poller
+	failed   bool // Reading the events failed for good, everything is polled
+	events   chan Event
+	errors   chan error
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+func newNative(pollInterval time.Duration) (Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[string]int),
+		paths:   make(map[int]string),
+		events:  make(chan Event, 128),
+		errors:  make(chan error, 16),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	w.fallback = newPoller(pollInterval, w.events, w.errors)
+
+	go w.run()
+
+	return w, nil
+}
+
+func (w *inotify) Add(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.watches[path]; ok {
+		return nil
+	}
+
+	if w.failed {
+		return w.fallback.Add(path)
+	}
+
+	wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			return w.fallback.Add(path)
+		}
+		return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+
+	w.watches[path] = wd
+	w.paths[wd] = path
+
+	return nil
+}
+
+func (w *inotify) Remove(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	wd, ok := w.watches[path]
+	if !ok {
+		return w.fallback.Remove(path)
+	}
+
+	delete(w.watches, path)
+	delete(w.paths, wd)
+
+	// The watch is already gone if the directory was removed
+	unix.InotifyRmWatch(w.fd, uint32(wd))
+
+	return nil
+}
+
+func (w *inotify) Events() <-chan Event {
+	return w.events
+}
+
+func (w *inotify) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *inotify) Close() error {
+	var err error
+
+	w.once.Do(func() {
+		close(w.done)
+		w.fallback.Close()
+
+		// Unblocks the read in run
+		err = w.file.Close()
+		<-w.stopped
+
+		close(w.events)
+		close(w.errors)
+	})
+
+	return err
+}
+
+// How many reads in a row may fail before the directories are handed to the poller
+const maxReadErrors = 5
+
+func (w *inotify) run() {
+	defer close(w.stopped)
+
+	buf := make([]byte, 64*1024)
+	failures := 0
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+
+			w.sendError(err)
+
+			failures++
+			if failures >= maxReadErrors {
+				w.giveUp()
+				return
+			}
+
+			// An error that doesn't go away would keep the loop busy
+			select {
+			case <-w.done:
+				return
+			case <-time.After(time.Duration(failures) * 100 * time.Millisecond):
+				continue
+			}
+		}
+		failures = 0
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			offset = nameEnd
+
+			var name string
+			if raw.Len > 0 && nameEnd <= n {
+				name = cString(buf[nameStart:nameEnd])
+			}
+
+			ev, ok := w.event(int(raw.Wd), raw.Mask, name)
+			if !ok {
+				continue
+			}
+
+			select {
+			case w.events <- ev:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// giveUp hands every directory to the poller after reading the events failed for good. Events
+// were lost on the way, so everything has to be rescanned.
+func (w *inotify) giveUp() {
+	w.lock.Lock()
+	w.failed = true
+	for path, wd := range w.watches {
+		if err := w.fallback.Add(path); err != nil {
+			w.sendError(err)
+		}
+		unix.InotifyRmWatch(w.fd, uint32(wd))
+	}
+	w.watches = make(map[string]int)
+	w.paths = make(map[int]string)
+	w.lock.Unlock()
+
+	select {
+	case w.events <- Event{Op: Overflow}:
+	case <-w.done:
+	}
+}
+
+func (w *inotify) sendError(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
+
+// event converts an inotify event, it returns false for events that should not be sent
+func (w *inotify) event(wd int, mask uint32, name string) (Event, bool) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		return Event{Op: Overflow}, true
+	}
+
+	w.lock.Lock()
+	dir, ok := w.paths[wd]
+	if mask&unix.IN_IGNORED != 0 && ok {
+		delete(w.paths, wd)
+		if w.watches[dir] == wd {
+			delete(w.watches, dir)
+		}
+	}
+	w.lock.Unlock()
+
+	if !ok {
+		return Event{}, false
+	}
+
+	ev := Event{
+		Path:  dir,
+		IsDir: mask&unix.IN_ISDIR != 0,
+	}
+	if name != "" {
+		ev.Path = joinPath(dir, name)
+	}
+
+	switch {
+	case mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+		// The parent reports these as well, as long as it is watched
+		ev.Op = Remove
+		ev.IsDir = true
+	case mask&unix.IN_CREATE != 0, mask&unix.IN_MOVED_TO != 0:
+		ev.Op = Create
+	case mask&unix.IN_DELETE != 0:
+		ev.Op = Remove
+	case mask&unix.IN_MOVED_FROM != 0:
+		ev.Op = Rename
+	case mask&(unix.IN_CLOSE_WRITE|unix.IN_MODIFY|unix.IN_ATTRIB) != 0:
+		ev.Op = Write
+	default:
+		return Event{}, false
+	}
+
+	return ev, true
+}
+
+// cString returns the NUL padded name of an inotify event
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package watcher
+
+import (
+	"errors"
+	"time"
+)
+
+func newNative(pollInterval time.Duration) (Watcher, error) {
+	return nil, errors.New("no native watcher on this platform")
+}
@@ -0,0 +1,229 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Poller is a Watcher that lists the watched directories every interval and reports the differences.
+// Every pass lists every directory, so with many of them it waits longer between passes to spend
+// at most a tenth of the time listing.
+type Poller struct {
+	interval time.Duration
+	lock     sync.Mutex
+	dirs     map[string]map[string]entry
+	events   chan Event
+	errors   chan error
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+
+	// Whether Close closes the channels, they are shared when the poller is a fallback
+	ownsChannels bool
+}
+
+type entry struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// NewPoller returns a Watcher that works everywhere but only notices changes every interval
+func NewPoller(interval time.Duration) *Poller {
+	p := newPoller(interval, make(chan Event, 128), make(chan error, 16))
+	p.ownsChannels = true
+	return p
+}
+
+func newPoller(interval time.Duration, events chan Event, errors chan error) *Poller {
+	p := &Poller{
+		interval: interval,
+		dirs:     make(map[string]map[string]entry),
+		events:   events,
+		errors:   errors,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+func (p *Poller) Add(path string) error {
+	snapshot, err := list(path)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.dirs[path]; !ok {
+		p.dirs[path] = snapshot
+	}
+
+	return nil
+}
+
+func (p *Poller) Remove(path string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.dirs, path)
+
+	return nil
+}
+
+func (p *Poller) Events() <-chan Event {
+	return p.events
+}
+
+func (p *Poller) Errors() <-chan error {
+	return p.errors
+}
+
+func (p *Poller) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		<-p.stopped
+
+		if p.ownsChannels {
+			close(p.events)
+			close(p.errors)
+		}
+	})
+
+	return nil
+}
+
+// How many times as long as a pass took the poller waits before the next one at least
+const pollPause = 9
+
+func (p *Poller) run() {
+	defer close(p.stopped)
+
+	timer := time.NewTimer(p.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-timer.C:
+			start := time.Now()
+			p.poll()
+
+			wait := time.Since(start) * pollPause
+			if wait < p.interval {
+				wait = p.interval
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+// poll lists every watched directory once and sends the differences
+func (p *Poller) poll() {
+	p.lock.Lock()
+	dirs := make([]string, 0, len(p.dirs))
+	for dir := range p.dirs {
+		dirs = append(dirs, dir)
+	}
+	p.lock.Unlock()
+
+	for _, dir := range dirs {
+		snapshot, err := list(dir)
+
+		p.lock.Lock()
+		old, ok := p.dirs[dir]
+		if ok {
+			if err != nil {
+				delete(p.dirs, dir)
+			} else {
+				p.dirs[dir] = snapshot
+			}
+		}
+		p.lock.Unlock()
+
+		// Removed while we were listing
+		if !ok {
+			continue
+		}
+
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if !p.send(Event{Path: dir, Op: Remove, IsDir: true}) {
+					return
+				}
+			} else {
+				p.sendError(err)
+			}
+			continue
+		}
+
+		for name, e := range snapshot {
+			o, ok := old[name]
+			switch {
+			case !ok:
+				if !p.send(Event{Path: joinPath(dir, name), Op: Create, IsDir: e.isDir}) {
+					return
+				}
+			case !e.isDir && (!o.modTime.Equal(e.modTime) || o.size != e.size):
+				if !p.send(Event{Path: joinPath(dir, name), Op: Write}) {
+					return
+				}
+			}
+		}
+
+		for name, o := range old {
+			if _, ok := snapshot[name]; !ok {
+				if !p.send(Event{Path: joinPath(dir, name), Op: Remove, IsDir: o.isDir}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Poller) send(ev Event) bool {
+	select {
+	case p.events <- ev:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+func (p *Poller) sendError(err error) {
+	select {
+	case p.errors <- err:
+	default:
+	}
+}
+
+// list returns the entries of a directory
+func list(dir string) (map[string]entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]entry, len(files))
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		snapshot[file.Name()] = entry{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			isDir:   file.IsDir(),
+		}
+	}
+
+	return snapshot, nil
+}
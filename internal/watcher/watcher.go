@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Op describes what happened to a path
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename // The path was moved away, the new path gets a Create
+	Overflow
+)
+
+func (op Op) String() string {
+	var ops []string
+	for _, o := range []struct {
+		op   Op
+		name string
+	}{
+		{Create, "CREATE"},
+		{Write, "WRITE"},
+		{Remove, "REMOVE"},
+		{Rename, "RENAME"},
+		{Overflow, "OVERFLOW"},
+	} {
+		if op&o.op != 0 {
+			ops = append(ops, o.name)
+		}
+	}
+
+	if len(ops) == 0 {
+		return "NONE"
+	}
+
+	return strings.Join(ops, "|")
+}
+
+// Event is a change to a file or directory in a watched directory.
+//
+// Overflow events have no path, they mean events were lost and everything should be rescanned.
+type Event struct {
+	Path  string
+	Op    Op
+	IsDir bool
+}
+
+var ErrClosed = errors.New("watcher closed")
+
+// Watcher reports changes to the entries of watched directories, it does not recurse on its own
+type Watcher interface {
+	// Add starts watching a directory
+	Add(path string) error
+
+	// Remove stops watching a directory
+	Remove(path string) error
+
+	Events() <-chan Event
+
+	Errors() <-chan error
+
+	Close() error
+}
+
+// New returns the best watcher for the platform, inotify on Linux and polling everywhere
+// else. pollInterval is used by the polling watcher.
+func New(pollInterval time.Duration) (Watcher, error) {
+	w, err := newNative(pollInterval)
+	if err == nil {
+		return w, nil
+	}
+
+	return NewPoller(pollInterval), nil
+}
+
+// joinPath joins a directory and a name without doubling the separator of roots like "/"
+func joinPath(dir, name string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + name
+}
@@ -0,0 +1,83 @@
+package watcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechMDW/indexing/internal/watcher"
+)
+
+func waitForEvent(t *testing.T, w watcher.Watcher, path string, op watcher.Op) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-w.Events():
+			if ev.Path == path && ev.Op&op != 0 {
+				return
+			}
+		case err := <-w.Errors():
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %s on %s", op, path)
+		}
+	}
+}
+
+func testWatcher(t *testing.T, w watcher.Watcher) {
+	defer w.Close()
+
+	dir := filepath.ToSlash(t.TempDir())
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	file := dir + "/file.txt"
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, file, watcher.Create)
+
+	// Make sure the poller sees a different size
+	if err := os.WriteFile(file, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, file, watcher.Write)
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, file, watcher.Remove)
+
+	sub := dir + "/sub"
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, sub, watcher.Create)
+}
+
+func TestPoller(t *testing.T) {
+	testWatcher(t, watcher.NewPoller(20*time.Millisecond))
+}
+
+func TestNew(t *testing.T) {
+	w, err := watcher.New(20 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testWatcher(t, w)
+}
+
+func TestCloseTwice(t *testing.T) {
+	w, err := watcher.New(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Close()
+	w.Close()
+}
@@ -49,7 +49,7 @@ document.addEventListener("astilectron-ready", function () {
 
       const resultTitle = document.createElement("span");
       resultTitle.classList.add("title");
-      resultTitle.innerText = result.name;
+      appendHighlighted(resultTitle, result.name, result.Internal_metadata.Positions);
 
       const resultPath = document.createElement("span");
       resultPath.classList.add("path");
@@ -58,11 +58,50 @@ document.addEventListener("astilectron-ready", function () {
       resultDiv.appendChild(resultTitle);
       resultDiv.appendChild(resultPath);
 
+      for (const snippet of result.Internal_metadata.Snippets || []) {
+        const resultSnippet = document.createElement("span");
+        resultSnippet.classList.add("snippet");
+        resultSnippet.innerText = `${snippet.line}: ${snippet.text}`;
+        resultDiv.appendChild(resultSnippet);
+      }
+
       document.getElementById("search-results").appendChild(resultDiv);
     }
   });
 });
 
+// Adds the name with the characters the query matched in <mark>
+function appendHighlighted(element, name, positions) {
+  const matched = new Set(positions || []);
+  const chars = Array.from(name);
+
+  let text = "";
+  let marked = false;
+  const flush = () => {
+    if (!text) {
+      return;
+    }
+
+    if (marked) {
+      const mark = document.createElement("mark");
+      mark.innerText = text;
+      element.appendChild(mark);
+    } else {
+      element.appendChild(document.createTextNode(text));
+    }
+    text = "";
+  };
+
+  for (let i = 0; i < chars.length; i++) {
+    if (matched.has(i) !== marked) {
+      flush();
+      marked = !marked;
+    }
+    text += chars[i];
+  }
+  flush();
+}
+
 function sendQuery(query) {
   astilectron.sendMessage(query, () => {});
 }